@@ -20,18 +20,29 @@ var ImplicitExcludes = []string{
 }
 
 func readExcludes(dir string) ([]string, error) {
+	excludes, err := ReadEarthIgnore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return append(excludes, ImplicitExcludes...), nil
+}
+
+// ReadEarthIgnore returns the patterns of the earthignore file of the given directory, if
+// any, without the ImplicitExcludes.
+func ReadEarthIgnore(dir string) ([]string, error) {
 	filePath := filepath.Join(dir, EarthIgnoreFile)
 	f, err := os.Open(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// No earthignore file present.
-			return ImplicitExcludes, nil
+			return nil, nil
 		}
 		return nil, errors.Wrapf(err, "read %s", filePath)
 	}
+	defer f.Close()
 	excludes, err := dockerignore.ReadAll(f)
 	if err != nil {
 		return nil, errors.Wrapf(err, "parse %s", filePath)
 	}
-	return append(excludes, ImplicitExcludes...), nil
+	return excludes, nil
 }
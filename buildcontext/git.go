@@ -40,6 +40,8 @@ type resolvedGitProject struct {
 	branches []string
 	// tags is the git tags
 	tags []string
+	// timestamp is the unix timestamp of the git commit.
+	timestamp string
 	// state is the state holding the git files.
 	state llb.State
 }
@@ -103,6 +105,7 @@ func (gr *gitResolver) resolveEarthProject(ctx context.Context, gwClient gwclien
 			Hash:      rgp.hash,
 			Branch:    rgp.branches,
 			Tags:      rgp.tags,
+			Timestamp: rgp.timestamp,
 		},
 	}, nil
 }
@@ -158,7 +161,8 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 			"/bin/sh", "-c",
 			"git rev-parse HEAD >/dest/git-hash ; " +
 				"git rev-parse --abbrev-ref HEAD >/dest/git-branch  || touch /dest/git-branch ; " +
-				"git describe --exact-match --tags >/dest/git-tags || touch /dest/git-tags",
+				"git describe --exact-match --tags >/dest/git-tags || touch /dest/git-tags ; " +
+				"git log -1 --format=%ct >/dest/git-timestamp || touch /dest/git-timestamp",
 		}),
 		llb.Dir("/git-src"),
 		llb.ReadonlyRootFS(),
@@ -190,8 +194,15 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 	if err != nil {
 		return nil, "", "", errors.Wrap(err, "read git-tags")
 	}
+	gitTimestampBytes, err := gitMetaAndEarthfileRef.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: "git-timestamp",
+	})
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "read git-timestamp")
+	}
 
 	gitHash := strings.SplitN(string(gitHashBytes), "\n", 2)[0]
+	gitTimestamp := strings.SplitN(string(gitTimestampBytes), "\n", 2)[0]
 	gitBranches := strings.SplitN(string(gitBranchBytes), "\n", 2)
 	var gitBranches2 []string
 	for _, gitBranch := range gitBranches {
@@ -220,6 +231,7 @@ func (gr *gitResolver) resolveGitProject(ctx context.Context, gwClient gwclient.
 		hash:                     gitHash,
 		branches:                 gitBranches2,
 		tags:                     gitTags2,
+		timestamp:                gitTimestamp,
 		state: llb.Git(
 			gitURL,
 			gitHash,
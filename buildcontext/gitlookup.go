@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/earthly/earthly/fileutil"
+	"github.com/earthly/earthly/gitutil"
 
 	"github.com/pkg/errors"
 )
@@ -23,14 +24,29 @@ type gitMatcher struct {
 	protocol string
 	password string
 	keyScan  string
+	// hostKeys, if set, is used to obtain the host keys of the site when no keyScan has
+	// been configured. It is only called once a git URL resolves to the site.
+	hostKeys HostKeysFunc
 }
 
 // GitLookup looksup gits
 type GitLookup struct {
 	matchers []*gitMatcher
 	catchAll *gitMatcher
+	// insteadOf are URL rewrite rules, applied to the clone URLs.
+	insteadOf []gitutil.InsteadOf
+	// credentials, if set, is used to obtain the credentials of http(s) hosts for which
+	// none have been configured.
+	credentials CredentialsFunc
 }
 
+// CredentialsFunc returns the user and password to use for a git host.
+type CredentialsFunc func(protocol, host string) (string, string, error)
+
+// HostKeysFunc returns the SSH host keys, in known_hosts format, to use for a git site. An
+// empty string means that the keys of ~/.ssh/known_hosts are used.
+type HostKeysFunc func() (string, error)
+
 // NewGitLookup creates new lookuper
 func NewGitLookup() *GitLookup {
 	matchers := []*gitMatcher{
@@ -132,6 +148,43 @@ func (gl *GitLookup) AddMatcher(name, pattern, sub, user, password, suffix, prot
 	return nil
 }
 
+// AddInsteadOf adds a URL rewrite rule, similar to the url.<base>.insteadOf setting of git.
+func (gl *GitLookup) AddInsteadOf(rule gitutil.InsteadOf) {
+	gl.insteadOf = append(gl.insteadOf, rule)
+}
+
+// SetCredentialsFunc sets the function used to obtain the credentials of http(s) hosts for
+// which none have been configured.
+func (gl *GitLookup) SetCredentialsFunc(f CredentialsFunc) {
+	gl.credentials = f
+}
+
+// SetHostKeysFunc sets the function used to obtain the host keys of the given site, when no
+// keyScan has been configured for it.
+func (gl *GitLookup) SetHostKeysFunc(name string, f HostKeysFunc) error {
+	for _, m := range gl.matchers {
+		if m.name == name {
+			m.hostKeys = f
+			return nil
+		}
+	}
+	return fmt.Errorf("no git matcher named %s", name)
+}
+
+// rewriteURL applies the insteadOf rule with the longest matching prefix, as git does.
+func (gl *GitLookup) rewriteURL(gitURL string) string {
+	var best *gitutil.InsteadOf
+	for i, rule := range gl.insteadOf {
+		if strings.HasPrefix(gitURL, rule.Prefix) && (best == nil || len(rule.Prefix) > len(best.Prefix)) {
+			best = &gl.insteadOf[i]
+		}
+	}
+	if best == nil {
+		return gitURL
+	}
+	return best.Base + strings.TrimPrefix(gitURL, best.Prefix)
+}
+
 func (gl *GitLookup) getGitMatcher(path string) (string, *gitMatcher, error) {
 	if len(gl.matchers) == 0 {
 		panic("no matchers")
@@ -152,9 +205,11 @@ func (gl *GitLookup) getGitMatcher(path string) (string, *gitMatcher, error) {
 }
 
 // GetCloneURL returns the repo to clone, and a path relative to the repo
-//   "github.com/earthly/earthly"             ---> ("git@github.com/earthly/earthly.git", "")
-//   "github.com/earthly/earthly/examples"    ---> ("git@github.com/earthly/earthly.git", "examples")
-//   "github.com/earthly/earthly/examples/go" ---> ("git@github.com/earthly/earthly.git", "examples/go")
+//
+//	"github.com/earthly/earthly"             ---> ("git@github.com/earthly/earthly.git", "")
+//	"github.com/earthly/earthly/examples"    ---> ("git@github.com/earthly/earthly.git", "examples")
+//	"github.com/earthly/earthly/examples/go" ---> ("git@github.com/earthly/earthly.git", "examples/go")
+//
 // Additionally a ssh keyscan might be returned (or an empty string indicating none was configured)
 func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 	match, m, err := gl.getGitMatcher(path)
@@ -173,10 +228,25 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 	case "ssh":
 		gitURL = m.user + "@" + strings.Replace(match, "/", ":", 1) + m.suffix
 		keyScan = m.keyScan
+		if keyScan == "" && m.hostKeys != nil {
+			keyScan, err = m.hostKeys()
+			if err != nil {
+				return "", "", "", errors.Wrapf(err, "host keys of %s", m.name)
+			}
+		}
 	case "http", "https":
+		user, password := m.user, m.password
+		if password == "" && gl.credentials != nil {
+			host := strings.SplitN(match, "/", 2)[0]
+			user, password, err = gl.credentials(m.protocol, host)
+			if err != nil {
+				// Carry on without credentials; public repos do not need any.
+				user, password = "", ""
+			}
+		}
 		var userAndPass string
-		if m.user != "" && m.password != "" {
-			userAndPass = url.QueryEscape(m.user) + ":" + url.QueryEscape(m.password) + "@"
+		if user != "" && password != "" {
+			userAndPass = url.QueryEscape(user) + ":" + url.QueryEscape(password) + "@"
 		}
 		gitURL = m.protocol + "://" + userAndPass + match + m.suffix
 	default:
@@ -186,6 +256,7 @@ func (gl *GitLookup) GetCloneURL(path string) (string, string, string, error) {
 	if m.sub != "" {
 		gitURL = m.re.ReplaceAllString(path, m.sub)
 	}
+	gitURL = gl.rewriteURL(gitURL)
 
 	if keyScan == "" {
 		keyScan, err = loadKnownHosts()
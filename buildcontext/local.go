@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/gitutil"
 	"github.com/earthly/earthly/llbutil"
@@ -16,6 +17,7 @@ import (
 type localResolver struct {
 	gitMetaCache map[string]*gitutil.GitMetadata
 	sessionID    string
+	console      conslogging.ConsoleLogger
 }
 
 func (lr *localResolver) resolveLocal(ctx context.Context, target domain.Target) (*Data, error) {
@@ -27,42 +29,76 @@ func (lr *localResolver) resolveLocal(ctx context.Context, target domain.Target)
 		return nil, err
 	}
 
-	metadata, found := lr.gitMetaCache[target.LocalPath]
-	if !found {
-		metadata, err = gitutil.Metadata(ctx, target.LocalPath)
-		if err != nil {
-			if errors.Is(err, gitutil.ErrNoGitBinary) ||
-				errors.Is(err, gitutil.ErrNotAGitDir) ||
-				errors.Is(err, gitutil.ErrCouldNotDetectRemote) ||
-				errors.Is(err, gitutil.ErrCouldNotDetectGitHash) ||
-				errors.Is(err, gitutil.ErrCouldNotDetectGitBranch) {
-				// Keep going anyway. Either not a git dir, or git not installed, or
-				// remote not detected.
-				if errors.Is(err, gitutil.ErrNoGitBinary) {
-					// TODO: Log this properly in the console.
-					fmt.Printf("Warning: %s\n", err.Error())
-				}
-			} else {
-				return nil, err
-			}
-		}
-		// Note that this could be nil in some cases.
-		lr.gitMetaCache[target.LocalPath] = metadata
+	metadata, err := lr.gitMetadata(ctx, target.LocalPath)
+	if err != nil {
+		return nil, err
 	}
 
 	buildFilePath, err := detectBuildFile(target, filepath.FromSlash(target.LocalPath))
 	if err != nil {
 		return nil, err
 	}
-	return &Data{
-		BuildFilePath: buildFilePath,
-		BuildContext: llb.Local(
-			target.LocalPath,
+	localContext := func(includePatterns []string) llb.State {
+		opts := []llb.LocalOption{
 			llb.ExcludePatterns(excludes),
 			llb.SessionID(lr.sessionID),
 			llb.Platform(llbutil.DefaultPlatform()),
 			llb.WithCustomNamef("[context %s] local context %s", target.LocalPath, target.LocalPath),
-		),
-		GitMetadata: metadata,
+		}
+		if len(includePatterns) > 0 {
+			opts = append(opts, llb.IncludePatterns(includePatterns))
+		}
+		return llb.Local(target.LocalPath, opts...)
+	}
+	return &Data{
+		BuildFilePath:       buildFilePath,
+		BuildContext:        localContext(nil),
+		LocalContextInclude: localContext,
+		GitMetadata:         metadata,
 	}, nil
 }
+
+// gitMetadata returns the (cached) git metadata of a local dir. Note that the metadata
+// could be nil in some cases (e.g. not a git dir).
+func (lr *localResolver) gitMetadata(ctx context.Context, localPath string) (*gitutil.GitMetadata, error) {
+	metadata, found := lr.gitMetaCache[localPath]
+	if found {
+		return metadata, nil
+	}
+	metadata, err := gitutil.Metadata(ctx, localPath)
+	if err != nil {
+		if errors.Is(err, gitutil.ErrNoGitBinary) ||
+			errors.Is(err, gitutil.ErrNotAGitDir) ||
+			errors.Is(err, gitutil.ErrCouldNotDetectRemote) ||
+			errors.Is(err, gitutil.ErrCouldNotDetectGitHash) ||
+			errors.Is(err, gitutil.ErrCouldNotDetectGitBranch) {
+			// Keep going anyway. Either not a git dir, or git not installed, or
+			// remote not detected.
+			if errors.Is(err, gitutil.ErrNoGitBinary) {
+				lr.console.Warnf("Warning: %s\n", err.Error())
+			}
+		} else {
+			return nil, err
+		}
+	}
+	lr.gitMetaCache[localPath] = metadata
+	return metadata, nil
+}
+
+// withLocalGitTag sets the tag of a remote target to the ref currently checked out locally,
+// if the target points to the same repository as the current directory.
+func (lr *localResolver) withLocalGitTag(ctx context.Context, target domain.Target) domain.Target {
+	metadata, err := lr.gitMetadata(ctx, ".")
+	if err != nil || metadata == nil {
+		return target
+	}
+	if !metadata.IsSameRepo(target.GitURL) {
+		return target
+	}
+	target.Tag = metadata.DefaultTag()
+	if target.Tag == "HEAD" {
+		// Detached HEAD. Use the commit instead.
+		target.Tag = metadata.Hash
+	}
+	return target
+}
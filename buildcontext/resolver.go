@@ -2,8 +2,10 @@ package buildcontext
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/gitutil"
 
@@ -21,6 +23,9 @@ type Data struct {
 	BuildFilePath string
 	// BuildContext is the state to use for the build.
 	BuildContext llb.State
+	// LocalContextInclude returns the build context restricted to the files matching the
+	// given patterns, so that only those are transferred. It is nil for remote targets.
+	LocalContextInclude func(includePatterns []string) llb.State
 	// GitMetadata contains git metadata information.
 	GitMetadata *gitutil.GitMetadata
 	// Target is the earthly target.
@@ -33,11 +38,19 @@ type Data struct {
 type Resolver struct {
 	gr *gitResolver
 	lr *localResolver
+
+	// localGitTagDefault enables defaulting the tag of untagged remote targets pointing
+	// to the local git repository, to the currently checked out ref.
+	localGitTagDefault bool
+	// offline causes the resolution of remote targets to fail, instead of fetching them.
+	offline bool
 }
 
 // NewResolver returns a new NewResolver.
-func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup) *Resolver {
+func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLookup *GitLookup, console conslogging.ConsoleLogger, localGitTagDefault bool, offline bool) *Resolver {
 	return &Resolver{
+		localGitTagDefault: localGitTagDefault,
+		offline:            offline,
 		gr: &gitResolver{
 			cleanCollection: cleanCollection,
 			projectCache:    make(map[string]*resolvedGitProject),
@@ -46,15 +59,28 @@ func NewResolver(sessionID string, cleanCollection *cleanup.Collection, gitLooku
 		lr: &localResolver{
 			gitMetaCache: make(map[string]*gitutil.GitMetadata),
 			sessionID:    sessionID,
+			console:      console,
 		},
 	}
 }
 
+// ResetLocal forgets the cached git metadata of the local directories, so that it is read
+// again on the next resolution (e.g. when the same target is built again).
+func (r *Resolver) ResetLocal() {
+	r.lr.gitMetaCache = make(map[string]*gitutil.GitMetadata)
+}
+
 // Resolve returns resolved build context data.
 func (r *Resolver) Resolve(ctx context.Context, gwClient gwclient.Client, target domain.Target) (*Data, error) {
 	localDirs := make(map[string]string)
 	if target.IsRemote() {
 		// Remote.
+		if r.offline {
+			return nil, fmt.Errorf("cannot fetch remote target %s in offline mode", target.String())
+		}
+		if target.Tag == "" && r.localGitTagDefault {
+			target = r.lr.withLocalGitTag(ctx, target)
+		}
 		d, err := r.gr.resolveEarthProject(ctx, gwClient, target)
 		if err != nil {
 			return nil, err
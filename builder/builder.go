@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -53,6 +54,37 @@ type Opt struct {
 	BuildContextProvider *provider.BuildContextProvider
 	GitLookup            *buildcontext.GitLookup
 	UseFakeDep           bool
+	LocalGitTagDefault   bool
+	CacheMountSharing    llb.CacheMountSharingMode
+	NoCacheMounts        bool
+	// BuildkitAttrs are additional attributes passed to the buildkit exporter (for the keys
+	// prefixed with exporter:) and to the registry cache exports (for the keys prefixed with
+	// cache-export:). The keys must be allowed by ValidateBuildkitAttr.
+	BuildkitAttrs map[string]string
+	// SourceDateEpoch is the unix timestamp exposed as the SOURCE_DATE_EPOCH builtin arg.
+	// Empty means that the timestamp of the git commit of the build context is used.
+	SourceDateEpoch string
+	// ProgressJSON, if set, receives the progress of the builds as a stream of
+	// JSONStreamEvents, one per line.
+	ProgressJSON io.Writer
+	// Offline prevents remote targets from being fetched.
+	Offline bool
+	// ContainerRuntime is the CLI (docker or podman) used to load the output images.
+	ContainerRuntime string
+	// Strict turns the deprecation warnings of the Earthfiles into errors.
+	Strict bool
+	// ExplainCache enables printing whether each step was served from the cache, and the
+	// likely reason when it was not.
+	ExplainCache bool
+	// CacheHintAll treats the final state of every target in the build as if it had been
+	// saved with SAVE IMAGE --cache-hint, so that each target becomes a cache boundary in
+	// CacheExport. Unlike MaxCacheExport, which exports every intermediate layer (mode=max),
+	// only the layers of the target results are exported. It has no effect without
+	// CacheExport.
+	CacheHintAll bool
+	// EarthlyVersion is the version of the running earthly, which the Earthfiles may
+	// require a minimum of, via VERSION.
+	EarthlyVersion string
 }
 
 // BuildOpt is a collection of build options.
@@ -64,6 +96,9 @@ type BuildOpt struct {
 	OnlyFinalTargetImages bool
 	OnlyArtifact          *domain.Artifact
 	OnlyArtifactDestPath  string
+	// OutputDir is the directory under which the local artifacts are written, if set. The
+	// AS LOCAL destinations, including the absolute ones, are taken as relative to it.
+	OutputDir string
 }
 
 // Builder executes Earthly builds.
@@ -72,13 +107,15 @@ type Builder struct {
 	opt       Opt
 	resolver  *buildcontext.Resolver
 	builtMain bool
+	// explicitCacheImports are the cache imports passed in the options, before any were
+	// added by the build itself.
+	explicitCacheImports map[string]bool
 }
 
 // NewBuilder returns a new earthly Builder.
 func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
 	b := &Builder{
 		s: &solver{
-			sm:              newSolverMonitor(opt.Console, opt.Verbose),
 			bkClient:        opt.BkClient,
 			cacheImports:    opt.CacheImports,
 			cacheExport:     opt.CacheExport,
@@ -86,16 +123,35 @@ func NewBuilder(ctx context.Context, opt Opt) (*Builder, error) {
 			attachables:     opt.Attachables,
 			enttlmnts:       opt.Enttlmnts,
 			saveInlineCache: opt.SaveInlineCache,
+			buildkitAttrs:   opt.BuildkitAttrs,
 		},
-		opt:      opt,
-		resolver: nil, // initialized below
+		opt:                  opt,
+		resolver:             nil, // initialized below
+		explicitCacheImports: make(map[string]bool),
 	}
-	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup)
+	for ci := range opt.CacheImports {
+		b.explicitCacheImports[ci] = true
+	}
+	b.s.sm = b.newSolverMonitor()
+	b.resolver = buildcontext.NewResolver(opt.SessionID, opt.CleanCollection, opt.GitLookup, opt.Console, opt.LocalGitTagDefault, opt.Offline)
 	return b, nil
 }
 
-// BuildTarget executes the build of a given Earthly target.
+func (b *Builder) newSolverMonitor() *solverMonitor {
+	sm := newSolverMonitor(b.opt.Console, b.opt.Verbose)
+	if b.opt.ProgressJSON != nil {
+		sm.jsonStream = newJSONStream(b.opt.ProgressJSON)
+	}
+	sm.explainCache = b.opt.ExplainCache
+	sm.noCache = b.opt.NoCache
+	return sm
+}
+
+// BuildTarget executes the build of a given Earthly target. A builder may run several
+// builds in a row (e.g. with --watch): the attachables of the session and the resolved
+// remote projects are reused, while the state of the previous build is discarded.
 func (b *Builder) BuildTarget(ctx context.Context, target domain.Target, opt BuildOpt) (*states.MultiTarget, error) {
+	b.reset()
 	mts, err := b.convertAndBuild(ctx, target, opt)
 	if err != nil {
 		return nil, err
@@ -103,6 +159,31 @@ func (b *Builder) BuildTarget(ctx context.Context, target domain.Target, opt Bui
 	return mts, nil
 }
 
+// reset discards the state left by a previous build, if any.
+func (b *Builder) reset() {
+	b.builtMain = false
+	for ci := range b.opt.CacheImports {
+		if !b.explicitCacheImports[ci] {
+			delete(b.opt.CacheImports, ci)
+		}
+	}
+	b.s.sm = b.newSolverMonitor()
+	b.resolver.ResetLocal()
+}
+
+// InlineCacheSources returns the image tags which the build added as cache sources: the
+// SAVE IMAGE --push tags (when inline caching is enabled) and the SAVE IMAGE --cache-from tags.
+func (b *Builder) InlineCacheSources() []string {
+	var sources []string
+	for ci := range b.opt.CacheImports {
+		if !b.explicitCacheImports[ci] {
+			sources = append(sources, ci)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
 // MakeImageAsTarBuilderFun returns a function which can be used to build an image as a tar.
 func (b *Builder) MakeImageAsTarBuilderFun() states.DockerBuilderFun {
 	return func(ctx context.Context, mts *states.MultiTarget, dockerTag string, outFile string) error {
@@ -155,6 +236,10 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 	depIndex := 0
 	imageIndex := 0
 	dirIndex := 0
+	cacheHintIndex := 0
+	// saveImageCacheHints counts the SAVE IMAGE --cache-hint images separately, as their
+	// state may also be hinted by CacheHintAll.
+	saveImageCacheHints := 0
 	bf := func(childCtx context.Context, gwClient gwclient.Client) (*gwclient.Result, error) {
 		var err error
 		if !b.builtMain {
@@ -170,6 +255,12 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 				CacheImports:         b.opt.CacheImports,
 				UseInlineCache:       b.opt.UseInlineCache,
 				UseFakeDep:           b.opt.UseFakeDep,
+				CacheMountSharing:    b.opt.CacheMountSharing,
+				NoCacheMounts:        b.opt.NoCacheMounts,
+				EarthlyVersion:       b.opt.EarthlyVersion,
+				Console:              b.opt.Console,
+				Strict:               b.opt.Strict,
+				SourceDateEpoch:      b.opt.SourceDateEpoch,
 			})
 			if err != nil {
 				return nil, err
@@ -205,6 +296,16 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 				res.AddRef(refKey, depRef)
 				depIndex++
 			}
+			if b.opt.CacheHintAll && b.opt.CacheExport != "" && !b.builtMain {
+				// Adding the state as a ref of the result is enough for its cache
+				// records to be included in the cache export.
+				hintRef, err := b.stateToRef(childCtx, gwClient, sts.MainState, sts.Platform)
+				if err != nil {
+					return nil, err
+				}
+				res.AddRef(fmt.Sprintf("cache-hint-%d", cacheHintIndex), hintRef)
+				cacheHintIndex++
+			}
 
 			for _, saveImage := range sts.SaveImages {
 				shouldPush := opt.Push && saveImage.Push && !sts.Target.IsRemote() && saveImage.DockerTag != ""
@@ -214,6 +315,9 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 					// Short-circuit.
 					continue
 				}
+				if useCacheHint {
+					saveImageCacheHints++
+				}
 				ref, err := b.stateToRef(childCtx, gwClient, saveImage.State, sts.Platform)
 				if err != nil {
 					return nil, err
@@ -321,7 +425,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 		pipeR, pipeW := io.Pipe()
 		eg.Go(func() error {
 			defer pipeR.Close()
-			err := loadDockerTar(childCtx, pipeR)
+			err := loadDockerTar(childCtx, b.opt.ContainerRuntime, pipeR)
 			if err != nil {
 				return errors.Wrapf(err, "load docker tar")
 			}
@@ -349,6 +453,11 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 	if err != nil {
 		return nil, errors.Wrapf(err, "build main")
 	}
+	if b.opt.CacheHintAll && b.opt.CacheExport != "" {
+		b.opt.Console.Printf(
+			"Exported the cache of %d target(s) and of %d SAVE IMAGE --cache-hint image(s) to %s\n",
+			cacheHintIndex, saveImageCacheHints, b.opt.CacheExport)
+	}
 	sp.printCurrentSuccess()
 	sp.incrementIndex()
 	b.builtMain = true
@@ -455,7 +564,7 @@ func (b *Builder) convertAndBuild(ctx context.Context, target domain.Target, opt
 		}
 	}
 	for parentImageName, children := range manifestLists {
-		err = loadDockerManifest(ctx, b.opt.Console, parentImageName, children)
+		err = loadDockerManifest(ctx, b.opt.Console, b.opt.ContainerRuntime, parentImageName, children)
 		if err != nil {
 			return nil, err
 		}
@@ -592,6 +701,7 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 			// Place within dest dir.
 			to = path.Join(to, path.Base(from))
 		}
+		to = rootUnder(opt.OutputDir, to)
 		destExists := false
 		fiDest, err := os.Stat(to)
 		if err != nil {
@@ -653,9 +763,20 @@ func (b *Builder) saveArtifactLocally(ctx context.Context, artifact domain.Artif
 		if strings.HasSuffix(destPath, "/") {
 			destPath2 = filepath.Join(destPath2, filepath.Base(artifactPath))
 		}
+		destPath2 = filepath.FromSlash(rootUnder(opt.OutputDir, filepath.ToSlash(destPath2)))
 		if opt.PrintSuccess {
 			console.Printf("Artifact %s as local %s\n", artifact2.StringCanonical(), destPath2)
 		}
 	}
 	return nil
 }
+
+// rootUnder returns the given slash-separated path as a path within dir. The path is taken as
+// relative to dir even if it is absolute, and may not escape it via "..". An empty dir leaves
+// the path unchanged.
+func rootUnder(dir string, p string) string {
+	if dir == "" {
+		return p
+	}
+	return path.Join(filepath.ToSlash(dir), path.Clean("/"+p))
+}
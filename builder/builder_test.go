@@ -0,0 +1,27 @@
+package builder
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRootUnder(t *testing.T) {
+	var tests = []struct {
+		dir      string
+		path     string
+		expected string
+	}{
+		{"", "out/file", "out/file"},
+		{"", "/abs/file", "/abs/file"},
+		{"dist", "./", "dist"},
+		{"dist", "out/file", "dist/out/file"},
+		{"dist", "/abs/file", "dist/abs/file"},
+		{"dist", "../file", "dist/file"},
+		{"/tmp/dist", "sub/../file", "/tmp/dist/file"},
+	}
+
+	for _, tt := range tests {
+		Equal(t, tt.expected, rootUnder(tt.dir, tt.path), "%s under %s", tt.path, tt.dir)
+	}
+}
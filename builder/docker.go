@@ -39,7 +39,7 @@ func platformSpecificImageName(imgName string, platform specs.Platform) (string,
 	return reference.FamiliarString(r2), nil
 }
 
-func loadDockerManifest(ctx context.Context, console conslogging.ConsoleLogger, parentImageName string, children []manifest) error {
+func loadDockerManifest(ctx context.Context, console conslogging.ConsoleLogger, runtime, parentImageName string, children []manifest) error {
 	console = console.WithPrefix(parentImageName)
 	if len(children) == 0 {
 		return errors.Errorf("no images in manifest list for %s", parentImageName)
@@ -68,28 +68,28 @@ func loadDockerManifest(ctx context.Context, console conslogging.ConsoleLogger,
 		"%s is a multi-platform image. The following per-platform images have been produced:\n\t%s\n%s\n",
 		parentImageName, strings.Join(childImgs, "\n\t"), noteDetail)
 
-	cmd := exec.CommandContext(ctx, "docker", "tag", children[defaultChild].imageName, parentImageName)
+	cmd := exec.CommandContext(ctx, runtime, "tag", children[defaultChild].imageName, parentImageName)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		return errors.Wrap(err, "docker tag default platform image")
+		return errors.Wrapf(err, "%s tag default platform image", runtime)
 	}
 	return nil
 }
 
 // TODO: This doesn't work with vanilla docker installations. Not currently used.
-func loadDockerManifestExperimental(ctx context.Context, parentImageName string, children []manifest) error {
+func loadDockerManifestExperimental(ctx context.Context, runtime, parentImageName string, children []manifest) error {
 	createArgs := []string{"manifest", "create", parentImageName}
 	for _, child := range children {
 		createArgs = append(createArgs, child.imageName)
 	}
-	cmd := exec.CommandContext(ctx, "docker", createArgs...)
+	cmd := exec.CommandContext(ctx, runtime, createArgs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		return errors.Wrap(err, "docker manifest create")
+		return errors.Wrapf(err, "%s manifest create", runtime)
 	}
 
 	for _, child := range children {
@@ -101,26 +101,26 @@ func loadDockerManifestExperimental(ctx context.Context, parentImageName string,
 			"--os-version", child.platform.OSVersion,
 			"--os-features", strings.Join(child.platform.OSFeatures, ","),
 		}
-		cmd := exec.CommandContext(ctx, "docker", annotateArgs...)
+		cmd := exec.CommandContext(ctx, runtime, annotateArgs...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		err := cmd.Run()
 		if err != nil {
-			return errors.Wrap(err, "docker manifest annotate")
+			return errors.Wrapf(err, "%s manifest annotate", runtime)
 		}
 	}
 	return nil
 }
 
-func loadDockerTar(ctx context.Context, r io.ReadCloser) error {
+func loadDockerTar(ctx context.Context, runtime string, r io.ReadCloser) error {
 	// TODO: This is a gross hack - should use proper docker client.
-	cmd := exec.CommandContext(ctx, "docker", "load")
+	cmd := exec.CommandContext(ctx, runtime, "load")
 	cmd.Stdin = r
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err := cmd.Run()
 	if err != nil {
-		return errors.Wrap(err, "docker load")
+		return errors.Wrapf(err, "%s load", runtime)
 	}
 	return nil
 }
@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+)
+
+// JSONStreamEvent is an event of the JSON progress stream, which is written as a single line
+// of JSON. The fields which are set depend on the type of the event:
+//
+//   - "vertex": a build step (vertex) was updated. Vertex, Name, Target and Operation
+//     identify the step. Started and Completed are set once the step has started and
+//     completed, respectively (DurationMS is then set too). Cached is set if the step was
+//     served from the cache and Error is set if the step failed.
+//   - "status": the progress of a step was updated (e.g. a download). ID identifies the
+//     item being processed, Current and Total measure its progress and Completed is set
+//     once the item is done.
+//   - "log": a step wrote output. Stream is 1 for stdout and 2 for stderr, and Data holds
+//     the output, which is not necessarily split on line boundaries.
+//
+// Time is the time the event was written at.
+type JSONStreamEvent struct {
+	Type       string     `json:"type"`
+	Time       time.Time  `json:"time"`
+	Vertex     string     `json:"vertex"`
+	Name       string     `json:"name,omitempty"`
+	Target     string     `json:"target,omitempty"`
+	Operation  string     `json:"operation,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	Completed  *time.Time `json:"completed,omitempty"`
+	DurationMS int64      `json:"durationMs,omitempty"`
+	Cached     bool       `json:"cached,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ID         string     `json:"id,omitempty"`
+	Current    int64      `json:"current,omitempty"`
+	Total      int64      `json:"total,omitempty"`
+	Stream     int        `json:"stream,omitempty"`
+	Data       string     `json:"data,omitempty"`
+}
+
+// jsonStream writes the solve statuses of the builds as JSONStreamEvents. It is safe for
+// concurrent use.
+type jsonStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONStream(w io.Writer) *jsonStream {
+	return &jsonStream{enc: json.NewEncoder(w)}
+}
+
+func (js *jsonStream) write(ss *client.SolveStatus) error {
+	now := time.Now()
+	var events []JSONStreamEvent
+	for _, vertex := range ss.Vertexes {
+		targetStr, _, _, operation := parseVertexName(vertex.Name)
+		ev := JSONStreamEvent{
+			Type:      "vertex",
+			Time:      now,
+			Vertex:    vertex.Digest.String(),
+			Name:      vertex.Name,
+			Target:    targetStr,
+			Operation: operation,
+			Started:   vertex.Started,
+			Completed: vertex.Completed,
+			Cached:    vertex.Cached,
+			Error:     vertex.Error,
+		}
+		if vertex.Started != nil && vertex.Completed != nil {
+			ev.DurationMS = vertex.Completed.Sub(*vertex.Started).Milliseconds()
+		}
+		events = append(events, ev)
+	}
+	for _, vs := range ss.Statuses {
+		events = append(events, JSONStreamEvent{
+			Type:      "status",
+			Time:      now,
+			Vertex:    vs.Vertex.String(),
+			ID:        vs.ID,
+			Current:   vs.Current,
+			Total:     vs.Total,
+			Started:   vs.Started,
+			Completed: vs.Completed,
+		})
+	}
+	for _, logLine := range ss.Logs {
+		events = append(events, JSONStreamEvent{
+			Type:   "log",
+			Time:   now,
+			Vertex: logLine.Vertex.String(),
+			Stream: logLine.Stream,
+			Data:   string(logLine.Data),
+		})
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, ev := range events {
+		err := js.enc.Encode(ev)
+		if err != nil {
+			return errors.Wrap(err, "write json stream event")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestJSONStream(t *testing.T) {
+	started := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	completed := started.Add(1500 * time.Millisecond)
+	dgst := digest.FromString("vertex")
+
+	var buf bytes.Buffer
+	js := newJSONStream(&buf)
+	err := js.write(&client.SolveStatus{
+		Vertexes: []*client.Vertex{{
+			Digest:    dgst,
+			Name:      "[+build salt] RUN go build",
+			Started:   &started,
+			Completed: &completed,
+			Cached:    true,
+		}},
+		Statuses: []*client.VertexStatus{{
+			ID:      "sha256:abc",
+			Vertex:  dgst,
+			Current: 5,
+			Total:   10,
+		}},
+		Logs: []*client.VertexLog{{
+			Vertex: dgst,
+			Stream: 2,
+			Data:   []byte("hello\n"),
+		}},
+	})
+	NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	Len(t, lines, 3)
+	var events []JSONStreamEvent
+	for _, line := range lines {
+		var ev JSONStreamEvent
+		NoError(t, json.Unmarshal([]byte(line), &ev))
+		events = append(events, ev)
+	}
+
+	Equal(t, "vertex", events[0].Type)
+	Equal(t, dgst.String(), events[0].Vertex)
+	Equal(t, "+build", events[0].Target)
+	Equal(t, "RUN go build", events[0].Operation)
+	Equal(t, int64(1500), events[0].DurationMS)
+	True(t, events[0].Cached)
+
+	Equal(t, "status", events[1].Type)
+	Equal(t, "sha256:abc", events[1].ID)
+	Equal(t, int64(5), events[1].Current)
+	Equal(t, int64(10), events[1].Total)
+
+	Equal(t, "log", events[2].Type)
+	Equal(t, 2, events[2].Stream)
+	Equal(t, "hello\n", events[2].Data)
+}
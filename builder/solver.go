@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/states/image"
@@ -33,6 +36,37 @@ type solver struct {
 	cacheExport     string
 	maxCacheExport  string
 	saveInlineCache bool
+	buildkitAttrs   map[string]string
+}
+
+const (
+	// exporterAttrPrefix prefixes the buildkit attributes which are passed to the exporter
+	// of the images and artifacts.
+	exporterAttrPrefix = "exporter:"
+	// cacheExportAttrPrefix prefixes the buildkit attributes which are passed to the
+	// registry cache exports (--remote-cache and --max-remote-cache).
+	cacheExportAttrPrefix = "cache-export:"
+)
+
+// allowedBuildkitAttrs are the buildkit attributes which may be set by users. Attributes
+// which earthly sets itself (e.g. name, ref or mode) are not allowed.
+var allowedBuildkitAttrs = map[string]bool{
+	exporterAttrPrefix + "compression":       true,
+	exporterAttrPrefix + "oci-mediatypes":    true,
+	cacheExportAttrPrefix + "oci-mediatypes": true,
+}
+
+// ValidateBuildkitAttr returns an error if the given buildkit attribute may not be set by users.
+func ValidateBuildkitAttr(key string) error {
+	if allowedBuildkitAttrs[key] {
+		return nil
+	}
+	var allowed []string
+	for k := range allowedBuildkitAttrs {
+		allowed = append(allowed, k)
+	}
+	sort.Strings(allowed)
+	return fmt.Errorf("buildkit attribute %s is not allowed; allowed attributes: %s", key, strings.Join(allowed, ", "))
 }
 
 func (s *solver) solveDockerTar(ctx context.Context, state llb.State, platform specs.Platform, img *image.Image, dockerTag string, outFile string) error {
@@ -169,10 +203,10 @@ func (s *solver) newSolveOptDocker(img *image.Image, dockerTag string, w io.Writ
 		Exports: []client.ExportEntry{
 			{
 				Type: client.ExporterDocker,
-				Attrs: map[string]string{
+				Attrs: s.withExportAttrs(map[string]string{
 					"name":                  dockerTag,
 					"containerimage.config": string(imgJSON),
-				},
+				}),
 				Output: func(_ map[string]string) (io.WriteCloser, error) {
 					return w, nil
 				},
@@ -191,10 +225,10 @@ func (s *solver) newSolveOptMulti(ctx context.Context, eg *errgroup.Group, onIma
 	}
 	var cacheExports []client.CacheOptionsEntry
 	if s.cacheExport != "" {
-		cacheExports = append(cacheExports, newCacheExportOpt(s.cacheExport, false))
+		cacheExports = append(cacheExports, s.withCacheExportAttrs(newCacheExportOpt(s.cacheExport, false)))
 	}
 	if s.maxCacheExport != "" {
-		cacheExports = append(cacheExports, newCacheExportOpt(s.cacheExport, true))
+		cacheExports = append(cacheExports, s.withCacheExportAttrs(newCacheExportOpt(s.maxCacheExport, true)))
 	}
 	if s.saveInlineCache {
 		cacheExports = append(cacheExports, newInlineCacheOpt())
@@ -203,7 +237,7 @@ func (s *solver) newSolveOptMulti(ctx context.Context, eg *errgroup.Group, onIma
 		Exports: []client.ExportEntry{
 			{
 				Type:  client.ExporterEarthly,
-				Attrs: map[string]string{},
+				Attrs: s.withExportAttrs(map[string]string{}),
 				Output: func(md map[string]string) (io.WriteCloser, error) {
 					if md["export-image"] != "true" {
 						return nil, nil
@@ -254,6 +288,28 @@ func (s *solver) newSolveOptMain() (*client.SolveOpt, error) {
 	}, nil
 }
 
+// withExportAttrs adds the exporter attributes which apply to all exports to the given
+// attributes.
+func (s *solver) withExportAttrs(attrs map[string]string) map[string]string {
+	for k, v := range s.buildkitAttrs {
+		if strings.HasPrefix(k, exporterAttrPrefix) {
+			attrs[strings.TrimPrefix(k, exporterAttrPrefix)] = v
+		}
+	}
+	return attrs
+}
+
+// withCacheExportAttrs adds the user provided cache export attributes to the given registry
+// cache export.
+func (s *solver) withCacheExportAttrs(entry client.CacheOptionsEntry) client.CacheOptionsEntry {
+	for k, v := range s.buildkitAttrs {
+		if strings.HasPrefix(k, cacheExportAttrPrefix) {
+			entry.Attrs[strings.TrimPrefix(k, cacheExportAttrPrefix)] = v
+		}
+	}
+	return entry
+}
+
 func newCacheImportOpt(ref string) client.CacheOptionsEntry {
 	registryCacheOptAttrs := make(map[string]string)
 	registryCacheOptAttrs["ref"] = ref
@@ -45,6 +45,8 @@ type vertexMonitor struct {
 	openLine            []byte
 	lastOpenLineUpdate  time.Time
 	lastOpenLineSkipped bool
+	// cacheExplained is set once the cache outcome of the vertex has been printed.
+	cacheExplained bool
 }
 
 func (vm *vertexMonitor) printHeader() {
@@ -210,6 +212,12 @@ type solverMonitor struct {
 	lastOutputWasOngoingProgress bool
 	timingTable                  map[timingKey]time.Duration
 	startTime                    time.Time
+	// jsonStream, if set, receives all the solve statuses.
+	jsonStream *jsonStream
+	// explainCache enables printing whether each vertex was cached, and why not.
+	explainCache bool
+	// noCache is set if the build ignores the cache altogether (--no-cache).
+	noCache bool
 
 	mu             sync.Mutex
 	success        bool
@@ -246,6 +254,12 @@ Loop:
 			if !ok {
 				break Loop
 			}
+			if sm.jsonStream != nil {
+				err := sm.jsonStream.write(ss)
+				if err != nil {
+					return err
+				}
+			}
 			for _, vertex := range ss.Vertexes {
 				vm, ok := sm.vertices[vertex.Digest]
 				if !ok {
@@ -280,6 +294,11 @@ Loop:
 						}
 					}
 				}
+				if sm.explainCache && !vm.isInternal && !vm.cacheExplained && vm.headerPrinted &&
+					vertex.Error == "" && (vertex.Cached || vertex.Completed != nil) {
+					vm.cacheExplained = true
+					vm.console.WithMetadataMode(true).Printf("%s\n", sm.cacheExplanation(vm))
+				}
 				if sm.verbose {
 					vm.printTimingInfo()
 					sm.recordTiming(vm.targetStr, vm.targetBrackets, vm.salt, vertex)
@@ -356,6 +375,60 @@ func (sm *solverMonitor) printHeader(vm *vertexMonitor) {
 	vm.printHeader()
 }
 
+// cacheExplanation returns whether the vertex was served from the cache (CACHED) or not
+// (COMPUTED), along with the likely reason of the cache miss. The reason is inferred from the
+// cache outcome of the inputs of the vertex, as buildkit does not report it.
+func (sm *solverMonitor) cacheExplanation(vm *vertexMonitor) string {
+	if vm.vertex.Cached {
+		return "CACHED"
+	}
+	switch {
+	case sm.noCache:
+		return "COMPUTED: the cache is disabled (--no-cache)"
+	case hasRunFlag(vm.operation, "--no-cache"):
+		return "COMPUTED: the command is never cached (RUN --no-cache)"
+	case hasRunFlag(vm.operation, "--push"):
+		return "COMPUTED: push commands are never cached (RUN --push)"
+	}
+	var changed []string
+	for _, input := range vm.vertex.Inputs {
+		ivm, found := sm.vertices[input]
+		if !found || ivm.vertex.Cached {
+			continue
+		}
+		name := ivm.operation
+		if ivm.targetStr != "" && ivm.targetStr != "internal" {
+			name = fmt.Sprintf("%s %s", ivm.targetStr, ivm.operation)
+		}
+		changed = append(changed, name)
+	}
+	switch {
+	case len(changed) > 0:
+		return fmt.Sprintf("COMPUTED: an input changed (%s)", strings.Join(changed, "; "))
+	case len(vm.vertex.Inputs) == 0:
+		return "COMPUTED: the source is new or changed"
+	default:
+		return "COMPUTED: the command or its arguments changed, or the cache was pruned"
+	}
+}
+
+// hasRunFlag returns true if the operation is a RUN command with the given flag.
+func hasRunFlag(operation, flag string) bool {
+	words := strings.Fields(operation)
+	if len(words) == 0 || words[0] != "RUN" {
+		return false
+	}
+	for _, word := range words[1:] {
+		if !strings.HasPrefix(word, "--") {
+			return false
+		}
+		if word == flag {
+			return true
+		}
+	}
+	return false
+}
+
 func (sm *solverMonitor) recordTiming(targetStr, targetBrackets, salt string, vertex *client.Vertex) {
 	if vertex.Started == nil || vertex.Completed == nil {
 		return
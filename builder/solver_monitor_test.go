@@ -3,6 +3,9 @@ package builder
 import (
 	"testing"
 
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -69,3 +72,33 @@ func TestParseVertexName(t *testing.T) {
 
 	}
 }
+
+func TestCacheExplanation(t *testing.T) {
+	from := &client.Vertex{Digest: digest.FromString("from"), Name: "[+build] FROM alpine", Cached: true}
+	copyFiles := &client.Vertex{Digest: digest.FromString("copy"), Name: "[+build] COPY src src", Inputs: []digest.Digest{digest.FromString("from")}}
+	sm := newSolverMonitor(conslogging.Current(conslogging.NoColor, conslogging.DefaultPadding), false)
+	for _, v := range []*client.Vertex{from, copyFiles} {
+		targetStr, targetBrackets, salt, operation := parseVertexName(v.Name)
+		sm.vertices[v.Digest] = &vertexMonitor{vertex: v, targetStr: targetStr, targetBrackets: targetBrackets, salt: salt, operation: operation}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		inputs   []digest.Digest
+		cached   bool
+		noCache  bool
+		expected string
+	}{
+		{"[+build] RUN make", []digest.Digest{copyFiles.Digest}, true, false, "CACHED"},
+		{"[+build] RUN make", []digest.Digest{copyFiles.Digest}, false, true, "COMPUTED: the cache is disabled (--no-cache)"},
+		{"[+build] RUN --no-cache make", []digest.Digest{copyFiles.Digest}, false, false, "COMPUTED: the command is never cached (RUN --no-cache)"},
+		{"[+build] RUN echo --no-cache", []digest.Digest{from.Digest}, false, false, "COMPUTED: the command or its arguments changed, or the cache was pruned"},
+		{"[+build] RUN make", []digest.Digest{from.Digest, copyFiles.Digest}, false, false, "COMPUTED: an input changed (+build COPY src src)"},
+		{"[+build] FROM alpine", nil, false, false, "COMPUTED: the source is new or changed"},
+	} {
+		sm.noCache = tt.noCache
+		_, _, _, operation := parseVertexName(tt.name)
+		vm := &vertexMonitor{vertex: &client.Vertex{Name: tt.name, Inputs: tt.inputs, Cached: tt.cached}, operation: operation}
+		Equal(t, tt.expected, sm.cacheExplanation(vm), tt.name)
+	}
+}
@@ -0,0 +1,29 @@
+package builder
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestValidateBuildkitAttr(t *testing.T) {
+	NoError(t, ValidateBuildkitAttr("exporter:compression"))
+	NoError(t, ValidateBuildkitAttr("cache-export:oci-mediatypes"))
+	// Set by earthly itself.
+	Error(t, ValidateBuildkitAttr("exporter:name"))
+	Error(t, ValidateBuildkitAttr("cache-export:mode"))
+	// Missing or unknown scope.
+	Error(t, ValidateBuildkitAttr("compression"))
+	Error(t, ValidateBuildkitAttr("frontend:add-hosts"))
+}
+
+func TestBuildkitAttrs(t *testing.T) {
+	s := &solver{buildkitAttrs: map[string]string{
+		"exporter:compression":        "uncompressed",
+		"cache-export:oci-mediatypes": "true",
+	}}
+	Equal(t, map[string]string{"name": "img", "compression": "uncompressed"},
+		s.withExportAttrs(map[string]string{"name": "img"}))
+	Equal(t, map[string]string{"ref": "cache", "mode": "max", "oci-mediatypes": "true"},
+		s.withCacheExportAttrs(newCacheExportOpt("cache", true)).Attrs)
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	_ "github.com/earthly/earthly/buildkitd/podmanconnhelper" // Load "podman-container://" helper.
 	"github.com/earthly/earthly/conslogging"
 	"github.com/moby/buildkit/client"
 	_ "github.com/moby/buildkit/client/connhelper/dockercontainer" // Load "docker-container://" helper.
@@ -23,16 +24,17 @@ const (
 	VolumeName = "earthly-cache"
 )
 
-// Address is the address at which the daemon is available.
-var Address = fmt.Sprintf("docker-container://%s", ContainerName)
-
 // TODO: Implement all this properly with the docker client.
 
 // NewClient returns a new buildkitd client.
 func NewClient(ctx context.Context, console conslogging.ConsoleLogger, image string, settings Settings, opTimeout time.Duration, opts ...client.ClientOpt) (*client.Client, error) {
 	address, err := MaybeStart(ctx, console, image, settings, opTimeout)
 	if err != nil {
-		console.WithPrefix("buildkitd").Printf("Is docker installed and running? Are you part of the docker group?\n")
+		if settings.ContainerRuntime == PodmanRuntime {
+			console.WithPrefix("buildkitd").Printf("Is podman installed and able to run privileged containers?\n")
+		} else {
+			console.WithPrefix("buildkitd").Printf("Is docker installed and running? Are you part of the docker group?\n")
+		}
 		return nil, errors.Wrap(err, "maybe start buildkitd")
 	}
 	bkClient, err := client.New(ctx, address, opts...)
@@ -47,16 +49,17 @@ func ResetCache(ctx context.Context, console conslogging.ConsoleLogger, image st
 	console.
 		WithPrefix("buildkitd").
 		Printf("Restarting buildkit daemon with reset command...\n")
-	isStarted, err := IsStarted(ctx)
+	runtime := settings.ContainerRuntime
+	isStarted, err := IsStarted(ctx, runtime)
 	if err != nil {
 		return errors.Wrap(err, "check is started buildkitd")
 	}
 	if isStarted {
-		err = Stop(ctx)
+		err = Stop(ctx, runtime)
 		if err != nil {
 			return err
 		}
-		err = WaitUntilStopped(ctx, opTimeout)
+		err = WaitUntilStopped(ctx, runtime, opTimeout)
 		if err != nil {
 			return err
 		}
@@ -65,7 +68,7 @@ func ResetCache(ctx context.Context, console conslogging.ConsoleLogger, image st
 	if err != nil {
 		return err
 	}
-	err = WaitUntilStarted(ctx, Address, opTimeout)
+	err = WaitUntilStarted(ctx, Address(runtime), opTimeout)
 	if err != nil {
 		return err
 	}
@@ -78,27 +81,52 @@ func ResetCache(ctx context.Context, console conslogging.ConsoleLogger, image st
 // MaybeStart ensures that the buildkitd daemon is started. It returns the URL
 // that can be used to connect to it.
 func MaybeStart(ctx context.Context, console conslogging.ConsoleLogger, image string, settings Settings, opTimeout time.Duration) (string, error) {
-	isStarted, err := IsStarted(ctx)
+	runtime := settings.ContainerRuntime
+	isStarted, err := IsStarted(ctx, runtime)
 	if err != nil {
 		return "", errors.Wrap(err, "check is started buildkitd")
 	}
 	if isStarted {
 		console.
 			WithPrefix("buildkitd").
-			Printf("Found buildkit daemon as docker container (%s)\n", ContainerName)
+			Printf("Found buildkit daemon as %s container (%s)\n", runtime, ContainerName)
 		err := MaybeRestart(ctx, console, image, settings, opTimeout)
 		if err != nil {
 			return "", errors.Wrap(err, "maybe restart")
 		}
+		err = checkHealth(ctx, Address(runtime), opTimeout)
+		if err != nil {
+			console.
+				WithPrefix("buildkitd").
+				Printf("Buildkit daemon is not responding. Recreating it...\n")
+			err = recreate(ctx, image, settings, opTimeout)
+			if err != nil {
+				return "", errors.Wrap(err, "recreate")
+			}
+			console.
+				WithPrefix("buildkitd").
+				Printf("...Done\n")
+		}
 	} else {
-		console.
-			WithPrefix("buildkitd").
-			Printf("Starting buildkit daemon as a docker container (%s)...\n", ContainerName)
-		err := Start(ctx, image, settings, false)
+		found, err := exists(ctx, runtime)
+		if err != nil {
+			return "", errors.Wrap(err, "check exists buildkitd")
+		}
+		if found {
+			// Start replaces the container.
+			console.
+				WithPrefix("buildkitd").
+				Printf("Found a stopped buildkit daemon %s container (%s), which may have crashed. Recreating it...\n", runtime, ContainerName)
+		} else {
+			console.
+				WithPrefix("buildkitd").
+				Printf("Starting buildkit daemon as a %s container (%s)...\n", runtime, ContainerName)
+		}
+		err = Start(ctx, image, settings, false)
 		if err != nil {
 			return "", errors.Wrap(err, "start")
 		}
-		err = WaitUntilStarted(ctx, Address, opTimeout)
+		err = WaitUntilStarted(ctx, Address(runtime), opTimeout)
 		if err != nil {
 			return "", errors.Wrap(err, "wait until started")
 		}
@@ -106,27 +134,33 @@ func MaybeStart(ctx context.Context, console conslogging.ConsoleLogger, image st
 			WithPrefix("buildkitd").
 			Printf("...Done\n")
 	}
-	return Address, nil
+	return Address(runtime), nil
 }
 
 // MaybeRestart checks whether the there is a different buildkitd image available locally or if
 // settings of the current container are different from the provided settings. In either case,
 // the container is restarted.
 func MaybeRestart(ctx context.Context, console conslogging.ConsoleLogger, image string, settings Settings, opTimeout time.Duration) error {
-	containerImageID, err := GetContainerImageID(ctx)
+	runtime := settings.ContainerRuntime
+	containerImageID, err := GetContainerImageID(ctx, runtime)
 	if err != nil {
 		return err
 	}
-	availableImageID, err := GetAvailableImageID(ctx, image)
+	availableImageID, err := GetAvailableImageID(ctx, runtime, image)
 	if err != nil {
-		// Could not get available image ID. This happens when a new image tag is given and that
-		// tag has not yet been pulled locally. Restarting will cause that tag to be pulled.
-		availableImageID = "" // Will cause equality to fail and force a restart.
-		// Keep going anyway.
+		if settings.NoPull {
+			// The image cannot be pulled: keep the image of the running container.
+			availableImageID = containerImageID
+		} else {
+			// Could not get available image ID. This happens when a new image tag is given and that
+			// tag has not yet been pulled locally. Restarting will cause that tag to be pulled.
+			availableImageID = "" // Will cause equality to fail and force a restart.
+			// Keep going anyway.
+		}
 	}
 	if containerImageID == availableImageID {
 		// Images are the same. Check settings hash.
-		hash, err := GetSettingsHash(ctx)
+		hash, err := GetSettingsHash(ctx, runtime)
 		if err != nil {
 			return err
 		}
@@ -149,11 +183,11 @@ func MaybeRestart(ctx context.Context, console conslogging.ConsoleLogger, image
 	}
 
 	// Replace.
-	err = Stop(ctx)
+	err = Stop(ctx, runtime)
 	if err != nil {
 		return err
 	}
-	err = WaitUntilStopped(ctx, opTimeout)
+	err = WaitUntilStopped(ctx, runtime, opTimeout)
 	if err != nil {
 		return err
 	}
@@ -161,7 +195,7 @@ func MaybeRestart(ctx context.Context, console conslogging.ConsoleLogger, image
 	if err != nil {
 		return err
 	}
-	err = WaitUntilStarted(ctx, Address, opTimeout)
+	err = WaitUntilStarted(ctx, Address(runtime), opTimeout)
 	if err != nil {
 		return err
 	}
@@ -171,9 +205,78 @@ func MaybeRestart(ctx context.Context, console conslogging.ConsoleLogger, image
 	return nil
 }
 
+// healthCheckTimeout is how long a running buildkitd has to respond, before it is considered
+// unhealthy and recreated.
+const healthCheckTimeout = 10 * time.Second
+
+// checkHealth returns an error if the buildkitd daemon does not become responsive within
+// healthCheckTimeout (or within opTimeout, if shorter).
+func checkHealth(ctx context.Context, address string, opTimeout time.Duration) error {
+	timeout := healthCheckTimeout
+	if opTimeout < timeout {
+		timeout = opTimeout
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := ping(ctxTimeout, address)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-time.After(1 * time.Second):
+			// Try again.
+		case <-ctxTimeout.Done():
+			return err
+		}
+	}
+}
+
+// ping returns an error if the buildkitd daemon does not respond to a request.
+func ping(ctx context.Context, address string) error {
+	bkClient, err := client.New(ctx, address)
+	if err != nil {
+		return errors.Wrap(err, "new buildkit client")
+	}
+	defer bkClient.Close()
+	_, err = bkClient.ListWorkers(ctx)
+	if err != nil {
+		return errors.Wrap(err, "list workers")
+	}
+	return nil
+}
+
+// recreate replaces the buildkitd container with a new one.
+func recreate(ctx context.Context, image string, settings Settings, opTimeout time.Duration) error {
+	runtime := settings.ContainerRuntime
+	err := Stop(ctx, runtime)
+	if err != nil {
+		return err
+	}
+	err = WaitUntilStopped(ctx, runtime, opTimeout)
+	if err != nil {
+		return err
+	}
+	err = Start(ctx, image, settings, false)
+	if err != nil {
+		return err
+	}
+	return WaitUntilStarted(ctx, Address(runtime), opTimeout)
+}
+
+// exists checks if the buildkitd container exists, whether it is running or not.
+func exists(ctx context.Context, runtime string) (bool, error) {
+	cmd := exec.CommandContext(ctx, runtime, "ps", "-a", "-q", "-f", fmt.Sprintf("name=%s", ContainerName))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return false, errors.Wrap(err, "get combined output")
+	}
+	return (len(output) != 0), nil
+}
+
 // RemoveExited removes any stopped or exited buildkitd containers
-func RemoveExited(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "-q", "-f", fmt.Sprintf("name=%s", ContainerName))
+func RemoveExited(ctx context.Context, runtime string) error {
+	cmd := exec.CommandContext(ctx, runtime, "ps", "-a", "-q", "-f", fmt.Sprintf("name=%s", ContainerName))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return errors.Wrap(err, "get combined output")
@@ -181,26 +284,40 @@ func RemoveExited(ctx context.Context) error {
 	if len(output) == 0 {
 		return nil
 	}
-	return exec.CommandContext(ctx, "docker", "rm", ContainerName).Run()
+	return exec.CommandContext(ctx, runtime, "rm", ContainerName).Run()
 }
 
 // Start starts the buildkitd daemon.
 func Start(ctx context.Context, image string, settings Settings, reset bool) error {
-	err := CheckCompatibility(ctx, settings)
-	if len(settings.AdditionalArgs) == 0 && err != nil {
-		return errors.Wrap(err, "compatibility")
+	runtime := settings.ContainerRuntime
+	if runtime == DockerRuntime {
+		err := CheckCompatibility(ctx, settings)
+		if len(settings.AdditionalArgs) == 0 && err != nil {
+			return errors.Wrap(err, "compatibility")
+		}
 	}
 
+	if settings.NoPull {
+		_, err := GetAvailableImageID(ctx, runtime, image)
+		if err != nil {
+			return fmt.Errorf(
+				"the buildkitd image %s is not available locally, and pulling it is disabled (offline mode); pull it with %s pull %s while online", image, runtime, image)
+		}
+	}
 	settingsHash, err := settings.Hash()
 	if err != nil {
 		return errors.Wrap(err, "settings hash")
 	}
-	err = RemoveExited(ctx)
+	err = RemoveExited(ctx, runtime)
 	if err != nil {
 		return err
 	}
 	env := os.Environ()
 	runMount := fmt.Sprintf("%s:/run/earthly:consistent", settings.RunDir)
+	if runtime == PodmanRuntime {
+		// The consistency option only exists in docker (for Docker Desktop).
+		runMount = fmt.Sprintf("%s:/run/earthly", settings.RunDir)
+	}
 	args := []string{
 		"run",
 		"-d",
@@ -226,7 +343,30 @@ func Start(ctx context.Context, image string, settings Settings, reset bool) err
 	args = append(args,
 		"-e", fmt.Sprintf("CACHE_SIZE_MB=%d", settings.CacheSizeMb),
 		"-e", fmt.Sprintf("GIT_URL_INSTEAD_OF=%s", settings.GitURLInsteadOf),
+		"-e", fmt.Sprintf("GIT_PROXY=%s", settings.GitProxy),
+		"-e", fmt.Sprintf("GIT_PROXIES=%s", settings.GitProxies),
 	)
+	// Proxy settings, for the fetches performed by buildkitd (e.g. git).
+	for _, kv := range []struct{ name, value string }{
+		{"HTTP_PROXY", settings.HTTPProxy},
+		{"HTTPS_PROXY", settings.HTTPSProxy},
+		{"NO_PROXY", settings.NoProxy},
+	} {
+		if kv.value == "" {
+			continue
+		}
+		args = append(args,
+			"-e", fmt.Sprintf("%s=%s", kv.name, kv.value),
+			"-e", fmt.Sprintf("%s=%s", strings.ToLower(kv.name), kv.value))
+	}
+
+	registrySettings, err := registryConfig(settings.RegistryMirrors)
+	if err != nil {
+		return err
+	}
+	if registrySettings != "" {
+		args = append(args, "-e", fmt.Sprintf("REGISTRY_SETTINGS=%s", registrySettings))
+	}
 
 	// Apply reset.
 	if reset {
@@ -234,18 +374,18 @@ func Start(ctx context.Context, image string, settings Settings, reset bool) err
 	}
 	// Execute.
 	args = append(args, image)
-	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd := exec.CommandContext(ctx, runtime, args...)
 	cmd.Env = env
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return errors.Wrapf(err, "docker run %s: %s", image, string(output))
+		return errors.Wrapf(err, "%s run %s: %s", runtime, image, string(output))
 	}
 	return nil
 }
 
 // Stop stops the buildkitd container.
-func Stop(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "docker", "stop", ContainerName)
+func Stop(ctx context.Context, runtime string) error {
+	cmd := exec.CommandContext(ctx, runtime, "stop", ContainerName)
 	_, err := cmd.CombinedOutput()
 	if err != nil {
 		return errors.Wrap(err, "get combined output")
@@ -254,8 +394,8 @@ func Stop(ctx context.Context) error {
 }
 
 // IsStarted checks if the buildkitd container has been started.
-func IsStarted(ctx context.Context) (bool, error) {
-	cmd := exec.CommandContext(ctx, "docker", "ps", "-q", "-f", fmt.Sprintf("name=%s", ContainerName))
+func IsStarted(ctx context.Context, runtime string) (bool, error) {
+	cmd := exec.CommandContext(ctx, runtime, "ps", "-q", "-f", fmt.Sprintf("name=%s", ContainerName))
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return false, errors.Wrap(err, "get combined output")
@@ -292,8 +432,8 @@ func WaitUntilStarted(ctx context.Context, address string, opTimeout time.Durati
 }
 
 // GetContainerIP returns the IP of the buildkit container.
-func GetContainerIP(ctx context.Context) (string, error) {
-	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}", ContainerName)
+func GetContainerIP(ctx context.Context, runtime string) (string, error) {
+	cmd := exec.CommandContext(ctx, runtime, "inspect", "-f", "{{range.NetworkSettings.Networks}}{{.IPAddress}}{{end}}", ContainerName)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", errors.Wrap(err, "get combined output ip")
@@ -302,14 +442,14 @@ func GetContainerIP(ctx context.Context) (string, error) {
 }
 
 // WaitUntilStopped waits until the buildkitd daemon has stopped.
-func WaitUntilStopped(ctx context.Context, opTimeout time.Duration) error {
+func WaitUntilStopped(ctx context.Context, runtime string, opTimeout time.Duration) error {
 	ctxTimeout, cancel := context.WithTimeout(ctx, opTimeout)
 	defer cancel()
 	for {
 		select {
 		case <-time.After(1 * time.Second):
 			cmd := exec.CommandContext(
-				ctx, "docker", "inspect", "--format={{.State.Running}}", ContainerName)
+				ctx, runtime, "inspect", "--format={{.State.Running}}", ContainerName)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
 				// The container can no longer be found at all.
@@ -329,9 +469,9 @@ func WaitUntilStopped(ctx context.Context, opTimeout time.Duration) error {
 }
 
 // GetSettingsHash fetches the hash of the currently running buildkitd container.
-func GetSettingsHash(ctx context.Context) (string, error) {
+func GetSettingsHash(ctx context.Context, runtime string) (string, error) {
 	cmd := exec.CommandContext(ctx,
-		"docker", "inspect",
+		runtime, "inspect",
 		"--format={{index .Config.Labels \"dev.earthly.settingshash\"}}",
 		ContainerName)
 	output, err := cmd.CombinedOutput()
@@ -342,9 +482,9 @@ func GetSettingsHash(ctx context.Context) (string, error) {
 }
 
 // GetContainerImageID fetches the ID of the image used for the running buildkitd container.
-func GetContainerImageID(ctx context.Context) (string, error) {
+func GetContainerImageID(ctx context.Context, runtime string) (string, error) {
 	cmd := exec.CommandContext(ctx,
-		"docker", "inspect", "--format={{index .Image}}", ContainerName)
+		runtime, "inspect", "--format={{index .Image}}", ContainerName)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", errors.Wrap(err, "get output for container image ID")
@@ -353,9 +493,9 @@ func GetContainerImageID(ctx context.Context) (string, error) {
 }
 
 // GetAvailableImageID fetches the ID of the image buildkitd image available.
-func GetAvailableImageID(ctx context.Context, image string) (string, error) {
+func GetAvailableImageID(ctx context.Context, runtime, image string) (string, error) {
 	cmd := exec.CommandContext(ctx,
-		"docker", "inspect", "--format={{index .Id}}", image)
+		runtime, "inspect", "--format={{index .Id}}", image)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", errors.Wrap(err, "get output for available image ID")
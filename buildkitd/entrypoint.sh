@@ -63,6 +63,20 @@ if [ -n "$GIT_URL_INSTEAD_OF" ]; then
     done
 fi
 
+if [ -n "$GIT_PROXY" ]; then
+    git config --global http.proxy "$GIT_PROXY"
+fi
+
+if [ -n "$GIT_PROXIES" ]; then
+    # GIT_PROXIES can support multiple comma-separated <url>=<proxy> values
+    for git_proxy in $(echo "${GIT_PROXIES}" | sed "s/,/ /g")
+    do
+        url="${git_proxy%%=*}"
+        proxy="${git_proxy#*=}"
+        git config --global http."$url".proxy "$proxy"
+    done
+fi
+
 # Set up buildkit cache.
 export BUILDKIT_ROOT_DIR="$EARTHLY_TMP_DIR"/buildkit
 mkdir -p "$BUILDKIT_ROOT_DIR"
@@ -71,6 +85,74 @@ if [ "$CACHE_SIZE_MB" -gt "0" ]; then
     CACHE_SETTINGS="$(envsubst </etc/buildkitd.cache.template)"
 fi
 export CACHE_SETTINGS
+
+# The registry tables of EARTHLY_ADDITIONAL_BUILDKIT_CONFIG may also be generated from the
+# registry mirrors (REGISTRY_SETTINGS). TOML does not allow defining a table twice, so the
+# keys of such tables are merged into those of EARTHLY_ADDITIONAL_BUILDKIT_CONFIG, whose
+# values take precedence.
+if [ -n "$REGISTRY_SETTINGS" ] && [ -n "$EARTHLY_ADDITIONAL_BUILDKIT_CONFIG" ]; then
+    EARTHLY_ADDITIONAL_BUILDKIT_CONFIG="$(awk '
+        function norm(header) {
+            gsub(/[ \t"\047]/, "", header)
+            return header
+        }
+        function key(line) {
+            if (line !~ /^[ \t]*[A-Za-z0-9_-]+[ \t]*=/) {
+                return ""
+            }
+            sub(/^[ \t]*/, "", line)
+            sub(/[ \t]*=.*$/, "", line)
+            return line
+        }
+        BEGIN {
+            n = split(ENVIRON["REGISTRY_SETTINGS"], ours, "\n")
+            for (i = 1; i <= n; i++) {
+                if (ours[i] ~ /^[ \t]*\[/) {
+                    table = norm(ours[i])
+                    headers[table] = ours[i]
+                    order[++ntables] = table
+                } else if (ours[i] != "") {
+                    lines[table] = lines[table] ours[i] "\n"
+                    keys[table, key(ours[i])] = 1
+                }
+            }
+            m = split(ENVIRON["EARTHLY_ADDITIONAL_BUILDKIT_CONFIG"], theirs, "\n")
+            table = ""
+            for (i = 1; i <= m; i++) {
+                if (theirs[i] ~ /^[ \t]*\[/) {
+                    table = norm(theirs[i])
+                    defined[table] = 1
+                } else if (key(theirs[i]) != "") {
+                    overridden[table, key(theirs[i])] = 1
+                }
+            }
+            for (i = 1; i <= m; i++) {
+                print theirs[i]
+                if (theirs[i] !~ /^[ \t]*\[/) {
+                    continue
+                }
+                table = norm(theirs[i])
+                if (!(table in headers)) {
+                    continue
+                }
+                k = split(lines[table], tableLines, "\n")
+                for (j = 1; j <= k; j++) {
+                    if (tableLines[j] != "" && !((table, key(tableLines[j])) in overridden)) {
+                        print tableLines[j]
+                    }
+                }
+            }
+            for (t = 1; t <= ntables; t++) {
+                table = order[t]
+                if (!(table in defined)) {
+                    printf "%s\n%s", headers[table], lines[table]
+                }
+            }
+        }')"
+    export EARTHLY_ADDITIONAL_BUILDKIT_CONFIG
+    REGISTRY_SETTINGS=
+fi
+export REGISTRY_SETTINGS
 envsubst </etc/buildkitd.toml.template >/etc/buildkitd.toml
 echo "BUILDKIT_ROOT_DIR=$BUILDKIT_ROOT_DIR"
 echo "CACHE_SIZE_MB=$CACHE_SIZE_MB"
@@ -0,0 +1,35 @@
+// Package podmanconnhelper provides the connection helper for podman-container://<container>
+// buildkit hosts. It is the podman equivalent of buildkit's docker-container:// helper.
+package podmanconnhelper
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/docker/cli/cli/connhelper/commandconn"
+	"github.com/moby/buildkit/client/connhelper"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	connhelper.Register("podman-container", Helper)
+}
+
+// Helper returns a helper for connecting to a buildkitd instance running in a podman
+// container, by running "buildctl dial-stdio" in the container.
+func Helper(u *url.URL) (*connhelper.ConnectionHelper, error) {
+	if u.Scheme != "podman-container" {
+		return nil, errors.Errorf("invalid scheme %q for podman container", u.Scheme)
+	}
+	container := u.Hostname()
+	if container == "" {
+		return nil, errors.Errorf("no container specified in %s", u.String())
+	}
+	return &connhelper.ConnectionHelper{
+		ContextDialer: func(ctx context.Context, addr string) (net.Conn, error) {
+			// The background context is used as the process outlives the dial.
+			return commandconn.New(context.Background(), "podman", "exec", "-i", container, "buildctl", "dial-stdio")
+		},
+	}, nil
+}
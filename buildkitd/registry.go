@@ -0,0 +1,75 @@
+package buildkitd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultMirroredRegistry is the registry of the registry mirrors which do not specify one.
+const defaultMirroredRegistry = "docker.io"
+
+// ParseRegistryMirror parses a registry mirror of the form [<registry>=]<mirror>, where the
+// mirror is a host (with an optional port), optionally prefixed with http:// or https://. It
+// returns the mirrored registry, the mirror host and whether the mirror is plain http.
+func ParseRegistryMirror(s string) (string, string, bool, error) {
+	registry := defaultMirroredRegistry
+	mirror := s
+	if i := strings.Index(s, "="); i >= 0 {
+		registry = s[:i]
+		mirror = s[i+1:]
+	}
+	http := false
+	switch {
+	case strings.HasPrefix(mirror, "http://"):
+		mirror = strings.TrimPrefix(mirror, "http://")
+		http = true
+	case strings.HasPrefix(mirror, "https://"):
+		mirror = strings.TrimPrefix(mirror, "https://")
+	}
+	mirror = strings.TrimSuffix(mirror, "/")
+	if registry == "" || mirror == "" || strings.ContainsAny(registry+mirror, "/\" ") {
+		return "", "", false, fmt.Errorf("invalid registry mirror %q; expected [<registry>=]<host>[:<port>]", s)
+	}
+	return registry, mirror, http, nil
+}
+
+// registryConfig returns the buildkitd.toml registry sections which configure the given
+// registry mirrors.
+func registryConfig(mirrors []string) (string, error) {
+	mirrorsByRegistry := make(map[string][]string)
+	httpMirrors := make(map[string]bool)
+	for _, m := range mirrors {
+		registry, mirror, http, err := ParseRegistryMirror(m)
+		if err != nil {
+			return "", err
+		}
+		mirrorsByRegistry[registry] = append(mirrorsByRegistry[registry], mirror)
+		if http {
+			httpMirrors[mirror] = true
+		}
+	}
+	var registries []string
+	for registry := range mirrorsByRegistry {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+
+	var sb strings.Builder
+	for _, registry := range registries {
+		var quoted []string
+		for _, mirror := range mirrorsByRegistry[registry] {
+			quoted = append(quoted, fmt.Sprintf("%q", mirror))
+		}
+		fmt.Fprintf(&sb, "[registry.%q]\n  mirrors = [%s]\n", registry, strings.Join(quoted, ", "))
+	}
+	var insecure []string
+	for mirror := range httpMirrors {
+		insecure = append(insecure, mirror)
+	}
+	sort.Strings(insecure)
+	for _, mirror := range insecure {
+		fmt.Fprintf(&sb, "[registry.%q]\n  http = true\n", mirror)
+	}
+	return sb.String(), nil
+}
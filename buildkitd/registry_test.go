@@ -0,0 +1,39 @@
+package buildkitd
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRegistryConfig(t *testing.T) {
+	var tests = []struct {
+		mirrors  []string
+		expected string
+	}{
+		{nil, ""},
+		{
+			[]string{"mirror.gcr.io"},
+			"[registry.\"docker.io\"]\n  mirrors = [\"mirror.gcr.io\"]\n",
+		},
+		{
+			[]string{"https://mirror.example.com/", "quay.io=http://10.0.0.1:5000", "docker.io=mirror.gcr.io"},
+			"[registry.\"docker.io\"]\n  mirrors = [\"mirror.example.com\", \"mirror.gcr.io\"]\n" +
+				"[registry.\"quay.io\"]\n  mirrors = [\"10.0.0.1:5000\"]\n" +
+				"[registry.\"10.0.0.1:5000\"]\n  http = true\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := registryConfig(tt.mirrors)
+		NoError(t, err)
+		Equal(t, tt.expected, out)
+	}
+}
+
+func TestRegistryConfigInvalid(t *testing.T) {
+	for _, m := range []string{"", "docker.io=", "=mirror.gcr.io", "mirror.gcr.io/path"} {
+		_, err := registryConfig([]string{m})
+		Error(t, err, m)
+	}
+}
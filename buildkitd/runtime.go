@@ -0,0 +1,45 @@
+package buildkitd
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+const (
+	// DockerRuntime is the docker container runtime.
+	DockerRuntime = "docker"
+	// PodmanRuntime is the podman container runtime.
+	PodmanRuntime = "podman"
+)
+
+// DetectContainerRuntime returns the container runtime to use when none is specified:
+// docker if its CLI is available, otherwise podman if its CLI is available, and otherwise
+// docker.
+func DetectContainerRuntime() string {
+	if _, err := exec.LookPath(DockerRuntime); err == nil {
+		return DockerRuntime
+	}
+	if _, err := exec.LookPath(PodmanRuntime); err == nil {
+		return PodmanRuntime
+	}
+	return DockerRuntime
+}
+
+// ValidateContainerRuntime returns an error if the container runtime is not supported.
+func ValidateContainerRuntime(runtime string) error {
+	switch runtime {
+	case DockerRuntime, PodmanRuntime:
+		return nil
+	default:
+		return fmt.Errorf("unsupported container runtime %q (supported: %s, %s)", runtime, DockerRuntime, PodmanRuntime)
+	}
+}
+
+// Address returns the address at which the daemon is available, when run by the given
+// container runtime.
+func Address(runtime string) string {
+	if runtime == PodmanRuntime {
+		return fmt.Sprintf("podman-container://%s", ContainerName)
+	}
+	return fmt.Sprintf("docker-container://%s", ContainerName)
+}
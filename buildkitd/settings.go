@@ -17,6 +17,17 @@ type Settings struct {
 	Debug           bool     `json:"debug"`
 	DebuggerPort    int      `json:"debuggerPort"`
 	AdditionalArgs  []string `json:"additionalArgs"`
+	HTTPProxy       string   `json:"httpProxy"`
+	HTTPSProxy      string   `json:"httpsProxy"`
+	NoProxy         string   `json:"noProxy"`
+	GitProxy        string   `json:"gitProxy"`
+	GitProxies      string   `json:"gitProxies"`
+	RegistryMirrors []string `json:"registryMirrors"`
+	// NoPull prevents the buildkitd image from being pulled: only a locally available image
+	// may be used. It does not affect the daemon itself, and so it is not part of the hash.
+	NoPull bool `json:"-"`
+	// ContainerRuntime is the container runtime (docker or podman) which runs the daemon.
+	ContainerRuntime string `json:"-"`
 }
 
 // Hash returns a secure hash of the settings.
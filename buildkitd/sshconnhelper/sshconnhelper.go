@@ -0,0 +1,84 @@
+// Package sshconnhelper provides the connection helper for ssh://[user@]host[:port][/socket]
+// buildkit hosts.
+package sshconnhelper
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	"github.com/docker/cli/cli/connhelper/commandconn"
+	"github.com/moby/buildkit/client/connhelper"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	connhelper.Register("ssh", Helper)
+}
+
+// Helper returns a helper for connecting to a buildkitd instance on a remote host, over SSH.
+// The connection is proxied by running "buildctl dial-stdio" on the remote host, which thus
+// needs buildctl installed. Authentication is left to ssh, e.g. via the keys of the ssh-agent.
+func Helper(u *url.URL) (*connhelper.ConnectionHelper, error) {
+	sp, err := SpecFromURL(u)
+	if err != nil {
+		return nil, err
+	}
+	return &connhelper.ConnectionHelper{
+		ContextDialer: func(ctx context.Context, addr string) (net.Conn, error) {
+			// The background context is used as the process outlives the dial.
+			return commandconn.New(context.Background(), "ssh", sp.Args()...)
+		},
+	}, nil
+}
+
+// Spec is the spec of an ssh:// buildkit host.
+type Spec struct {
+	User string
+	Host string
+	Port string
+	// Socket is the path of the buildkitd socket on the remote host. If empty, the default
+	// socket of buildctl is used.
+	Socket string
+}
+
+// SpecFromURL creates a Spec from an ssh:// URL.
+func SpecFromURL(u *url.URL) (*Spec, error) {
+	if u.Scheme != "ssh" {
+		return nil, errors.Errorf("invalid scheme %q for ssh buildkit host", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.Errorf("no host specified in %s", u.String())
+	}
+	if _, ok := u.User.Password(); ok {
+		return nil, errors.New("passwords are not supported in ssh buildkit hosts; use the ssh-agent instead")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.Errorf("extra parts in ssh buildkit host %s", u.String())
+	}
+	sp := &Spec{
+		User: u.User.Username(),
+		Host: u.Hostname(),
+		Port: u.Port(),
+	}
+	if u.Path != "" && u.Path != "/" {
+		sp.Socket = u.Path
+	}
+	return sp, nil
+}
+
+// Args returns the arguments of the ssh command which proxies the connection.
+func (sp *Spec) Args() []string {
+	var args []string
+	if sp.User != "" {
+		args = append(args, "-l", sp.User)
+	}
+	if sp.Port != "" {
+		args = append(args, "-p", sp.Port)
+	}
+	args = append(args, "--", sp.Host, "buildctl")
+	if sp.Socket != "" {
+		args = append(args, "--addr", "unix://"+sp.Socket)
+	}
+	return append(args, "dial-stdio")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// listFormatter renders the records of a list command through the Go template given via
+// --format, one record per line.
+type listFormatter struct {
+	tmpl *template.Template
+}
+
+func newListFormatter(format string) (*listFormatter, error) {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse --format template")
+	}
+	return &listFormatter{tmpl: tmpl}, nil
+}
+
+func (lf *listFormatter) print(w io.Writer, record interface{}) error {
+	err := lf.tmpl.Execute(w, record)
+	if err != nil {
+		return errors.Wrap(err, "execute --format template")
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// orgRecord is the record of an org, as rendered by org list --format.
+type orgRecord struct {
+	Name string
+	// Role is either admin or member.
+	Role string
+}
+
+// tokenRecord is the record of an auth token, as rendered by account list-tokens --format
+// and --json.
+type tokenRecord struct {
+	Name    string    `json:"name"`
+	Write   bool      `json:"write"`
+	Expiry  time.Time `json:"expiry"`
+	Expired bool      `json:"expired"`
+}
+
+// secretRecord is the record of a secret, as rendered by secrets ls --format and --json.
+type secretRecord struct {
+	Path string `json:"path"`
+	// IsDir is set for the directories of secrets (whose paths end with a /).
+	IsDir bool `json:"isDir"`
+}
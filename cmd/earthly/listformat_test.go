@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestListFormatter(t *testing.T) {
+	lf, err := newListFormatter(`{{.Name}} {{if .Write}}rw{{else}}r{{end}} {{.Expiry.Format "2006-01-02"}}`)
+	NoError(t, err)
+	var buf bytes.Buffer
+	NoError(t, lf.print(&buf, tokenRecord{Name: "ci", Write: true, Expiry: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}))
+	NoError(t, lf.print(&buf, tokenRecord{Name: "laptop", Expiry: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)}))
+	Equal(t, "ci rw 2021-03-01\nlaptop r 2022-01-02\n", buf.String())
+
+	lf, err = newListFormatter("{{.Missing}}")
+	NoError(t, err)
+	Error(t, lf.print(&buf, secretRecord{Path: "/user/a"}))
+
+	_, err = newListFormatter("{{.Path")
+	Error(t, err)
+}
+
+func TestListRecordsJSON(t *testing.T) {
+	data, err := json.Marshal([]secretRecord{{Path: "/user/a"}, {Path: "/user/dir/", IsDir: true}})
+	NoError(t, err)
+	Equal(t, `[{"path":"/user/a","isDir":false},{"path":"/user/dir/","isDir":true}]`, string(data))
+
+	data, err = json.Marshal([]tokenRecord{{Name: "ci", Write: true, Expiry: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), Expired: true}})
+	NoError(t, err)
+	Equal(t, `[{"name":"ci","write":true,"expiry":"2021-03-01T00:00:00Z","expired":true}]`, string(data))
+}
@@ -8,20 +8,24 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	_ "net/http/pprof" // enable pprof handlers on net/http listener
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
-	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -32,15 +36,19 @@ import (
 	"github.com/earthly/earthly/buildcontext/provider"
 	"github.com/earthly/earthly/builder"
 	"github.com/earthly/earthly/buildkitd"
+	_ "github.com/earthly/earthly/buildkitd/sshconnhelper" // Load "ssh://" helper.
 	"github.com/earthly/earthly/cleanup"
 	"github.com/earthly/earthly/config"
 	"github.com/earthly/earthly/conslogging"
 	debuggercommon "github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/debugger/terminal"
+	"github.com/earthly/earthly/depgraph"
 	"github.com/earthly/earthly/docker2earthly"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/earthly/earthly/earthfileinit"
 	"github.com/earthly/earthly/fileutil"
+	"github.com/earthly/earthly/gitutil"
 	"github.com/earthly/earthly/llbutil"
 	"github.com/earthly/earthly/secretsclient"
 	"github.com/earthly/earthly/termutil"
@@ -65,16 +73,21 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
 )
 
 var dotEnvPath = ".env"
 
 type earthlyApp struct {
-	cliApp      *cli.App
-	console     conslogging.ConsoleLogger
-	cfg         *config.Config
-	sessionID   string
-	commandName string
+	cliApp  *cli.App
+	console conslogging.ConsoleLogger
+	// stdout receives the data output of commands. Logs and diagnostics go to the console.
+	stdout io.Writer
+	// logFileHandle is the file the console output is copied to, if --log-file is used.
+	logFileHandle *os.File
+	cfg           *config.Config
+	sessionID     string
+	commandName   string
 	cliFlags
 }
 
@@ -90,8 +103,12 @@ type cliFlags struct {
 	ci                     bool
 	noOutput               bool
 	noCache                bool
+	parseOnly              bool
 	pruneAll               bool
 	pruneReset             bool
+	pruneJSON              bool
+	pruneKeepBuilds        int
+	pruneBuildGap          time.Duration
 	buildkitdSettings      buildkitd.Settings
 	allowPrivileged        bool
 	enableProfiler         bool
@@ -115,6 +132,11 @@ type cliFlags struct {
 	disableNewLine         bool
 	secretFile             string
 	secretStdin            bool
+	secretExpiry           string
+	secretValidate         string
+	secretIfNotExists      bool
+	secretsWithPermissions bool
+	showSecretExpiry       bool
 	apiServer              string
 	writePermission        bool
 	registrationPublicKey  string
@@ -125,6 +147,63 @@ type cliFlags struct {
 	termsConditionsPrivacy bool
 	authToken              string
 	noFakeDep              bool
+	localGitTagDefault     bool
+	explainDot             bool
+	explainOutput          string
+	fmtCheck               bool
+	experimental           bool
+	quiet                  bool
+	color                  string
+	targetPadding          int
+	noTargetPadding        bool
+	logFile                string
+	recordPath             string
+	cacheMountSharing      string
+	noCacheMounts          bool
+	buildkitAttrs          cli.StringSlice
+	sourceDateEpoch        string
+	gitConfigFromHost      bool
+	initIgnore             bool
+	initForce              bool
+	debugTokens            bool
+	watch                  bool
+	outputJSONStream       string
+	offline                bool
+	containerRuntime       string
+	registryMirrors        cli.StringSlice
+	dockerfileTarget       string
+	docker2EarthlyStrict   bool
+	composePath            string
+	exportDockerfilePath   string
+	shutdownTimeoutS       int
+	printConfigPath        bool
+	printRunPath           bool
+	buildkitArgs           cli.StringSlice
+	logoutAll              bool
+	passwordStdin          bool
+	assumeYes              bool
+	orgListJSON            bool
+	secretsListJSON        bool
+	tokensListJSON         bool
+	noEarthSymlink         bool
+	isoExpiry              bool
+	secretsDefaultPath     string
+	listFormat             string
+	apiTimeout             time.Duration
+	apiRetries             int
+	outputDir              string
+	apiCACert              string
+	apiClientCert          string
+	apiClientKey           string
+	jsonSecretFiles        cli.StringSlice
+	yamlSecretFiles        cli.StringSlice
+	strictDuplicates       bool
+	explainCache           bool
+	cacheHintAll           bool
+	dryRun                 bool
+	buildArgFiles          cli.StringSlice
+	buildArgFileNewline    bool
+	strict                 bool
 }
 
 var (
@@ -138,12 +217,25 @@ var (
 	GitSha string
 )
 
+// detectedNoColor is the color setting detected from the terminal, before any overrides.
+var detectedNoColor = color.NoColor
+
 func profhandler() {
 	addr := "127.0.0.1:6060"
-	fmt.Printf("listening for pprof on %s\n", addr)
+	fmt.Fprintf(os.Stderr, "listening for pprof on %s\n", addr)
 	http.ListenAndServe(addr, nil)
 }
 
+const (
+	// minShutdownTimeoutS is the lowest shutdown timeout allowed, in seconds, so that the
+	// cleanup always gets a chance to run.
+	minShutdownTimeoutS = 5
+)
+
+// shutdownTimeout is how long to wait for the cleanup to complete after a signal is received,
+// before forcing an exit. It is accessed atomically, as it is set once the config is loaded.
+var shutdownTimeout = int64(30 * time.Second)
+
 func main() {
 	startTime := time.Now()
 	ctx := context.Background()
@@ -156,21 +248,28 @@ func main() {
 	}()
 	go func() {
 		receivedSignal := false
+		forwardedInterrupt := false
 		for {
 			select {
 			case sig := <-c:
+				if sig == syscall.SIGINT && !receivedSignal && !forwardedInterrupt && terminal.Interrupt() {
+					// An interactive debugging session is active: the interrupt is meant for
+					// its shell. A second signal cancels the build.
+					forwardedInterrupt = true
+					continue
+				}
 				cancel()
 				if receivedSignal {
 					// This is the second time we have received a signal. Quit immediately.
-					fmt.Printf("Received second signal %s. Forcing exit.\n", sig.String())
+					fmt.Fprintf(os.Stderr, "Received second signal %s. Forcing exit.\n", sig.String())
 					os.Exit(9)
 				}
 				receivedSignal = true
-				fmt.Printf("Received signal %s. Cleaning up before exiting...\n", sig.String())
+				fmt.Fprintf(os.Stderr, "Received signal %s. Cleaning up before exiting...\n", sig.String())
 				go func() {
-					// Wait for 30 seconds before forcing an exit.
-					time.Sleep(30 * time.Second)
-					fmt.Printf("Timed out cleaning up. Forcing exit.\n")
+					// Wait for the shutdown timeout before forcing an exit.
+					time.Sleep(time.Duration(atomic.LoadInt64(&shutdownTimeout)))
+					fmt.Fprintf(os.Stderr, "Timed out cleaning up. Forcing exit.\n")
 					os.Exit(9)
 				}()
 			}
@@ -184,7 +283,7 @@ func main() {
 	if fileutil.FileExists(dotEnvPath) {
 		err := godotenv.Load(dotEnvPath)
 		if err != nil {
-			fmt.Printf("Error loading dot-env file %s: %s\n", dotEnvPath, err.Error())
+			fmt.Fprintf(os.Stderr, "Error loading dot-env file %s: %s\n", dotEnvPath, err.Error())
 			os.Exit(1)
 		}
 	}
@@ -239,14 +338,44 @@ func getVersion() string {
 }
 
 func getBinaryName() string {
-	if len(os.Args) == 0 {
+	if len(os.Args) == 0 || os.Args[0] == "" {
 		return "earthly"
 	}
-	binPath := os.Args[0] // can't use os.Executable() here; because it will give us earthly if executed via the earth symlink
-	baseName := path.Base(binPath)
+	// can't use os.Executable() here; because it will give us earthly if executed via the earth symlink
+	return binaryName(os.Args[0])
+}
+
+// binaryName returns the name of the binary at the given path, without the .exe extension
+// used on Windows.
+func binaryName(binPath string) string {
+	baseName := filepath.Base(binPath)
+	if ext := filepath.Ext(baseName); strings.EqualFold(ext, ".exe") {
+		baseName = strings.TrimSuffix(baseName, ext)
+	}
 	return baseName
 }
 
+// invokedBinaryPath returns the absolute path of the binary that was invoked as arg0 (i.e.
+// os.Args[0]). A bare name was found via PATH, so it is looked up the same way. Symlinks
+// are not resolved, so that the earth symlink is not mistaken for earthly.
+func invokedBinaryPath(arg0 string, lookPath func(string) (string, error)) (string, error) {
+	if arg0 == "" {
+		return "", errors.New("empty binary path")
+	}
+	if !strings.ContainsAny(arg0, `/\`) {
+		found, err := lookPath(arg0)
+		if err != nil {
+			return "", errors.Wrapf(err, "look up %s", arg0)
+		}
+		arg0 = found
+	}
+	absPath, err := filepath.Abs(arg0)
+	if err != nil {
+		return "", errors.Wrapf(err, "absolute path of %s", arg0)
+	}
+	return absPath, nil
+}
+
 func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *earthlyApp {
 	sessionIDBytes := make([]byte, 64)
 	_, err := rand.Read(sessionIDBytes)
@@ -256,6 +385,7 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 	app := &earthlyApp{
 		cliApp:    cli.NewApp(),
 		console:   console,
+		stdout:    os.Stdout,
 		sessionID: base64.StdEncoding.EncodeToString(sessionIDBytes),
 		cliFlags: cliFlags{
 			buildkitdSettings: buildkitd.Settings{},
@@ -282,7 +412,7 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 		&cli.StringSliceFlag{
 			Name:    "platform",
 			EnvVars: []string{"EARTHLY_PLATFORMS"},
-			Usage:   "Specify the target platform to build for *experimental*",
+			Usage:   "Specify the target platform to build for (native for the platform of buildkit, local for the platform of the host) *experimental*",
 			Value:   &app.platformsStr,
 		},
 		&cli.StringSliceFlag{
@@ -291,6 +421,18 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:   "A build arg override, specified as <key>=[<value>]",
 			Value:   &app.buildArgs,
 		},
+		&cli.StringSliceFlag{
+			Name:    "build-arg-file",
+			EnvVars: []string{"EARTHLY_BUILD_ARG_FILES"},
+			Usage:   "A build arg override, read from a file, specified as <key>=<path>",
+			Value:   &app.buildArgFiles,
+		},
+		&cli.BoolFlag{
+			Name:        "build-arg-file-keep-newline",
+			EnvVars:     []string{"EARTHLY_BUILD_ARG_FILE_KEEP_NEWLINE"},
+			Usage:       "Keep the trailing new line of the files given via --build-arg-file",
+			Destination: &app.buildArgFileNewline,
+		},
 		&cli.StringSliceFlag{
 			Name:    "secret",
 			Aliases: []string{"s"},
@@ -304,6 +446,24 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:   "A secret override, specified as <key>=<path>",
 			Value:   &app.secretFiles,
 		},
+		&cli.StringSliceFlag{
+			Name:    "secret-file-json",
+			EnvVars: []string{"EARTHLY_SECRET_FILES_JSON"},
+			Usage:   "A JSON file of secret overrides, as a map of names to values",
+			Value:   &app.jsonSecretFiles,
+		},
+		&cli.StringSliceFlag{
+			Name:    "secret-file-yaml",
+			EnvVars: []string{"EARTHLY_SECRET_FILES_YAML"},
+			Usage:   "A YAML file of secret overrides, as a map of names to values",
+			Value:   &app.yamlSecretFiles,
+		},
+		&cli.BoolFlag{
+			Name:        "strict-duplicates",
+			EnvVars:     []string{"EARTHLY_STRICT_DUPLICATES"},
+			Usage:       "Fail if a build arg is given more than once (including via .env), as for secrets",
+			Destination: &app.strictDuplicates,
+		},
 		&cli.BoolFlag{
 			Name:        "artifact",
 			Aliases:     []string{"a"},
@@ -339,12 +499,61 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       wrap("Do not output artifacts or images", "(using --push is still allowed)"),
 			Destination: &app.noOutput,
 		},
+		&cli.StringFlag{
+			Name:        "output-dir",
+			EnvVars:     []string{"EARTHLY_OUTPUT_DIR"},
+			Usage:       "The directory under which all the local artifacts are written, whatever their AS LOCAL paths",
+			Destination: &app.outputDir,
+		},
 		&cli.BoolFlag{
 			Name:        "no-cache",
 			EnvVars:     []string{"EARTHLY_NO_CACHE"},
 			Usage:       "Do not use cache while building",
 			Destination: &app.noCache,
 		},
+		&cli.BoolFlag{
+			Name:        "parse-only",
+			EnvVars:     []string{"EARTHLY_PARSE_ONLY"},
+			Usage:       "Check that the Earthfiles of the target are valid, without building and without connecting to buildkit",
+			Destination: &app.parseOnly,
+		},
+		&cli.BoolFlag{
+			Name:        "explain-cache",
+			EnvVars:     []string{"EARTHLY_EXPLAIN_CACHE"},
+			Usage:       "Print whether each step was served from the cache, and why not",
+			Destination: &app.explainCache,
+		},
+		&cli.BoolFlag{
+			Name:        "dry-run",
+			EnvVars:     []string{"EARTHLY_DRY_RUN"},
+			Usage:       "Print the commands that the build would execute, without building",
+			Destination: &app.dryRun,
+		},
+		&cli.StringFlag{
+			Name:        "cache-mount-sharing",
+			EnvVars:     []string{"EARTHLY_CACHE_MOUNT_SHARING"},
+			Usage:       "The sharing mode of RUN --mount=type=cache mounts which do not specify one: shared, private or locked",
+			Value:       "shared",
+			Destination: &app.cacheMountSharing,
+		},
+		&cli.BoolFlag{
+			Name:        "no-cache-mounts",
+			EnvVars:     []string{"EARTHLY_NO_CACHE_MOUNTS"},
+			Usage:       "Start RUN --mount=type=cache mounts empty, to verify clean builds",
+			Destination: &app.noCacheMounts,
+		},
+		&cli.StringSliceFlag{
+			Name:    "buildkit-attr",
+			EnvVars: []string{"EARTHLY_BUILDKIT_ATTRS"},
+			Usage:   "An additional exporter:<key>=<value> or cache-export:<key>=<value> attribute to pass to buildkit",
+			Value:   &app.buildkitAttrs,
+		},
+		&cli.StringFlag{
+			Name:        "source-date-epoch",
+			EnvVars:     []string{"EARTHLY_SOURCE_DATE_EPOCH", "SOURCE_DATE_EPOCH"},
+			Usage:       "The unix timestamp of the SOURCE_DATE_EPOCH builtin arg, instead of the git commit timestamp",
+			Destination: &app.sourceDateEpoch,
+		},
 		&cli.StringFlag{
 			Name:        "config",
 			Value:       defaultConfigPath(),
@@ -352,6 +561,22 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Path to config file",
 			Destination: &app.configPath,
 		},
+		&cli.StringFlag{
+			Name:        "secrets-default-path",
+			EnvVars:     []string{"EARTHLY_SECRETS_DEFAULT_PATH"},
+			Usage:       "The path prefix (e.g. /my-org/) of the relative secret paths of the secrets commands",
+			Destination: &app.secretsDefaultPath,
+		},
+		&cli.BoolFlag{
+			Name:        "print-config-path",
+			Usage:       "Print the path of the config file in use and exit",
+			Destination: &app.printConfigPath,
+		},
+		&cli.BoolFlag{
+			Name:        "print-run-path",
+			Usage:       "Print the path of the run directory in use and exit",
+			Destination: &app.printRunPath,
+		},
 		&cli.StringFlag{
 			Name:        "ssh-auth-sock",
 			Value:       os.Getenv("SSH_AUTH_SOCK"),
@@ -384,6 +609,12 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       wrap("Rewrite git URLs of a certain pattern. Similar to git-config url.", "<base>.insteadOf (https://git-scm.com/docs/git-config#Documentation/git-config.txt-urlltbasegtinsteadOf).", "Multiple values can be separated by commas. Format: <base>=<instead-of>[,...]. ", "For example: 'https://github.com/=git@github.com:'"),
 			Destination: &app.buildkitdSettings.GitURLInsteadOf,
 		},
+		&cli.BoolFlag{
+			Name:        "git-config-from-host",
+			EnvVars:     []string{"EARTHLY_GIT_CONFIG_FROM_HOST"},
+			Usage:       "Use the url insteadOf rules and the credential helpers of the host's git config when fetching remote targets",
+			Destination: &app.gitConfigFromHost,
+		},
 		&cli.BoolFlag{
 			Name:        "allow-privileged",
 			Aliases:     []string{"P"},
@@ -401,7 +632,7 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 		&cli.StringFlag{
 			Name:        "buildkit-host",
 			EnvVars:     []string{"EARTHLY_BUILDKIT_HOST"},
-			Usage:       wrap("The URL to use for connecting to a buildkit host. ", "If empty, earthly will attempt to start a buildkitd instance via docker run"),
+			Usage:       wrap("The URL to use for connecting to a buildkit host (e.g. tcp://host:1234 or ssh://user@host). ", "If empty, earthly will attempt to start a buildkitd instance via docker run"),
 			Destination: &app.buildkitHost,
 		},
 		&cli.IntFlag{
@@ -418,18 +649,61 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "The docker image to use for the buildkit daemon",
 			Destination: &app.buildkitdImage,
 		},
+		&cli.StringSliceFlag{
+			Name:    "buildkit-arg",
+			EnvVars: []string{"EARTHLY_BUILDKIT_ARGS"},
+			Usage:   "An additional argument passed to the container runtime when starting the buildkit daemon, after those of the config; repeat the flag for each argument",
+			Value:   &app.buildkitArgs,
+		},
+		&cli.StringFlag{
+			Name:        "container-runtime",
+			EnvVars:     []string{"EARTHLY_CONTAINER_RUNTIME"},
+			Usage:       "The container runtime (docker or podman) used to run the buildkit daemon and to load images (default: docker if available, otherwise podman)",
+			Destination: &app.containerRuntime,
+		},
+		&cli.StringSliceFlag{
+			Name:    "registry-mirror",
+			EnvVars: []string{"EARTHLY_REGISTRY_MIRRORS"},
+			Usage:   "A pull-through mirror used by the buildkit daemon for image pulls, specified as [<registry>=]<host> (the registry defaults to docker.io)",
+			Value:   &app.registryMirrors,
+		},
 		&cli.StringFlag{
 			Name:        "remote-cache",
 			EnvVars:     []string{"EARTHLY_REMOTE_CACHE"},
 			Usage:       "A remote docker image tag use as explicit cache *experimental*",
 			Destination: &app.remoteCache,
 		},
+		&cli.BoolFlag{
+			Name:        "watch",
+			EnvVars:     []string{"EARTHLY_WATCH"},
+			Usage:       "Rebuild the target each time its build context changes, reusing the builder and its session attachables",
+			Destination: &app.watch,
+		},
+		&cli.BoolFlag{
+			Name:        "offline",
+			Aliases:     []string{"no-buildkit-pull"},
+			EnvVars:     []string{"EARTHLY_OFFLINE"},
+			Usage:       "Never pull the buildkitd image or fetch remote targets; fail early instead",
+			Destination: &app.offline,
+		},
+		&cli.StringFlag{
+			Name:        "output-json-stream",
+			EnvVars:     []string{"EARTHLY_OUTPUT_JSON_STREAM"},
+			Usage:       "Also write the build progress to the given file (e.g. /dev/fd/3), as newline-delimited JSON events",
+			Destination: &app.outputJSONStream,
+		},
 		&cli.BoolFlag{
 			Name:        "max-remote-cache",
 			EnvVars:     []string{"EARTHLY_MAX_REMOTE_CACHE"},
 			Usage:       "Saves all intermediate images too in the remove cache *experimental*",
 			Destination: &app.maxRemoteCache,
 		},
+		&cli.BoolFlag{
+			Name:        "cache-hint-all",
+			EnvVars:     []string{"EARTHLY_CACHE_HINT_ALL"},
+			Usage:       "Saves the result of every target in the remote cache, as if each used SAVE IMAGE --cache-hint *experimental*",
+			Destination: &app.cacheHintAll,
+		},
 		&cli.BoolFlag{
 			Name:        "save-inline-cache",
 			EnvVars:     []string{"EARTHLY_SAVE_INLINE_CACHE"},
@@ -442,6 +716,12 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       wrap("Attempt to use any inline cache that may have been previously pushed ", "uses image tags referenced by SAVE IMAGE --push or SAVE IMAGE --cache-from", "*experimental*"),
 			Destination: &app.useInlineCache,
 		},
+		&cli.BoolFlag{
+			Name:        "local-git-tag-default",
+			EnvVars:     []string{"EARTHLY_LOCAL_GIT_TAG_DEFAULT"},
+			Usage:       wrap("Reference untagged remote targets of the current git repository ", "at the ref that is checked out locally"),
+			Destination: &app.localGitTagDefault,
+		},
 		&cli.BoolFlag{
 			Name:        "interactive",
 			Aliases:     []string{"i"},
@@ -449,6 +729,12 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Enable interactive debugging",
 			Destination: &app.interactiveDebugging,
 		},
+		&cli.StringFlag{
+			Name:        "record",
+			EnvVars:     []string{"EARTHLY_RECORD"},
+			Usage:       "Record the interactive debugging sessions to an asciinema cast file; requires --interactive",
+			Destination: &app.recordPath,
+		},
 		&cli.BoolFlag{
 			Name:        "verbose",
 			Aliases:     []string{"V"},
@@ -456,6 +742,57 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Enable verbose logging",
 			Destination: &app.verbose,
 		},
+		&cli.BoolFlag{
+			Name:        "quiet",
+			Aliases:     []string{"q"},
+			EnvVars:     []string{"EARTHLY_QUIET"},
+			Usage:       "Only print warnings and errors",
+			Destination: &app.quiet,
+		},
+		&cli.BoolFlag{
+			Name:        "assume-yes",
+			Aliases:     []string{"y"},
+			EnvVars:     []string{"EARTHLY_ASSUME_YES"},
+			Usage:       "Automatically confirm destructive operations, instead of asking for a confirmation",
+			Destination: &app.assumeYes,
+		},
+		&cli.BoolFlag{
+			Name:        "strict",
+			EnvVars:     []string{"EARTHLY_STRICT"},
+			Usage:       "Fail on the use of deprecated flags, settings and commands, instead of warning",
+			Destination: &app.strict,
+		},
+		&cli.StringFlag{
+			Name:        "color",
+			EnvVars:     []string{"EARTHLY_COLOR"},
+			Usage:       "Whether to use colors in the output: auto, always or never; overrides FORCE_COLOR and NO_COLOR",
+			Value:       "auto",
+			Destination: &app.color,
+		},
+		&cli.IntFlag{
+			Name:        "shutdown-timeout-s",
+			EnvVars:     []string{"EARTHLY_SHUTDOWN_TIMEOUT_S"},
+			Usage:       "How long to wait for the cleanup to complete after an interrupt, before forcing an exit, in seconds",
+			Value:       30,
+			Destination: &app.shutdownTimeoutS,
+		},
+		&cli.IntFlag{
+			Name:        "target-padding",
+			Usage:       "The width to pad target names to in the output; overrides EARTHLY_TARGET_PADDING",
+			Value:       conslogging.DefaultPadding,
+			Destination: &app.targetPadding,
+		},
+		&cli.BoolFlag{
+			Name:        "no-target-padding",
+			Usage:       "Print full target names in the output, without padding; overrides EARTHLY_FULL_TARGET",
+			Destination: &app.noTargetPadding,
+		},
+		&cli.StringFlag{
+			Name:        "log-file",
+			EnvVars:     []string{"EARTHLY_LOG_FILE"},
+			Usage:       "Also write the output (without colors) to the given file",
+			Destination: &app.logFile,
+		},
 		&cli.BoolFlag{
 			Name:        "debug",
 			Aliases:     []string{"D"},
@@ -463,6 +800,12 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Usage:       "Enable debug mode",
 			Destination: &app.debug,
 		},
+		&cli.BoolFlag{
+			Name:        "experimental",
+			EnvVars:     []string{"EARTHLY_EXPERIMENTAL"},
+			Usage:       "Acknowledge the use of experimental features, which may change or be removed in future releases",
+			Destination: &app.experimental,
+		},
 		&cli.StringFlag{
 			Name:        "server",
 			Value:       "https://api.earthly.dev",
@@ -471,6 +814,38 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			Destination: &app.apiServer,
 			Hidden:      true, // Internal.
 		},
+		&cli.DurationFlag{
+			Name:        "api-timeout",
+			EnvVars:     []string{"EARTHLY_API_TIMEOUT"},
+			Usage:       "How long to wait for each response of the API server (e.g. 30s or 2m), or 0 to wait indefinitely",
+			Value:       30 * time.Second,
+			Destination: &app.apiTimeout,
+		},
+		&cli.IntFlag{
+			Name:        "api-retries",
+			EnvVars:     []string{"EARTHLY_API_RETRIES"},
+			Usage:       "How many times to retry the read-only requests to the API server which fail due to transient errors",
+			Value:       secretsclient.DefaultRetries,
+			Destination: &app.apiRetries,
+		},
+		&cli.StringFlag{
+			Name:        "api-ca-cert",
+			EnvVars:     []string{"EARTHLY_API_CA_CERT"},
+			Usage:       "Path to a PEM file of additional CA certificates to trust when connecting to the API server",
+			Destination: &app.apiCACert,
+		},
+		&cli.StringFlag{
+			Name:        "api-client-cert",
+			EnvVars:     []string{"EARTHLY_API_CLIENT_CERT"},
+			Usage:       "Path to a PEM client certificate to present to the API server, for mutual TLS (requires --api-client-key)",
+			Destination: &app.apiClientCert,
+		},
+		&cli.StringFlag{
+			Name:        "api-client-key",
+			EnvVars:     []string{"EARTHLY_API_CLIENT_KEY"},
+			Usage:       "Path to the PEM private key of the --api-client-cert certificate",
+			Destination: &app.apiClientKey,
+		},
 		&cli.BoolFlag{
 			Name:        "no-fake-dep",
 			EnvVars:     []string{"EARTHLY_NO_FAKE_DEP"},
@@ -493,8 +868,21 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 					Hidden:      true, // only meant for use with homebrew formula
 					Destination: &app.homebrewSource,
 				},
+				&cli.BoolFlag{
+					Name:        "no-earth-symlink",
+					EnvVars:     []string{"EARTHLY_NO_EARTH_SYMLINK"},
+					Usage:       "Do not replace the legacy earth binary with a symlink to earthly",
+					Destination: &app.noEarthSymlink,
+				},
 			},
 		},
+		{
+			Name:        "completion",
+			Usage:       "Prints the shell completion script",
+			Description: "Prints the completion script of the given shell (bash, zsh or fish) to stdout",
+			UsageText:   "earthly [options] completion bash|zsh|fish",
+			Action:      app.actionCompletion,
+		},
 		{
 			Name:        "docker2earthly",
 			Usage:       "Convert a Dockerfile into Earthfile",
@@ -519,6 +907,53 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 					Usage:       "Name and tag for the built image; formatted as 'name:tag'",
 					Destination: &app.earthfileFinalImage,
 				},
+				&cli.StringFlag{
+					Name:        "target",
+					Usage:       "Only convert the given Dockerfile stage (name or index) and the stages it depends on (default: the last stage)",
+					Destination: &app.dockerfileTarget,
+				},
+				&cli.BoolFlag{
+					Name:        "strict",
+					Usage:       "Fail instead of approximating or dropping instructions which cannot be converted exactly",
+					Destination: &app.docker2EarthlyStrict,
+				},
+			},
+		},
+		{
+			Name:        "compose2earthly",
+			Usage:       "Convert a docker-compose file into Earthfile",
+			Description: "Converts the services of an existing docker-compose file which have a build section into Earthfile targets",
+			Hidden:      true, // Experimental.
+			Action:      app.actionCompose2Earthly,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "compose",
+					Usage:       "Path to docker-compose file input",
+					Value:       "docker-compose.yml",
+					Destination: &app.composePath,
+				},
+				&cli.StringFlag{
+					Name:        "earthfile",
+					Usage:       "Path to earthfile output, or - for stdout",
+					Value:       "Earthfile",
+					Destination: &app.earthfilePath,
+				},
+			},
+		},
+		{
+			Name:        "export-dockerfile",
+			Usage:       "Convert an Earthfile target into Dockerfile",
+			UsageText:   "earthly [options] export-dockerfile [--dockerfile <path>] <target-ref>",
+			Description: "Converts a target of an existing Earthfile into a Dockerfile, commenting out the commands which have no Dockerfile equivalent",
+			Hidden:      true, // Experimental.
+			Action:      app.actionExportDockerfile,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "dockerfile",
+					Usage:       "Path to dockerfile output, or - for stdout",
+					Value:       "-",
+					Destination: &app.exportDockerfilePath,
+				},
 			},
 		},
 		{
@@ -534,8 +969,20 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 				{
 					Name:      "list",
 					Usage:     "List organizations you belong to",
-					UsageText: "earthly [options] org list",
+					UsageText: "earthly [options] org list [--json|--format <template>]",
 					Action:    app.actionOrgList,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:        "json",
+							Usage:       "Print the organizations as JSON, along with their member counts (for admins)",
+							Destination: &app.orgListJSON,
+						},
+						&cli.StringFlag{
+							Name:        "format",
+							Usage:       "Print each organization via the given Go template; the fields are .Name and .Role",
+							Destination: &app.listFormat,
+						},
+					},
 				},
 				{
 					Name:      "list-permissions",
@@ -589,6 +1036,21 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 							Usage:       "Stores secret read from stdin",
 							Destination: &app.secretStdin,
 						},
+						&cli.StringFlag{
+							Name:        "expiry",
+							Usage:       "Set secret expiry date in the form YYYY-MM-DD or RFC 3339 (default never)",
+							Destination: &app.secretExpiry,
+						},
+						&cli.StringFlag{
+							Name:        "validate",
+							Usage:       "Reject the secret unless its value matches the given regular expression",
+							Destination: &app.secretValidate,
+						},
+						&cli.BoolFlag{
+							Name:        "if-not-exists",
+							Usage:       "Do not overwrite the secret if it already exists",
+							Destination: &app.secretIfNotExists,
+						},
 					},
 				},
 				{
@@ -602,13 +1064,40 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 							Usage:       "Disable newline at the end of the secret",
 							Destination: &app.disableNewLine,
 						},
+						&cli.BoolFlag{
+							Name:        "show-expiry",
+							Usage:       "Print the remaining lifetime of the secret to stderr",
+							Destination: &app.showSecretExpiry,
+						},
 					},
 				},
 				{
 					Name:      "ls",
 					Usage:     "List secrets in the secrets store",
-					UsageText: "earthly [options] secrets ls [<path>]",
+					UsageText: "earthly [options] secrets ls [options] [<path>]",
 					Action:    app.actionSecretsList,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:        "show-expiry",
+							Usage:       "Show the remaining lifetime of each secret",
+							Destination: &app.showSecretExpiry,
+						},
+						&cli.BoolFlag{
+							Name:        "with-permissions",
+							Usage:       "Show the org members which have access to each secret",
+							Destination: &app.secretsWithPermissions,
+						},
+						&cli.StringFlag{
+							Name:        "format",
+							Usage:       "Print each secret via the given Go template; the fields are .Path and .IsDir",
+							Destination: &app.listFormat,
+						},
+						&cli.BoolFlag{
+							Name:        "json",
+							Usage:       "Print the secrets as a JSON array of objects, with the fields path and isDir",
+							Destination: &app.secretsListJSON,
+						},
+					},
 				},
 				{
 					Name:      "rm",
@@ -649,6 +1138,11 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 							Usage:       "Specify password on the command line instead of interactively being asked",
 							Destination: &app.password,
 						},
+						&cli.BoolFlag{
+							Name:        "password-stdin",
+							Usage:       "Read the password from stdin instead of interactively being asked",
+							Destination: &app.passwordStdin,
+						},
 						&cli.StringFlag{
 							Name:        "public-key",
 							EnvVars:     []string{"EARTHLY_PUBLIC_KEY"},
@@ -670,6 +1164,7 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 					UsageText: "earthly [options] account login\n" +
 						"   earthly [options] account login --email <email>\n" +
 						"   earthly [options] account login --email <email> --password <password>\n" +
+						"   earthly [options] account login --email <email> --password-stdin\n" +
 						"   earthly [options] account login --token <token>\n",
 					Action: app.actionAccountLogin,
 					Flags: []cli.Flag{
@@ -689,13 +1184,26 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 							Usage:       "Specify password on the command line instead of interactively being asked",
 							Destination: &app.password,
 						},
+						&cli.BoolFlag{
+							Name:        "password-stdin",
+							Usage:       "Read the password from stdin instead of interactively being asked",
+							Destination: &app.passwordStdin,
+						},
 					},
 				},
 				{
 					Name:        "logout",
 					Usage:       "Logout of an Earthly account",
-					Description: "Logout of an Earthly account; this has no effect for ssh-based authentication",
+					Description: "Logout of an Earthly account; with --all, the keys of the ssh-agent are no longer tried until the next login",
+					UsageText:   "earthly [options] account logout [--all]",
 					Action:      app.actionAccountLogout,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:        "all",
+							Usage:       "Also stop trying the keys of the ssh-agent until the next login, and report the credentials which would still log you in",
+							Destination: &app.logoutAll,
+						},
+					},
 				},
 				{
 					Name:      "list-keys",
@@ -718,8 +1226,20 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 				{
 					Name:      "list-tokens",
 					Usage:     "List associated tokens used for authentication",
-					UsageText: "earthly [options] account list-tokens",
+					UsageText: "earthly [options] account list-tokens [--json|--format <template>]",
 					Action:    app.actionAccountListTokens,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:        "format",
+							Usage:       "Print each token via the given Go template; the fields are .Name, .Write, .Expiry and .Expired",
+							Destination: &app.listFormat,
+						},
+						&cli.BoolFlag{
+							Name:        "json",
+							Usage:       "Print the tokens as a JSON array of objects, with the fields name, write, expiry and expired",
+							Destination: &app.tokensListJSON,
+						},
+					},
 				},
 				{
 					Name:      "create-token",
@@ -737,6 +1257,11 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 							Usage:       "Set token expiry date in the form YYYY-MM-DD or never (default 1year)",
 							Destination: &app.expiry,
 						},
+						&cli.BoolFlag{
+							Name:        "iso-expiry",
+							Usage:       "Print the expiry of the token as an RFC 3339 timestamp too",
+							Destination: &app.isoExpiry,
+						},
 					},
 				},
 				{
@@ -754,6 +1279,77 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 			ArgsUsage:   "[<path>]",
 			Hidden:      true, // Dev purposes only.
 			Action:      app.actionDebug,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "tokens",
+					Usage:       "Print the tokens emitted by the lexer, instead of the parse tree",
+					Destination: &app.debugTokens,
+				},
+			},
+		},
+		{
+			Name:        "fmt",
+			Usage:       "Format an Earthfile",
+			Description: "Rewrite an Earthfile with canonical indentation and spacing",
+			ArgsUsage:   "[<path>]",
+			Action:      app.actionFmt,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "check",
+					EnvVars:     []string{"EARTHLY_FMT_CHECK"},
+					Usage:       "Do not rewrite the Earthfile; exit with an error if it is not formatted",
+					Destination: &app.fmtCheck,
+				},
+			},
+		},
+		{
+			Name:  "init",
+			Usage: "Create a starter Earthfile in the current directory",
+			Description: fmt.Sprintf("Write a starter Earthfile for a common stack into the current directory; available templates: %s (default %s)",
+				strings.Join(earthfileinit.Templates(), ", "), earthfileinit.DefaultTemplate),
+			ArgsUsage: "[<template>]",
+			Action:    app.actionInit,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "ignore",
+					Usage:       "Also write a " + buildcontext.EarthIgnoreFile + " file",
+					Destination: &app.initIgnore,
+				},
+				&cli.BoolFlag{
+					Name:        "force",
+					Usage:       "Overwrite existing files",
+					Destination: &app.initForce,
+				},
+			},
+		},
+		{
+			Name:        "explain",
+			Usage:       "Print the dependency tree of a target",
+			Description: "Print the tree of targets referenced by a target, without building it",
+			ArgsUsage:   "<target-ref>",
+			Action:      app.actionExplain,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:        "dot",
+					EnvVars:     []string{"EARTHLY_EXPLAIN_DOT"},
+					Usage:       "Print the dependency graph in Graphviz DOT format",
+					Destination: &app.explainDot,
+				},
+				&cli.StringFlag{
+					Name:        "output",
+					Aliases:     []string{"o"},
+					EnvVars:     []string{"EARTHLY_EXPLAIN_OUTPUT"},
+					Usage:       "Write the output to the given file instead of stdout",
+					Destination: &app.explainOutput,
+				},
+			},
+		},
+		{
+			Name:        "config",
+			Usage:       "Reads or writes a value of the earthly config file",
+			Description: "Prints the value of the given key (e.g. global.cache_size_mb) of the earthly config file, or sets it to the given value",
+			UsageText:   "earthly [options] config <key> [<value>]",
+			Action:      app.actionConfig,
 		},
 		{
 			Name:        "prune",
@@ -774,6 +1370,24 @@ func newEarthlyApp(ctx context.Context, console conslogging.ConsoleLogger) *eart
 					Usage:       "Reset cache entirely by wiping cache dir",
 					Destination: &app.pruneReset,
 				},
+				&cli.BoolFlag{
+					Name:        "json",
+					Usage:       "Print a JSON summary of the removed cache records",
+					Destination: &app.pruneJSON,
+				},
+				&cli.IntFlag{
+					Name:        "keep-builds",
+					EnvVars:     []string{"EARTHLY_PRUNE_KEEP_BUILDS"},
+					Usage:       "Keep the cache used by the last N builds and prune the older cache; builds are told apart by the gaps between the last uses of the cache (see --build-gap)",
+					Destination: &app.pruneKeepBuilds,
+				},
+				&cli.DurationFlag{
+					Name:        "build-gap",
+					EnvVars:     []string{"EARTHLY_PRUNE_BUILD_GAP"},
+					Usage:       "The minimum gap between the last uses of the cache which separates two builds, for --keep-builds (e.g. 5m or 1h)",
+					Value:       defaultPruneBuildGap,
+					Destination: &app.pruneBuildGap,
+				},
 			},
 		},
 	}
@@ -791,12 +1405,65 @@ func (app *earthlyApp) before(context *cli.Context) error {
 		go profhandler()
 	}
 
+	if context.IsSet("color") {
+		switch app.color {
+		case "auto":
+			app.console = app.console.WithColorMode(conslogging.AutoColor)
+			color.NoColor = detectedNoColor
+		case "always":
+			app.console = app.console.WithColorMode(conslogging.ForceColor)
+			color.NoColor = false
+		case "never":
+			app.console = app.console.WithColorMode(conslogging.NoColor)
+			color.NoColor = true
+		default:
+			return fmt.Errorf("invalid --color value %q: must be one of auto, always or never", app.color)
+		}
+	}
+	if context.IsSet("target-padding") {
+		if app.targetPadding < 0 {
+			return fmt.Errorf("invalid --target-padding value %d: must not be negative", app.targetPadding)
+		}
+		app.console = app.console.WithPrefixPadding(app.targetPadding)
+	}
+	if app.noTargetPadding {
+		app.console = app.console.WithPrefixPadding(conslogging.NoPadding)
+	}
+	if app.quiet {
+		app.console = app.console.WithQuiet(true)
+	}
+	if app.logFile != "" {
+		f, err := os.Create(app.logFile)
+		if err != nil {
+			return errors.Wrapf(err, "create log file %s", app.logFile)
+		}
+		app.logFileHandle = f
+		app.console = app.console.WithTee(f)
+	}
+	if (context.Args().Present() && app.cliApp.Command(context.Args().First()) != nil) ||
+		app.printConfigPath || app.printRunPath || app.parseOnly {
+		// Not a build: stdout is reserved for the data output of the command.
+		app.console = app.console.WithErrOutput()
+	}
+
 	if context.IsSet("config") {
 		app.console.Printf("loading config values from %q\n", app.configPath)
 	}
+	if context.Args().First() == "config" {
+		// The config command reads and writes the config file itself: it needs to work even
+		// if the config is invalid, e.g. to fix it, and it does not use the run directory.
+		return nil
+	}
+	if app.printConfigPath || app.printRunPath {
+		// Only the paths are printed: the run directory is not created, and nothing is
+		// asked, so that the output can be used by scripts.
+		return nil
+	}
 
 	yamlData, err := ioutil.ReadFile(app.configPath)
-	if os.IsNotExist(err) && !context.IsSet("config") {
+	if os.IsNotExist(err) && !configFlagPassed(context, app.configPath) {
+		// A missing default config file, or one set via EARTHLY_CONFIG (e.g. globally in a
+		// container image), means the default settings.
 		yamlData = []byte{}
 	} else if err != nil {
 		return errors.Wrapf(err, "failed to read from %s", app.configPath)
@@ -816,10 +1483,62 @@ func (app *earthlyApp) before(context *cli.Context) error {
 		return err
 	}
 
+	err = app.checkExperimentalFlags(context)
+	if err != nil {
+		return err
+	}
+	app.checkSSHAuthSock()
+
 	// command line option overrides the config which overrides the default value
 	if !context.IsSet("buildkit-image") && app.cfg.Global.BuildkitImage != "" {
 		app.buildkitdImage = app.cfg.Global.BuildkitImage
 	}
+	if !context.IsSet("container-runtime") && app.cfg.Global.ContainerRuntime != "" {
+		app.containerRuntime = app.cfg.Global.ContainerRuntime
+	}
+	if app.containerRuntime == "" {
+		app.containerRuntime = buildkitd.DetectContainerRuntime()
+	}
+	err = buildkitd.ValidateContainerRuntime(app.containerRuntime)
+	if err != nil {
+		return err
+	}
+
+	if context.IsSet("secrets-default-path") {
+		app.cfg.Secrets.DefaultPath = app.secretsDefaultPath
+	}
+	if !context.IsSet("shutdown-timeout-s") {
+		app.shutdownTimeoutS = app.cfg.Global.ShutdownTimeoutS
+	}
+	if app.shutdownTimeoutS < minShutdownTimeoutS {
+		app.console.Warnf("Warning: the shutdown timeout of %ds is too short; using %ds\n", app.shutdownTimeoutS, minShutdownTimeoutS)
+		app.shutdownTimeoutS = minShutdownTimeoutS
+	}
+	atomic.StoreInt64(&shutdownTimeout, int64(time.Duration(app.shutdownTimeoutS)*time.Second))
+	if !context.IsSet("api-timeout") {
+		app.apiTimeout = time.Duration(app.cfg.Global.APITimeoutS) * time.Second
+	}
+	if app.apiTimeout < 0 {
+		return errors.Errorf("invalid api timeout %s", app.apiTimeout)
+	}
+	if !context.IsSet("api-retries") {
+		app.apiRetries = app.cfg.Global.APIRetries
+	}
+	if app.apiRetries < 0 {
+		return errors.Errorf("invalid api retries %d", app.apiRetries)
+	}
+	if !context.IsSet("api-ca-cert") {
+		app.apiCACert = app.cfg.Global.APICACert
+	}
+	if !context.IsSet("api-client-cert") {
+		app.apiClientCert = app.cfg.Global.APIClientCert
+	}
+	if !context.IsSet("api-client-key") {
+		app.apiClientKey = app.cfg.Global.APIClientKey
+	}
+	if (app.apiClientCert == "") != (app.apiClientKey == "") {
+		return errors.New("the api client certificate and key must be given together (see --api-client-cert and --api-client-key)")
+	}
 
 	if !fileutil.DirExists(app.cfg.Global.RunPath) {
 		err := os.MkdirAll(app.cfg.Global.RunPath, 0755)
@@ -827,45 +1546,291 @@ func (app *earthlyApp) before(context *cli.Context) error {
 			return errors.Wrapf(err, "failed to create run directory %s", app.cfg.Global.RunPath)
 		}
 	}
+	err = fileutil.CheckDirWritable(app.cfg.Global.RunPath)
+	if err != nil {
+		return errors.Wrapf(err, "run directory %s is not writable; check its permissions and free space, or change global.run_path in %s", app.cfg.Global.RunPath, app.configPath)
+	}
 
 	app.buildkitdSettings.DebuggerPort = app.cfg.Global.DebuggerPort
 	app.buildkitdSettings.RunDir = app.cfg.Global.RunPath
-	app.buildkitdSettings.AdditionalArgs = app.cfg.Global.BuildkitAdditionalArgs
+	app.setBuildkitAdditionalArgs()
+	app.buildkitdSettings.NoPull = app.offline
+	app.buildkitdSettings.ContainerRuntime = app.containerRuntime
+	err = app.setRegistryMirrors(context)
+	if err != nil {
+		return err
+	}
+	err = app.setProxySettings()
+	if err != nil {
+		return err
+	}
 
-	return nil
-}
+	err = app.checkAnalyticsChoice(yamlData)
+	if err != nil {
+		return err
+	}
 
-func (app *earthlyApp) warnIfEarth() {
-	if len(os.Args) == 0 {
-		return
+	err = app.checkPinnedVersion()
+	if err != nil {
+		return err
 	}
-	binPath := os.Args[0] // can't use os.Executable() here; because it will give us earthly if executed via the earth symlink
 
-	baseName := path.Base(binPath)
-	if baseName == "earth" {
-		app.console.Warnf("Warning: the earth binary has been renamed to earthly; the earth command is currently symlinked, but is deprecated and will one day be removed.")
+	return nil
+}
 
-		absPath, err := filepath.Abs(binPath)
-		if err != nil {
-			return
-		}
-		earthlyPath := path.Join(path.Dir(absPath), "earthly")
-		if fileutil.FileExists(earthlyPath) {
-			app.console.Warnf("Once you are ready to switch over to earthly, you can `rm %s`", absPath)
+// analyticsNoticeFile is created next to the config file once the analytics notice has been
+// printed in a non-interactive context, so that it is only printed once.
+const analyticsNoticeFile = "analytics-notice"
+
+// checkAnalyticsChoice asks the user whether to share anonymous usage statistics, if they
+// have not made a choice yet, and persists the answer in the config file. In non-interactive
+// contexts, analytics stay enabled and a notice is printed instead, once. Failing to persist
+// the choice only results in a warning, as it must not prevent running the command.
+func (app *earthlyApp) checkAnalyticsChoice(yamlData []byte) error {
+	isSet, err := config.IsSet(yamlData, "global.disable_analytics")
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", app.configPath)
+	}
+	if isSet {
+		return nil
+	}
+	configDir := filepath.Dir(app.configPath)
+	// The answer is read from stdin, which may instead carry piped input meant for the command
+	// (e.g. --password-stdin).
+	if !termutil.IsTTY() || !termutil.IsInputTTY() {
+		noticePath := filepath.Join(configDir, analyticsNoticeFile)
+		if app.quiet || fileutil.FileExists(noticePath) {
+			return nil
+		}
+		app.console.Printf(
+			"Earthly collects anonymous usage statistics. To opt out, set global.disable_analytics to true in %s.\n"+
+				"For more information see https://docs.earthly.dev/earthly-config\n", app.configPath)
+		err := os.MkdirAll(configDir, 0755)
+		if err != nil {
+			app.console.Warnf("Warning: failed to create %s: %v\n", configDir, err)
+			return nil
+		}
+		err = ioutil.WriteFile(noticePath, []byte{}, 0644)
+		if err != nil {
+			app.console.Warnf("Warning: failed to create %s: %v\n", noticePath, err)
+		}
+		return nil
+	}
+
+	answer := promptInput("Earthly collects anonymous usage statistics, to help improve the tool. Share anonymous usage statistics? [Y/n] ")
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	disable := answer == "n" || answer == "no"
+	app.cfg.Global.DisableAnalytics = disable
+	newYAMLData, err := config.Upsert(yamlData, "global.disable_analytics", disable)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update %s", app.configPath)
+	}
+	err = app.writeConfigFile(newYAMLData)
+	if err != nil {
+		app.console.Warnf("Warning: failed to save your choice: %v\n", err)
+		return nil
+	}
+	app.console.Printf("Your choice has been saved in %s (global.disable_analytics)\n", app.configPath)
+	return nil
+}
+
+// writeConfigFile writes the given data to the config file, keeping its permissions if it
+// already exists.
+func (app *earthlyApp) writeConfigFile(yamlData []byte) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(app.configPath); err == nil {
+		mode = fi.Mode()
+	}
+	configDir := filepath.Dir(app.configPath)
+	err := os.MkdirAll(configDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", configDir)
+	}
+	err = ioutil.WriteFile(app.configPath, yamlData, mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %s", app.configPath)
+	}
+	return nil
+}
+
+// setBuildkitAdditionalArgs sets the additional args used to start buildkitd: the args of the
+// config, followed by those of the --buildkit-arg flag (so that the flag takes precedence for
+// the options given twice).
+func (app *earthlyApp) setBuildkitAdditionalArgs() {
+	args := append([]string{}, app.cfg.Global.BuildkitAdditionalArgs...)
+	args = append(args, app.buildkitArgs.Value()...)
+	if len(app.buildkitArgs.Value()) > 0 && app.buildkitHost != "" {
+		app.console.Warnf("Warning: --buildkit-arg only applies to the buildkit daemon started by earthly, not to %s\n", app.buildkitHost)
+	}
+	app.buildkitdSettings.AdditionalArgs = args
+}
+
+// setRegistryMirrors sets the registry mirrors of the buildkitd settings, from the
+// --registry-mirror flag or, failing that, from the config.
+func (app *earthlyApp) setRegistryMirrors(context *cli.Context) error {
+	mirrors := app.registryMirrors.Value()
+	if !context.IsSet("registry-mirror") {
+		mirrors = app.cfg.Global.RegistryMirrors
+	}
+	for _, m := range mirrors {
+		_, _, _, err := buildkitd.ParseRegistryMirror(m)
+		if err != nil {
+			return err
+		}
+	}
+	if len(mirrors) > 0 && app.buildkitHost != "" {
+		app.console.Warnf("Warning: registry mirrors only apply to the buildkit daemon started by earthly; configure them in the buildkitd.toml of %s instead\n", app.buildkitHost)
+	}
+	app.buildkitdSettings.RegistryMirrors = mirrors
+	return nil
+}
+
+// setProxySettings passes the proxy settings of the environment (HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY) and of the git section of the config to buildkitd, which performs the
+// fetches of remote targets.
+func (app *earthlyApp) setProxySettings() error {
+	app.buildkitdSettings.HTTPProxy = getEnvAnyCase("HTTP_PROXY")
+	app.buildkitdSettings.HTTPSProxy = getEnvAnyCase("HTTPS_PROXY")
+	app.buildkitdSettings.NoProxy = getEnvAnyCase("NO_PROXY")
+	if gitGlobal, ok := app.cfg.Git["global"]; ok {
+		app.buildkitdSettings.GitProxy = gitGlobal.Proxy
+	}
+	var sites []string
+	for k, v := range app.cfg.Git {
+		if k != "global" && v.Proxy != "" {
+			sites = append(sites, k)
+		}
+	}
+	// Keep a stable order, as the settings are hashed to detect changes.
+	sort.Strings(sites)
+	var gitProxies []string
+	for _, k := range sites {
+		proxy := app.cfg.Git[k].Proxy
+		if strings.Contains(proxy, ",") {
+			return fmt.Errorf("invalid proxy %q for git site %s", proxy, k)
+		}
+		gitProxies = append(gitProxies, fmt.Sprintf("https://%s/=%s", k, proxy))
+	}
+	app.buildkitdSettings.GitProxies = strings.Join(gitProxies, ",")
+	return nil
+}
+
+func getEnvAnyCase(name string) string {
+	value, ok := os.LookupEnv(name)
+	if ok {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// experimentalFlags are the flags which are marked *experimental* and which should be
+// used together with --experimental.
+var experimentalFlags = []string{
+	"platform",
+	"ci",
+	"remote-cache",
+	"max-remote-cache",
+	"save-inline-cache",
+	"use-inline-cache",
+	"cache-hint-all",
+}
+
+func (app *earthlyApp) checkExperimentalFlags(context *cli.Context) error {
+	if app.experimental {
+		return nil
+	}
+	for _, flag := range experimentalFlags {
+		if context.IsSet(flag) {
+			// TODO: Turn this into an error once the deprecation period is over.
+			err := app.deprecated("--%s is experimental and will require --experimental in a future release", flag)
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+// deprecated reports the use of a deprecated flag, setting or command: as a warning, or
+// as an error if --strict is set, so that CI can enforce the migration.
+func (app *earthlyApp) deprecated(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if app.strict {
+		return errors.Errorf("%s (failing due to --strict)", msg)
+	}
+	app.console.Warnf("Warning: %s\n", msg)
+	return nil
+}
+
+// checkSSHAuthSock warns if the configured SSH auth socket is unusable, as ssh-agent
+// forwarding would otherwise silently be skipped.
+func (app *earthlyApp) checkSSHAuthSock() {
+	if app.sshAuthSock == "" {
+		return
+	}
+	fi, err := os.Stat(app.sshAuthSock)
+	if os.IsNotExist(err) {
+		app.console.Warnf("Warning: the SSH auth socket %s does not exist; ssh-agent forwarding will not be available\n", app.sshAuthSock)
+		return
+	}
+	if err != nil {
+		app.console.Warnf("Warning: unable to access the SSH auth socket %s: %v\n", app.sshAuthSock, err)
+		return
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		app.console.Warnf("Warning: the SSH auth socket %s is not a socket; ssh-agent forwarding will not be available\n", app.sshAuthSock)
+	}
+}
+
+func (app *earthlyApp) warnIfEarth() error {
+	if len(os.Args) == 0 || binaryName(os.Args[0]) != "earth" {
+		return nil
+	}
+	err := app.deprecated("the earth binary has been renamed to earthly; the earth command is currently symlinked, but is deprecated and will one day be removed.")
+	if err != nil {
+		return err
+	}
+
+	// can't use os.Executable() here; because it will give us earthly if executed via the earth symlink
+	earthPath, err := invokedBinaryPath(os.Args[0], exec.LookPath)
+	if err != nil {
+		return nil
+	}
+	if fileutil.FileExists(siblingBinaryPath(earthPath, "earthly")) {
+		app.console.Warnf("Once you are ready to switch over to earthly, you can `rm %s`", earthPath)
+	}
+	return nil
+}
+
+// siblingBinaryPath returns the path of the binary with the given name, in the same
+// directory as binPath, keeping the .exe extension of binPath if it has one.
+func siblingBinaryPath(binPath string, name string) string {
+	ext := filepath.Ext(binPath)
+	if !strings.EqualFold(ext, ".exe") {
+		ext = ""
+	}
+	return filepath.Join(filepath.Dir(binPath), name+ext)
 }
 
 func (app *earthlyApp) processDeprecatedCommandOptions(context *cli.Context, cfg *config.Config) error {
-	app.warnIfEarth()
+	err := app.warnIfEarth()
+	if err != nil {
+		return err
+	}
 
 	if cfg.Global.CachePath != "" {
-		app.console.Warnf("Warning: the setting cache_path is now obsolete and will be ignored")
+		err = app.deprecated("the setting cache_path is now obsolete and will be ignored")
+		if err != nil {
+			return err
+		}
 	}
 
 	// command line overrides the config file
 	if app.gitUsernameOverride != "" || app.gitPasswordOverride != "" {
-		app.console.Warnf("Warning: the --git-username and --git-password command flags are deprecated and are now configured in the ~/.earthly/config.yml file under the git section; see https://docs.earthly.dev/earthly-config for reference.\n")
+		err = app.deprecated("the --git-username and --git-password command flags are deprecated and are now configured in the ~/.earthly/config.yml file under the git section; see https://docs.earthly.dev/earthly-config for reference.")
+		if err != nil {
+			return err
+		}
 		if _, ok := cfg.Git["github.com"]; !ok {
 			cfg.Git["github.com"] = config.GitConfig{}
 		}
@@ -886,7 +1851,10 @@ func (app *earthlyApp) processDeprecatedCommandOptions(context *cli.Context, cfg
 	}
 
 	if context.IsSet("git-url-instead-of") {
-		app.console.Warnf("Warning: the --git-url-instead-of command flag is deprecated and is now configured in the ~/.earthly/config.yml file under the git global url_instead_of setting; see https://docs.earthly.dev/earthly-config for reference.\n")
+		err = app.deprecated("the --git-url-instead-of command flag is deprecated and is now configured in the ~/.earthly/config.yml file under the git global url_instead_of setting; see https://docs.earthly.dev/earthly-config for reference.")
+		if err != nil {
+			return err
+		}
 	} else {
 		if gitGlobal, ok := cfg.Git["global"]; ok {
 			if gitGlobal.GitURLInsteadOf != "" {
@@ -896,7 +1864,10 @@ func (app *earthlyApp) processDeprecatedCommandOptions(context *cli.Context, cfg
 	}
 
 	if context.IsSet("buildkit-cache-size-mb") {
-		app.console.Warnf("Warning: the --buildkit-cache-size-mb command flag is deprecated and is now configured in the ~/.earthly/config.yml file under the buildkit_cache_size setting; see https://docs.earthly.dev/earthly-config for reference.\n")
+		err = app.deprecated("the --buildkit-cache-size-mb command flag is deprecated and is now configured in the ~/.earthly/config.yml file under the buildkit_cache_size setting; see https://docs.earthly.dev/earthly-config for reference.")
+		if err != nil {
+			return err
+		}
 	} else {
 		app.buildkitdSettings.CacheSizeMb = cfg.Global.BuildkitCacheSizeMb
 	}
@@ -955,7 +1926,7 @@ func (app *earthlyApp) autoCompleteImp() (err error) {
 		return err
 	}
 	for _, p := range potentials {
-		fmt.Printf("%s\n", p)
+		fmt.Fprintf(app.stdout, "%s\n", p)
 	}
 
 	return err
@@ -973,7 +1944,7 @@ func (app *earthlyApp) insertBashCompleteEntry() error {
 	dirPath := filepath.Dir(path)
 
 	if !fileutil.DirExists(dirPath) {
-		fmt.Fprintf(os.Stderr, "Warning: unable to enable bash-completion: %s does not exist\n", dirPath)
+		app.console.Warnf("Warning: unable to enable bash-completion: %s does not exist\n", dirPath)
 		return nil // bash-completion isn't available, silently fail.
 	}
 
@@ -1032,6 +2003,11 @@ function _earth {
 }
 `
 
+// fishCompleteEntry runs earthly as a bash completion command would, since the
+// completion of earthly is driven by the COMP_LINE and COMP_POINT env vars.
+const fishCompleteEntry = `complete -c earthly -f -a '(env COMP_LINE=(commandline -cp) COMP_POINT=(string length -- (commandline -cp)) /usr/local/bin/earthly)'
+`
+
 // If debugging this, it might be required to run `rm ~/.zcompdump*` to remove the cache
 func (app *earthlyApp) insertZSHCompleteEntry() error {
 	// should be the same on linux and macOS
@@ -1039,7 +2015,7 @@ func (app *earthlyApp) insertZSHCompleteEntry() error {
 	dirPath := filepath.Dir(path)
 
 	if !fileutil.DirExists(dirPath) {
-		fmt.Fprintf(os.Stderr, "Warning: unable to enable zsh-completion: %s does not exist\n", dirPath)
+		app.console.Warnf("Warning: unable to enable zsh-completion: %s does not exist\n", dirPath)
 		return nil // zsh-completion isn't available, silently fail.
 	}
 
@@ -1064,6 +2040,9 @@ func (app *earthlyApp) insertZSHCompleteEntry() error {
 
 func (app *earthlyApp) run(ctx context.Context, args []string) int {
 	err := app.cliApp.RunContext(ctx, args)
+	if app.logFileHandle != nil {
+		defer app.logFileHandle.Close()
+	}
 
 	rpcRegex := regexp.MustCompile(`(?U)rpc error: code = .+ desc = .+:\s`)
 	if err != nil {
@@ -1071,7 +2050,7 @@ func (app *earthlyApp) run(ctx context.Context, args []string) int {
 			app.console.Warnf("Error: --allow-privileged (-P) flag is required\n")
 		} else if strings.Contains(err.Error(), "failed to fetch remote") {
 			app.console.Warnf("Error: %v\n", err)
-			app.console.Printf(
+			app.console.Warnf(
 				"Check your git auth settings.\n" +
 					"Did you ssh-add today? Need to configure ~/.earthly/config.yml?\n" +
 					"For more information see https://docs.earthly.dev/guides/auth\n")
@@ -1109,18 +2088,17 @@ func isEarthlyBinary(path string) bool {
 	return true
 }
 
-func symlinkEarthlyToEarth() error {
-	binPath, err := os.Executable()
-	if err != nil {
-		return errors.Wrap(err, "failed to get current executable path")
+// symlinkEarthlyToEarth replaces the legacy earth binary next to the earthly binary at
+// binPath with a symlink to it. Nothing is done if --no-earth-symlink is set.
+func (app *earthlyApp) symlinkEarthlyToEarth(binPath string) error {
+	if app.noEarthSymlink {
+		return nil
 	}
-
-	baseName := path.Base(binPath)
-	if baseName != "earthly" {
+	if binaryName(binPath) != "earthly" {
 		return nil
 	}
 
-	earthPath := path.Join(path.Dir(binPath), "earth")
+	earthPath := siblingBinaryPath(binPath, "earth")
 
 	if !fileutil.FileExists(earthPath) && termutil.IsTTY() {
 		return nil // legacy earth binary doesn't exist, don't create it (unless we're under a non-tty system e.g. CI)
@@ -1132,7 +2110,7 @@ func symlinkEarthlyToEarth() error {
 
 	// otherwise legacy earth command has been detected, remove it and symlink
 	// to the new earthly command.
-	err = os.Remove(earthPath)
+	err := os.Remove(earthPath)
 	if err != nil {
 		return errors.Wrapf(err, "failed to remove old install at %s", earthPath)
 	}
@@ -1143,14 +2121,32 @@ func symlinkEarthlyToEarth() error {
 	return nil
 }
 
+func (app *earthlyApp) actionCompletion(c *cli.Context) error {
+	app.commandName = "completion"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments; expected the shell: bash, zsh or fish")
+	}
+	switch shell := c.Args().Get(0); shell {
+	case "bash":
+		fmt.Fprint(app.stdout, bashCompleteEntry)
+	case "zsh":
+		fmt.Fprint(app.stdout, zshCompleteEntry)
+	case "fish":
+		fmt.Fprint(app.stdout, fishCompleteEntry)
+	default:
+		return fmt.Errorf("unsupported shell %q; expected bash, zsh or fish", shell)
+	}
+	return nil
+}
+
 func (app *earthlyApp) actionBootstrap(c *cli.Context) error {
 	app.commandName = "bootstrap"
 	switch app.homebrewSource {
 	case "bash":
-		fmt.Printf(bashCompleteEntry)
+		fmt.Fprint(app.stdout, bashCompleteEntry)
 		return nil
 	case "zsh":
-		fmt.Printf(zshCompleteEntry)
+		fmt.Fprint(app.stdout, zshCompleteEntry)
 		return nil
 	case "":
 		break
@@ -1158,9 +2154,14 @@ func (app *earthlyApp) actionBootstrap(c *cli.Context) error {
 		return fmt.Errorf("unhandled source %q", app.homebrewSource)
 	}
 
-	err := symlinkEarthlyToEarth()
+	binPath, err := os.Executable()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: %s\n", err.Error())
+		app.console.Warnf("Warning: failed to get current executable path: %s\n", err.Error())
+	} else {
+		err = app.symlinkEarthlyToEarth(binPath)
+		if err != nil {
+			app.console.Warnf("Warning: %s\n", err.Error())
+		}
 	}
 
 	err = app.insertBashCompleteEntry()
@@ -1173,13 +2174,60 @@ func (app *earthlyApp) actionBootstrap(c *cli.Context) error {
 		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "Bootstrapping successful; you may have to restart your shell for autocomplete to get initialized (e.g. run \"exec $SHELL\")\n")
+	app.console.Printf("Bootstrapping successful; you may have to restart your shell for autocomplete to get initialized (e.g. run \"exec $SHELL\")\n")
 
 	return nil
 }
 
+// newSecretsClient returns a client of the API server, configured from the global flags.
+func (app *earthlyApp) newSecretsClient() (secretsclient.Client, error) {
+	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	if err != nil {
+		return nil, err
+	}
+	sc.SetTimeout(app.apiTimeout)
+	sc.SetRetries(app.apiRetries)
+	if app.apiCACert != "" {
+		err = sc.SetCACert(app.apiCACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "api ca cert")
+		}
+	}
+	if app.apiClientCert != "" {
+		err = sc.SetClientCert(app.apiClientCert, app.apiClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "api client cert")
+		}
+	}
+	return sc, nil
+}
+
+// confirm asks for the confirmation of an operation, via a y/N prompt which defaults to no.
+// The operation is confirmed right away if --assume-yes is set. Otherwise, an error is returned
+// when stdin is not a terminal, as nobody can answer.
+func (app *earthlyApp) confirm(question string) (bool, error) {
+	if app.assumeYes {
+		return true, nil
+	}
+	if !termutil.IsInputTTY() {
+		return false, errors.New("confirmation required; use --assume-yes (-y) to confirm in non-interactive contexts")
+	}
+	return app.confirmInteractive(question)
+}
+
+// confirmInteractive is like confirm, but always requires an interactive answer, even if
+// --assume-yes is set. It is meant for the agreements which must be given explicitly, such
+// as the acceptance of the terms of service.
+func (app *earthlyApp) confirmInteractive(question string) (bool, error) {
+	if !termutil.IsInputTTY() {
+		return false, errors.New("interactive confirmation required")
+	}
+	answer := strings.ToLower(strings.TrimSpace(promptInput(question + " [y/N]: ")))
+	return answer == "y" || answer == "yes", nil
+}
+
 func promptInput(question string) string {
-	fmt.Printf(question)
+	fmt.Fprint(os.Stderr, question)
 	rbuf := bufio.NewReader(os.Stdin)
 	line, err := rbuf.ReadString('\n')
 	if err != nil {
@@ -1194,7 +2242,7 @@ func (app *earthlyApp) actionOrgCreate(c *cli.Context) error {
 		return errors.New("invalid number of arguments provided")
 	}
 	org := c.Args().Get(0)
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1207,7 +2255,10 @@ func (app *earthlyApp) actionOrgCreate(c *cli.Context) error {
 
 func (app *earthlyApp) actionOrgList(c *cli.Context) error {
 	app.commandName = "orgList"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	if app.orgListJSON && app.listFormat != "" {
+		return errors.New("--json cannot be used with --format")
+	}
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1215,8 +2266,28 @@ func (app *earthlyApp) actionOrgList(c *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to list orgs")
 	}
+	if app.orgListJSON {
+		return app.printOrgListJSON(sc, orgs)
+	}
+	if app.listFormat != "" {
+		lf, err := newListFormatter(app.listFormat)
+		if err != nil {
+			return err
+		}
+		for _, org := range orgs {
+			role := "member"
+			if org.Admin {
+				role = "admin"
+			}
+			err := lf.print(app.stdout, orgRecord{Name: org.Name, Role: role})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
 	for _, org := range orgs {
 		fmt.Fprintf(w, "/%s/", org.Name)
 		if org.Admin {
@@ -1231,6 +2302,47 @@ func (app *earthlyApp) actionOrgList(c *cli.Context) error {
 	return nil
 }
 
+// orgListEntry is an org of the output of org list --json.
+type orgListEntry struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Members is the number of users with permissions within the org. The permissions of an
+	// org are only available to its admins.
+	Members *int `json:"members,omitempty"`
+}
+
+func (app *earthlyApp) printOrgListJSON(sc secretsclient.Client, orgs []*secretsclient.OrgDetail) error {
+	var adminOrgPaths []string
+	for _, org := range orgs {
+		if org.Admin {
+			adminOrgPaths = append(adminOrgPaths, fmt.Sprintf("/%s/", org.Name))
+		}
+	}
+	perms, err := listPermissionsByOrg(sc, adminOrgPaths)
+	if err != nil {
+		return err
+	}
+	entries := []orgListEntry{}
+	for _, org := range orgs {
+		entry := orgListEntry{Name: org.Name, Role: "member"}
+		if org.Admin {
+			entry.Role = "admin"
+			users := make(map[string]bool)
+			for _, perm := range perms[org.Name] {
+				users[perm.User] = true
+			}
+			members := len(users)
+			entry.Members = &members
+		}
+		entries = append(entries, entry)
+	}
+	err = json.NewEncoder(app.stdout).Encode(entries)
+	if err != nil {
+		return errors.Wrap(err, "encode org list")
+	}
+	return nil
+}
+
 func (app *earthlyApp) actionOrgListPermissions(c *cli.Context) error {
 	app.commandName = "orgListPermissions"
 	if c.NArg() != 1 {
@@ -1240,7 +2352,7 @@ func (app *earthlyApp) actionOrgListPermissions(c *cli.Context) error {
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
 	}
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1249,7 +2361,7 @@ func (app *earthlyApp) actionOrgListPermissions(c *cli.Context) error {
 		return errors.Wrap(err, "failed to list org permissions")
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
 	for _, org := range orgs {
 		fmt.Fprintf(w, "%s\t%s", org.Path, org.User)
 		if org.Write {
@@ -1273,7 +2385,7 @@ func (app *earthlyApp) actionOrgInvite(c *cli.Context) error {
 		return errors.New("invitation paths must end with a slash (/)")
 	}
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1295,7 +2407,7 @@ func (app *earthlyApp) actionOrgRevoke(c *cli.Context) error {
 		return errors.New("revoked paths must end with a slash (/)")
 	}
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1314,12 +2426,20 @@ func (app *earthlyApp) actionSecretsList(c *cli.Context) error {
 	if c.NArg() > 1 {
 		return errors.New("invalid number of arguments provided")
 	} else if c.NArg() == 1 {
-		path = c.Args().Get(0)
+		path = app.expandSecretPath(c.Args().Get(0))
+	}
+	if app.secretsListJSON {
+		if app.listFormat != "" {
+			return errors.New("--json cannot be used with --format")
+		}
+		if app.showSecretExpiry || app.secretsWithPermissions {
+			return errors.New("--json cannot be used with --show-expiry or --with-permissions")
+		}
 	}
 	if !strings.HasSuffix(path, "/") {
 		path += "/"
 	}
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1327,44 +2447,321 @@ func (app *earthlyApp) actionSecretsList(c *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to list secret")
 	}
+	if app.secretsListJSON {
+		records := []secretRecord{}
+		for _, path := range paths {
+			records = append(records, secretRecord{Path: path, IsDir: strings.HasSuffix(path, "/")})
+		}
+		err = json.NewEncoder(app.stdout).Encode(records)
+		if err != nil {
+			return errors.Wrap(err, "encode secret list")
+		}
+		return nil
+	}
+	if app.listFormat != "" {
+		if app.showSecretExpiry || app.secretsWithPermissions {
+			return errors.New("--format cannot be used with --show-expiry or --with-permissions")
+		}
+		lf, err := newListFormatter(app.listFormat)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			err := lf.print(app.stdout, secretRecord{Path: path, IsDir: strings.HasSuffix(path, "/")})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !app.showSecretExpiry && !app.secretsWithPermissions {
+		for _, path := range paths {
+			fmt.Fprintln(app.stdout, path)
+		}
+		return nil
+	}
+	var perms map[string][]*secretsclient.OrgPermissions
+	if app.secretsWithPermissions {
+		perms, err = listPermissionsByOrg(sc, paths)
+		if err != nil {
+			return err
+		}
+	}
+	w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
 	for _, path := range paths {
-		fmt.Println(path)
+		fmt.Fprintf(w, "%s", path)
+		if app.showSecretExpiry {
+			if strings.HasSuffix(path, "/") {
+				fmt.Fprintf(w, "\t")
+			} else {
+				expiry, err := sc.GetExpiry(path)
+				if err != nil {
+					return errors.Wrapf(err, "failed to get expiry of %s", path)
+				}
+				fmt.Fprintf(w, "\t%s", describeSecretExpiry(expiry))
+			}
+		}
+		if app.secretsWithPermissions {
+			fmt.Fprintf(w, "\t%s", describeSecretPermissions(path, perms))
+		}
+		fmt.Fprintf(w, "\n")
 	}
+	w.Flush()
 	return nil
 }
 
-func (app *earthlyApp) actionSecretsGet(c *cli.Context) error {
-	app.commandName = "secretsGet"
-	if c.NArg() != 1 {
-		return errors.New("invalid number of arguments provided")
+// personalSecretsOrg is the first path element of the personal secrets of a user, which
+// belong to no org.
+const personalSecretsOrg = "user"
+
+// secretOrg returns the org of the secret at the given path.
+func secretOrg(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return ""
 	}
-	path := c.Args().Get(0)
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
-	if err != nil {
-		return errors.Wrap(err, "failed to create secretsclient")
+	return parts[1]
+}
+
+// listPermissionsByOrg returns the permissions of the orgs of the given secret paths, keyed
+// by org. The permissions of each org are fetched once, regardless of the number of paths.
+func listPermissionsByOrg(sc secretsclient.Client, paths []string) (map[string][]*secretsclient.OrgPermissions, error) {
+	perms := make(map[string][]*secretsclient.OrgPermissions)
+	for _, path := range paths {
+		org := secretOrg(path)
+		if org == "" || org == personalSecretsOrg {
+			continue
+		}
+		if _, ok := perms[org]; ok {
+			continue
+		}
+		orgPerms, err := sc.ListOrgPermissions(fmt.Sprintf("/%s/", org))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list permissions of org %s", org)
+		}
+		perms[org] = orgPerms
 	}
-	data, err := sc.Get(path)
-	if err != nil {
-		return errors.Wrap(err, "failed to get secret")
+	return perms, nil
+}
+
+// describeSecretPermissions returns the org members which have access to the secret at the
+// given path, along with their access level.
+func describeSecretPermissions(path string, perms map[string][]*secretsclient.OrgPermissions) string {
+	org := secretOrg(path)
+	if org == personalSecretsOrg {
+		return "personal"
+	}
+	var users []string
+	for _, perm := range perms[org] {
+		if !strings.HasPrefix(path, perm.Path) {
+			continue
+		}
+		access := "r"
+		if perm.Write {
+			access = "rw"
+		}
+		users = append(users, fmt.Sprintf("%s (%s)", perm.User, access))
 	}
-	fmt.Printf("%s", data)
-	if !app.disableNewLine {
-		fmt.Printf("\n")
+	if len(users) == 0 {
+		return "-"
 	}
-	return nil
+	sort.Strings(users)
+	return strings.Join(users, ", ")
 }
 
-func (app *earthlyApp) actionSecretsRemove(c *cli.Context) error {
-	app.commandName = "secretsRemove"
-	if c.NArg() != 1 {
+func (app *earthlyApp) actionConfig(c *cli.Context) error {
+	app.commandName = "config"
+	if c.NArg() != 1 && c.NArg() != 2 {
 		return errors.New("invalid number of arguments provided")
 	}
-	path := c.Args().Get(0)
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
-	if err != nil {
-		return errors.Wrap(err, "failed to create secretsclient")
+	key := c.Args().Get(0)
+	yamlData, err := ioutil.ReadFile(app.configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read from %s", app.configPath)
 	}
-	err = sc.Remove(path)
+	if c.NArg() == 1 {
+		value, err := config.Get(yamlData, key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(app.stdout, value)
+		return nil
+	}
+	newYAMLData, err := config.Set(yamlData, key, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	cfg, err := config.ParseConfigFile(newYAMLData)
+	if err == nil {
+		err = checkConfigValue(cfg, key)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for %s", key)
+		}
+	}
+	err = app.writeConfigFile(newYAMLData)
+	if err != nil {
+		return err
+	}
+	app.console.Printf("Set %s in %s\n", key, app.configPath)
+	return nil
+}
+
+// checkConfigValue checks that the value of the given config key can be used, beyond being
+// of the right type.
+func checkConfigValue(cfg *config.Config, key string) error {
+	switch key {
+	case "global.run_path":
+		err := os.MkdirAll(cfg.Global.RunPath, 0755)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create run directory %s", cfg.Global.RunPath)
+		}
+		err = fileutil.CheckDirWritable(cfg.Global.RunPath)
+		if err != nil {
+			return errors.Wrapf(err, "run directory %s is not writable", cfg.Global.RunPath)
+		}
+	case "global.container_runtime":
+		if cfg.Global.ContainerRuntime != "" {
+			return buildkitd.ValidateContainerRuntime(cfg.Global.ContainerRuntime)
+		}
+	case "global.registry_mirrors":
+		for _, m := range cfg.Global.RegistryMirrors {
+			_, _, _, err := buildkitd.ParseRegistryMirror(m)
+			if err != nil {
+				return err
+			}
+		}
+	case "global.api_timeout_s":
+		if cfg.Global.APITimeoutS < 0 {
+			return errors.Errorf("invalid api timeout %ds", cfg.Global.APITimeoutS)
+		}
+	case "global.api_retries":
+		if cfg.Global.APIRetries < 0 {
+			return errors.Errorf("invalid api retries %d", cfg.Global.APIRetries)
+		}
+	case "global.lint_disable":
+		known := earthfile2llb.LintRuleNames()
+		for _, name := range cfg.Global.LintDisable {
+			found := false
+			for _, k := range known {
+				found = found || k == name
+			}
+			if !found {
+				return errors.Errorf("unknown lint rule %s; the known rules are %s", name, strings.Join(known, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionSecretsGet(c *cli.Context) error {
+	app.commandName = "secretsGet"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	path := app.expandSecretPath(c.Args().Get(0))
+	sc, err := app.newSecretsClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create secretsclient")
+	}
+	data, expiry, err := sc.GetWithExpiry(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to get secret")
+	}
+	fmt.Fprintf(app.stdout, "%s", data)
+	if !app.disableNewLine {
+		fmt.Fprintf(app.stdout, "\n")
+	}
+	if app.showSecretExpiry {
+		app.console.Printf("%s %s\n", path, describeSecretExpiry(expiry))
+	}
+	return nil
+}
+
+// expandSecretPath prepends the default secrets path to a relative secret path, if a default
+// path is set, noting the expansion.
+func (app *earthlyApp) expandSecretPath(path string) string {
+	expanded, ok := app.cfg.Secrets.ExpandPath(path)
+	if ok {
+		app.console.Printf("Using %s for the relative path %s (default secrets path %s)\n", expanded, path, app.cfg.Secrets.DefaultPath)
+	}
+	return expanded
+}
+
+// secretExists returns whether a secret exists at the given path, by listing its parent.
+func secretExists(sc secretsclient.Client, path string) (bool, error) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return false, errors.New("invalid path")
+	}
+	paths, err := sc.List(path[:i+1])
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list secrets")
+	}
+	for _, p := range paths {
+		if p == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validateSecret checks the value of the secret against the --validate pattern and against
+// the validation pattern configured for its path, if any. The value is never included in
+// the returned error.
+func (app *earthlyApp) validateSecret(path, value string) error {
+	if app.secretValidate != "" {
+		err := matchSecretPattern(app.secretValidate, value)
+		if err != nil {
+			return errors.Wrap(err, "--validate")
+		}
+	}
+	if app.cfg == nil {
+		return nil
+	}
+	prefix, pattern, ok := app.cfg.Secrets.ValidationPattern(path)
+	if !ok {
+		return nil
+	}
+	err := matchSecretPattern(pattern, value)
+	if err != nil {
+		return errors.Wrapf(err, "secrets validation configured for %s", prefix)
+	}
+	return nil
+}
+
+func matchSecretPattern(pattern, value string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	if !re.MatchString(value) {
+		return fmt.Errorf("secret value does not match pattern %q", pattern)
+	}
+	return nil
+}
+
+// describeSecretExpiry returns the remaining lifetime of a secret with the given expiry.
+func describeSecretExpiry(expiry *time.Time) string {
+	if expiry == nil {
+		return "never expires"
+	}
+	if expiry.Before(time.Now()) {
+		return fmt.Sprintf("expired %s", humanize.Time(*expiry))
+	}
+	return fmt.Sprintf("expires %s", humanize.Time(*expiry))
+}
+
+func (app *earthlyApp) actionSecretsRemove(c *cli.Context) error {
+	app.commandName = "secretsRemove"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	path := app.expandSecretPath(c.Args().Get(0))
+	sc, err := app.newSecretsClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create secretsclient")
+	}
+	err = sc.Remove(path)
 	if err != nil {
 		return errors.Wrap(err, "failed to remove secret")
 	}
@@ -1405,20 +2802,94 @@ func (app *earthlyApp) actionSecretsSet(c *cli.Context) error {
 		}
 		value = string(data)
 	}
+	path = app.expandSecretPath(path)
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	err := app.validateSecret(path, value)
+	if err != nil {
+		return err
+	}
+
+	var expiry *time.Time
+	if app.secretExpiry != "" && app.secretExpiry != "never" {
+		t, err := parseExpiry(app.secretExpiry)
+		if err != nil {
+			return err
+		}
+		if t.Before(time.Now()) {
+			return fmt.Errorf("expiry %q is in the past", app.secretExpiry)
+		}
+		expiry = &t
+	}
+
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
-	err = sc.Set(path, []byte(value))
+	if app.secretIfNotExists {
+		exists, err := secretExists(sc, path)
+		if err != nil {
+			return err
+		}
+		created := false
+		expirySet := true
+		if !exists {
+			created, expirySet, err = sc.SetIfNotExists(path, []byte(value), expiry)
+			if err != nil {
+				return errors.Wrap(err, "failed to set secret")
+			}
+		}
+		if !created {
+			app.console.Printf("Secret %s already exists; leaving it unchanged\n", path)
+			return nil
+		}
+		if !expirySet {
+			app.console.Warnf("Warning: the secrets store does not support secret expiry; %s was stored without an expiry\n", path)
+		}
+		return nil
+	}
+	if expiry == nil {
+		err = sc.Set(path, []byte(value))
+		if err != nil {
+			return errors.Wrap(err, "failed to set secret")
+		}
+		return nil
+	}
+	supported, err := sc.SetWithExpiry(path, []byte(value), *expiry)
 	if err != nil {
 		return errors.Wrap(err, "failed to set secret")
 	}
+	if !supported {
+		app.console.Warnf("Warning: the secrets store does not support secret expiry; %s was stored without an expiry\n", path)
+	}
+	return nil
+}
+
+// readPasswordStdin sets the password from stdin, if requested via --password-stdin. The
+// trailing newline is not part of the password.
+func (app *earthlyApp) readPasswordStdin(c *cli.Context) error {
+	if !app.passwordStdin {
+		return nil
+	}
+	if c.IsSet("password") {
+		return errors.New("--password and --password-stdin are mutually exclusive")
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "read password from stdin")
+	}
+	app.password = strings.TrimRight(string(data), "\r\n")
+	if app.password == "" {
+		return errors.New("no password given on stdin")
+	}
 	return nil
 }
 
 func (app *earthlyApp) actionRegister(c *cli.Context) error {
 	app.commandName = "secretsRegister"
+	err := app.readPasswordStdin(c)
+	if err != nil {
+		return err
+	}
 	if app.email == "" {
 		return errors.New("no email given")
 	}
@@ -1427,7 +2898,7 @@ func (app *earthlyApp) actionRegister(c *cli.Context) error {
 		return errors.New("email is invalid")
 	}
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1437,7 +2908,7 @@ func (app *earthlyApp) actionRegister(c *cli.Context) error {
 		if err != nil {
 			return errors.Wrap(err, "failed to register email")
 		}
-		fmt.Printf("An email has been sent to %q containing a registration token\n", app.email)
+		app.console.Printf("An email has been sent to %q containing a registration token\n", app.email)
 		return nil
 	}
 
@@ -1470,25 +2941,21 @@ func (app *earthlyApp) actionRegister(c *cli.Context) error {
 		pword = string(enteredPassword)
 	}
 
-	var interactiveAccept bool
-	if !app.termsConditionsPrivacy {
-		rawAccept := promptInput("I acknowledge Earthly Technologies’ Privacy Policy (https://earthly.dev/privacy-policy) and agree to Earthly Technologies Terms of Service (https://earthly.dev/tos) [y/N]: ")
-		if rawAccept == "" {
-			rawAccept = "n"
+	termsConditionsPrivacy := app.termsConditionsPrivacy
+	if !termsConditionsPrivacy {
+		termsConditionsPrivacy, err = app.confirmInteractive("I acknowledge Earthly Technologies’ Privacy Policy (https://earthly.dev/privacy-policy) and agree to Earthly Technologies Terms of Service (https://earthly.dev/tos)")
+		if err != nil {
+			return errors.Wrap(err, "the terms of service must be accepted (see --accept-terms-of-service-privacy)")
 		}
-		accept := strings.ToLower(rawAccept)[0]
-
-		interactiveAccept = accept == 'y'
 	}
-	termsConditionsPrivacy := app.termsConditionsPrivacy || interactiveAccept
 
 	var publicKey string
 	if app.registrationPublicKey == "" {
 		if len(publicKeys) > 0 {
-			fmt.Printf("Which of the following keys do you want to register?\n")
-			fmt.Printf("0) none\n")
+			fmt.Fprintf(os.Stderr, "Which of the following keys do you want to register?\n")
+			fmt.Fprintf(os.Stderr, "0) none\n")
 			for i, key := range publicKeys {
-				fmt.Printf("%d) %s\n", i+1, key.String())
+				fmt.Fprintf(os.Stderr, "%d) %s\n", i+1, key.String())
 			}
 			keyNum := promptInput("enter key number (1=default): ")
 			if keyNum == "" {
@@ -1529,13 +2996,13 @@ func (app *earthlyApp) actionRegister(c *cli.Context) error {
 		return errors.Wrap(err, "failed to create account")
 	}
 
-	fmt.Println("Account registration complete")
+	app.console.Printf("Account registration complete\n")
 	return nil
 }
 
 func (app *earthlyApp) actionAccountListKeys(c *cli.Context) error {
 	app.commandName = "accountListKeys"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1544,14 +3011,14 @@ func (app *earthlyApp) actionAccountListKeys(c *cli.Context) error {
 		return errors.Wrap(err, "failed to list account keys")
 	}
 	for _, key := range keys {
-		fmt.Printf("%s\n", key)
+		fmt.Fprintf(app.stdout, "%s\n", key)
 	}
 	return nil
 }
 
 func (app *earthlyApp) actionAccountAddKey(c *cli.Context) error {
 	app.commandName = "accountAddKey"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1577,9 +3044,9 @@ func (app *earthlyApp) actionAccountAddKey(c *cli.Context) error {
 	// as there's no way to pass app.ctx to stdin read calls.
 	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
 
-	fmt.Printf("Which of the following keys do you want to register?\n")
+	fmt.Fprintf(os.Stderr, "Which of the following keys do you want to register?\n")
 	for i, key := range publicKeys {
-		fmt.Printf("%d) %s\n", i+1, key.String())
+		fmt.Fprintf(os.Stderr, "%d) %s\n", i+1, key.String())
 	}
 	keyNum := promptInput("enter key number (1=default): ")
 	if keyNum == "" {
@@ -1610,7 +3077,7 @@ func (app *earthlyApp) actionAccountAddKey(c *cli.Context) error {
 			app.console.Warnf("failed to authenticate using newly added public key: %s", err.Error())
 			return nil
 		}
-		fmt.Printf("Switching from password-based login to ssh-based login\n")
+		app.console.Printf("Switching from password-based login to ssh-based login\n")
 	}
 
 	return nil
@@ -1618,7 +3085,7 @@ func (app *earthlyApp) actionAccountAddKey(c *cli.Context) error {
 
 func (app *earthlyApp) actionAccountRemoveKey(c *cli.Context) error {
 	app.commandName = "accountRemoveKey"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1632,7 +3099,10 @@ func (app *earthlyApp) actionAccountRemoveKey(c *cli.Context) error {
 }
 func (app *earthlyApp) actionAccountListTokens(c *cli.Context) error {
 	app.commandName = "accountListTokens"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	if app.tokensListJSON && app.listFormat != "" {
+		return errors.New("--json cannot be used with --format")
+	}
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1640,13 +3110,46 @@ func (app *earthlyApp) actionAccountListTokens(c *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to list account tokens")
 	}
+	now := time.Now()
+	if app.tokensListJSON {
+		records := []tokenRecord{}
+		for _, token := range tokens {
+			records = append(records, tokenRecord{
+				Name:    token.Name,
+				Write:   token.Write,
+				Expiry:  token.Expiry,
+				Expired: now.After(token.Expiry),
+			})
+		}
+		err = json.NewEncoder(app.stdout).Encode(records)
+		if err != nil {
+			return errors.Wrap(err, "encode token list")
+		}
+		return nil
+	}
+	if app.listFormat != "" {
+		lf, err := newListFormatter(app.listFormat)
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			err := lf.print(app.stdout, tokenRecord{
+				Name:    token.Name,
+				Write:   token.Write,
+				Expiry:  token.Expiry,
+				Expired: now.After(token.Expiry),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(tokens) == 0 {
 		return nil // avoid printing header columns when there are no tokens
 	}
 
-	now := time.Now()
-
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
 	fmt.Fprintf(w, "Token Name\tRead/Write\tExpiry\n")
 	for _, token := range tokens {
 		expired := now.After(token.Expiry)
@@ -1665,6 +3168,22 @@ func (app *earthlyApp) actionAccountListTokens(c *cli.Context) error {
 	w.Flush()
 	return nil
 }
+
+// parseExpiry parses an expiry date given either as YYYY-MM-DD or in RFC 3339 format.
+func parseExpiry(s string) (time.Time, error) {
+	layouts := []string{
+		"2006-01-02",
+		time.RFC3339,
+	}
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse expiry %q", s)
+}
+
 func (app *earthlyApp) actionAccountCreateToken(c *cli.Context) error {
 	app.commandName = "accountCreateToken"
 	if c.NArg() != 1 {
@@ -1675,26 +3194,24 @@ func (app *earthlyApp) actionAccountCreateToken(c *cli.Context) error {
 	if app.expiry == "" {
 		expiry = time.Now().Add(time.Hour * 24 * 365)
 	} else if app.expiry == "never" {
+		ok, err := app.confirm("The token will never expire, and remains valid until it is removed. Continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			app.console.Printf("Aborted\n")
+			return nil
+		}
 		expiry = time.Now().Add(time.Hour * 24 * 365 * 100) // TODO save this some other way
 	} else {
-		layouts := []string{
-			"2006-01-02",
-			time.RFC3339,
-		}
-
 		var err error
-		for _, layout := range layouts {
-			expiry, err = time.Parse(layout, app.expiry)
-			if err == nil {
-				break
-			}
-		}
+		expiry, err = parseExpiry(app.expiry)
 		if err != nil {
-			return fmt.Errorf("failed to parse expiry %q", app.expiry)
+			return err
 		}
 	}
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1703,17 +3220,29 @@ func (app *earthlyApp) actionAccountCreateToken(c *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to create token")
 	}
-	expiryStr := humanize.Time(expiry)
-	fmt.Printf("created token %q which will expire in %s; save this token somewhere, it can't be viewed again (only reset)\n", token, expiryStr)
+	expiryStr := tokenExpiryString(expiry, app.expiry == "never", app.isoExpiry)
+	fmt.Fprintf(app.stdout, "created token %q which will %s; save this token somewhere, it can't be viewed again (only reset)\n", token, expiryStr)
 	return nil
 }
+
+// tokenExpiryString describes when a new token expires, for create-token. If iso is set,
+// the exact expiry is included as an RFC 3339 timestamp, for scripts to parse.
+func tokenExpiryString(expiry time.Time, never bool, iso bool) string {
+	if never {
+		return "never expire"
+	}
+	if iso {
+		return fmt.Sprintf("expire at %s (%s)", expiry.UTC().Format(time.RFC3339), humanize.Time(expiry))
+	}
+	return fmt.Sprintf("expire in %s", humanize.Time(expiry))
+}
 func (app *earthlyApp) actionAccountRemoveToken(c *cli.Context) error {
 	app.commandName = "accountRemoveToken"
 	if c.NArg() != 1 {
 		return errors.New("invalid number of arguments provided")
 	}
 	name := c.Args().First()
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1726,6 +3255,10 @@ func (app *earthlyApp) actionAccountRemoveToken(c *cli.Context) error {
 
 func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 	app.commandName = "accountLogin"
+	err := app.readPasswordStdin(c)
+	if err != nil {
+		return err
+	}
 	email := app.email
 	token := app.token
 	pass := app.password
@@ -1749,7 +3282,7 @@ func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 	if token != "" && (email != "" || pass != "") {
 		return errors.New("--token can not be used in conjuction with --email or --password")
 	}
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -1766,10 +3299,15 @@ func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 		if !writeAccess {
 			authType = "read-only-" + authType
 		}
-		fmt.Printf("Logged in as %q using %s auth\n", loggedInEmail, authType)
+		app.console.Printf("Logged in as %q using %s auth\n", loggedInEmail, authType)
 		return nil
 	}
 
+	// Logging in undoes account logout --all.
+	err = sc.EnableSSHKeyGuessing()
+	if err != nil {
+		return errors.Wrap(err, "failed to enable ssh key guessing")
+	}
 	if token != "" || pass != "" {
 		err := sc.DeleteCachedCredentials()
 		if err != nil {
@@ -1786,7 +3324,7 @@ func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 					if err != nil {
 						return err
 					}
-					fmt.Printf("Logged in as %q using ssh auth\n", email)
+					app.console.Printf("Logged in as %q using ssh auth\n", email)
 					return nil
 				}
 			}
@@ -1804,7 +3342,7 @@ func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 		if !writeAccess {
 			authType = "read-only-" + authType
 		}
-		fmt.Printf("Logged in as %q using %s auth\n", loggedInEmail, authType)
+		app.console.Printf("Logged in as %q using %s auth\n", loggedInEmail, authType)
 		return nil
 	default:
 		return err
@@ -1839,28 +3377,57 @@ func (app *earthlyApp) actionAccountLogin(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Logged in as %q using token auth\n", email) // TODO display if using read-only token
+		app.console.Printf("Logged in as %q using token auth\n", email) // TODO display if using read-only token
 	} else {
 		err = sc.SetLoginCredentials(email, string(pass))
 		if err != nil {
 			return err
 		}
-		fmt.Printf("Logged in as %q using password auth\n", email)
-		fmt.Printf("Warning unencrypted password has been stored under ~/.earthly/auth.token; consider using ssh-based auth to prevent this.\n")
+		app.console.Printf("Logged in as %q using password auth\n", email)
+		app.console.Warnf("Warning unencrypted password has been stored under ~/.earthly/auth.token; consider using ssh-based auth to prevent this.\n")
 	}
 	return nil
 }
 
 func (app *earthlyApp) actionAccountLogout(c *cli.Context) error {
 	app.commandName = "accountLogout"
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return err
 	}
+	if !app.logoutAll {
+		err = sc.DeleteCachedCredentials()
+		if err != nil {
+			return errors.Wrap(err, "failed to logout")
+		}
+		return nil
+	}
+
+	tokenPath, err := sc.CachedCredentialsPath()
+	if err != nil {
+		return errors.Wrap(err, "failed to logout")
+	}
+	cached := fileutil.FileExists(tokenPath)
 	err = sc.DeleteCachedCredentials()
 	if err != nil {
 		return errors.Wrap(err, "failed to logout")
 	}
+	if cached {
+		app.console.Printf("Removed the cached credentials %s\n", tokenPath)
+	} else {
+		app.console.Printf("No cached credentials found at %s\n", tokenPath)
+	}
+	err = sc.SaveSSHKeyGuessingDisabled()
+	if err != nil {
+		return errors.Wrap(err, "failed to logout")
+	}
+	if app.hasSSHKeys() {
+		app.console.Printf("The keys of the ssh-agent (%s) will not be tried until the next earthly account login\n", app.sshAuthSock)
+	}
+	// An auth token given explicitly is not cached by earthly, and cannot be removed by it.
+	if app.authToken != "" {
+		app.console.Warnf("An auth token is still given via --auth-token or the EARTHLY_TOKEN environment variable; unset it to logout\n")
+	}
 	return nil
 }
 
@@ -1875,6 +3442,13 @@ func (app *earthlyApp) actionDebug(c *cli.Context) error {
 	}
 	path = filepath.Join(path, "Earthfile")
 
+	if app.debugTokens {
+		err := earthfile2llb.DebugTokens(path, app.stdout)
+		if err != nil {
+			return errors.Wrap(err, "debug tokens")
+		}
+		return nil
+	}
 	err := earthfile2llb.ParseDebug(path)
 	if err != nil {
 		return errors.Wrap(err, "parse debug")
@@ -1882,20 +3456,146 @@ func (app *earthlyApp) actionDebug(c *cli.Context) error {
 	return nil
 }
 
+func (app *earthlyApp) actionInit(c *cli.Context) error {
+	app.commandName = "init"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	template := earthfileinit.DefaultTemplate
+	if c.NArg() == 1 {
+		template = c.Args().First()
+	}
+	written, err := earthfileinit.Init(".", template, app.initIgnore, app.initForce)
+	if err != nil {
+		return err
+	}
+	for _, path := range written {
+		app.console.Printf("Wrote %s\n", path)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionFmt(c *cli.Context) error {
+	app.commandName = "fmt"
+	if c.NArg() > 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	path := "."
+	if c.NArg() == 1 {
+		path = c.Args().First()
+	}
+	path = filepath.Join(path, "Earthfile")
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", path)
+	}
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", path)
+	}
+	formatted, err := earthfile2llb.Format(path)
+	if err != nil {
+		return errors.Wrap(err, "format")
+	}
+	if bytes.Equal(original, formatted) {
+		return nil
+	}
+	if app.fmtCheck {
+		return fmt.Errorf("%s is not formatted", path)
+	}
+	err = ioutil.WriteFile(path, formatted, stat.Mode())
+	if err != nil {
+		return errors.Wrapf(err, "write %s", path)
+	}
+	return nil
+}
+
+func (app *earthlyApp) actionExplain(c *cli.Context) error {
+	app.commandName = "explain"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	target, err := domain.ParseTarget(c.Args().First())
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", c.Args().First())
+	}
+	graph, err := depgraph.Build(target)
+	if err != nil {
+		return errors.Wrap(err, "build dependency graph")
+	}
+	if app.explainOutput == "" {
+		return app.writeExplain(app.stdout, graph)
+	}
+	f, err := os.Create(app.explainOutput)
+	if err != nil {
+		return errors.Wrapf(err, "create output file %s", app.explainOutput)
+	}
+	err = app.writeExplain(f, graph)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return errors.Wrapf(closeErr, "close output file %s", app.explainOutput)
+	}
+	return nil
+}
+
+// writeExplain writes the dependency graph to out, as a tree or in the DOT format.
+func (app *earthlyApp) writeExplain(out io.Writer, graph *depgraph.Graph) error {
+	if app.explainDot {
+		err := graph.WriteDot(out)
+		if err != nil {
+			return errors.Wrap(err, "write dot")
+		}
+		// Cycles are highlighted in the graph itself.
+		return nil
+	}
+	graph.PrintTree(out)
+	if len(graph.Cycles) > 0 {
+		for _, cycle := range graph.Cycles {
+			app.console.Warnf("Dependency cycle: %s\n", depgraph.CycleString(cycle))
+		}
+		return fmt.Errorf("found %d dependency cycle(s)", len(graph.Cycles))
+	}
+	return nil
+}
+
 func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	app.commandName = "prune"
 	if c.NArg() != 0 {
 		return errors.New("invalid arguments")
 	}
+	if app.pruneReset && app.pruneJSON {
+		return errors.New("--json cannot be used with --reset")
+	}
+	if app.pruneKeepBuilds < 0 {
+		return errors.New("--keep-builds must not be negative")
+	}
+	if app.pruneReset && app.pruneKeepBuilds > 0 {
+		return errors.New("--keep-builds cannot be used with --reset")
+	}
+	if app.pruneBuildGap <= 0 {
+		return errors.New("--build-gap must be positive")
+	}
 	if app.pruneReset {
 		// Prune by resetting container.
 		if app.buildkitHost != "" {
 			return errors.New("Cannot use prune --reset on non-default buildkit-host setting")
 		}
+		ok, err := app.confirm("This restarts the buildkit daemon and deletes its entire cache. Continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			app.console.Printf("Aborted\n")
+			return nil
+		}
 		// Use twice the restart timeout for reset operations
 		// (needs extra time to also remove the files).
 		opTimeout := 2 * time.Duration(app.cfg.Global.BuildkitRestartTimeoutS) * time.Second
-		err := buildkitd.ResetCache(
+		err = buildkitd.ResetCache(
 			c.Context, app.console, app.buildkitdImage, app.buildkitdSettings,
 			opTimeout)
 		if err != nil {
@@ -1914,23 +3614,46 @@ func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	if app.pruneAll {
 		opts = append(opts, client.PruneAll)
 	}
+	if app.pruneKeepBuilds > 0 {
+		records, err := bkClient.DiskUsage(c.Context)
+		if err != nil {
+			return errors.Wrap(err, "buildkit disk usage")
+		}
+		ids := staleBuildRecords(records, app.pruneKeepBuilds, app.pruneBuildGap)
+		if len(ids) == 0 {
+			app.console.Printf("Nothing to prune; the cache holds no more than %d builds\n", app.pruneKeepBuilds)
+			if app.pruneJSON {
+				return json.NewEncoder(app.stdout).Encode(pruneSummary{})
+			}
+			return nil
+		}
+		// The prune filters match when any of them does.
+		filters := make([]string, 0, len(ids))
+		for _, id := range ids {
+			filters = append(filters, fmt.Sprintf("id==%s", id))
+		}
+		opts = append(opts, client.WithFilter(filters))
+	}
 	ch := make(chan client.UsageInfo, 1)
 	eg, ctx := errgroup.WithContext(c.Context)
 	eg.Go(func() error {
-		err = bkClient.Prune(ctx, ch, opts...)
+		err := bkClient.Prune(ctx, ch, opts...)
 		if err != nil {
 			return errors.Wrap(err, "buildkit prune")
 		}
 		close(ch)
 		return nil
 	})
+	var summary pruneSummary
 	eg.Go(func() error {
 		for {
 			select {
-			case _, ok := <-ch:
+			case ui, ok := <-ch:
 				if !ok {
 					return nil
 				}
+				summary.RecordsRemoved++
+				summary.BytesFreed += ui.Size
 				// TODO: Print some progress info.
 			case <-ctx.Done():
 				return nil
@@ -1941,16 +3664,198 @@ func (app *earthlyApp) actionPrune(c *cli.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "err group")
 	}
+	if app.pruneJSON {
+		err = json.NewEncoder(app.stdout).Encode(summary)
+		if err != nil {
+			return errors.Wrap(err, "encode prune summary")
+		}
+	}
+	return nil
+}
+
+// printBuildPlan prints the commands that a build of the given target would execute, as
+// worked out from the Earthfiles, without connecting to buildkit.
+func (app *earthlyApp) printBuildPlan(target domain.Target) error {
+	steps, err := earthfile2llb.GetBuildPlan(target)
+	if err != nil {
+		return errors.Wrapf(err, "get build plan of %s", target.String())
+	}
+	w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+	for _, step := range steps {
+		if step.Command == "" {
+			fmt.Fprintf(w, "%s\t\t(remote target, not expanded)\n", step.Target.String())
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", step.Target.String(), step.Line, step.Command)
+	}
+	return w.Flush()
+}
+
+// defaultPruneBuildGap is the default minimum time between the last uses of two cache
+// records for them to be considered part of different builds, for prune --keep-builds.
+const defaultPruneBuildGap = 5 * time.Minute
+
+// staleBuildRecords returns the IDs of the cache records that were last used before the
+// keepBuilds most recent builds. Buildkit does not keep track of the build that used a
+// record, so builds are told apart by gaps of at least gap between the last-used times of
+// the records. This is a heuristic: a build with a pause longer than gap between two of its
+// steps counts as several builds, and builds run less than gap apart count as one. Records
+// that are in use are never returned.
+func staleBuildRecords(records []*client.UsageInfo, keepBuilds int, gap time.Duration) []string {
+	lastUsed := func(r *client.UsageInfo) time.Time {
+		if r.LastUsedAt != nil {
+			return *r.LastUsedAt
+		}
+		return r.CreatedAt
+	}
+	sorted := make([]*client.UsageInfo, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return lastUsed(sorted[i]).After(lastUsed(sorted[j]))
+	})
+	var ids []string
+	builds := 0
+	var prev time.Time
+	for i, r := range sorted {
+		t := lastUsed(r)
+		if i == 0 || prev.Sub(t) >= gap {
+			builds++
+		}
+		prev = t
+		if builds > keepBuilds && !r.InUse {
+			ids = append(ids, r.ID)
+		}
+	}
+	return ids
+}
+
+// pruneSummary is the output of prune --json.
+type pruneSummary struct {
+	RecordsRemoved int   `json:"recordsRemoved"`
+	BytesFreed     int64 `json:"bytesFreed"`
+}
+
+// defaultTargetName is the name of the target built when no target reference is provided.
+const defaultTargetName = "default"
+
+// findDefaultTarget returns the reference of the default target of the Earthfile in the
+// current directory, if there is one. An error is returned if the Earthfile cannot be parsed.
+func findDefaultTarget() (string, bool, error) {
+	if !fileutil.FileExists("Earthfile") {
+		return "", false, nil
+	}
+	targets, err := earthfile2llb.GetTargets("Earthfile")
+	if err != nil {
+		return "", false, errors.Wrap(err, "get targets of Earthfile")
+	}
+	for _, target := range targets {
+		if target == defaultTargetName {
+			return "+" + defaultTargetName, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// printTargetHelp prints the documentation of a target and of the build args it accepts,
+// as parsed from its Earthfile.
+func (app *earthlyApp) printTargetHelp(targetName string) error {
+	target, err := domain.ParseTarget(targetName)
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", targetName)
+	}
+	if target.IsRemote() {
+		return fmt.Errorf("help is only available for local targets: %s", targetName)
+	}
+	earthfilePath := filepath.Join(filepath.FromSlash(target.LocalPath), "Earthfile")
+	infos, err := earthfile2llb.GetTargetInfo(earthfilePath)
+	if err != nil {
+		return errors.Wrapf(err, "get target info of %s", earthfilePath)
+	}
+	info, found := infos[target.Target]
+	if !found {
+		return fmt.Errorf("target %s not defined in %s", target.Target, earthfilePath)
+	}
+
+	fmt.Fprintf(app.stdout, "Usage: earthly [options] [--build-arg <name>=<value>...] %s\n", targetName)
+	if info.Doc != "" {
+		fmt.Fprintf(app.stdout, "\n%s\n", info.Doc)
+	}
+	if info.IsBase() {
+		fmt.Fprintf(app.stdout, "\nThis target produces no outputs: building it only runs its commands (e.g. tests), or prepares a base for other targets.\n")
+	}
+	printArgs := func(title string, args []earthfile2llb.TargetArg) {
+		if len(args) == 0 {
+			return
+		}
+		fmt.Fprintf(app.stdout, "\n%s:\n", title)
+		w := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+		for _, arg := range args {
+			value := "(no default)"
+			if arg.HasDefault {
+				value = fmt.Sprintf("(default %q)", arg.DefaultValue)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", arg.Name, value, strings.ReplaceAll(arg.Doc, "\n", " "))
+		}
+		w.Flush()
+	}
+	printArgs("Args", info.Args)
+	if target.Target != "base" {
+		printArgs("Global args", infos["base"].Args)
+	}
 	return nil
 }
 
 func (app *earthlyApp) actionDocker2Earthly(c *cli.Context) error {
-	return docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage)
+	return docker2earthly.Docker2Earthly(app.dockerfilePath, app.earthfilePath, app.earthfileFinalImage, app.dockerfileTarget, app.docker2EarthlyStrict)
+}
+
+func (app *earthlyApp) actionCompose2Earthly(c *cli.Context) error {
+	return docker2earthly.Compose2Earthly(app.composePath, app.earthfilePath)
+}
+
+func (app *earthlyApp) actionExportDockerfile(c *cli.Context) error {
+	app.commandName = "exportDockerfile"
+	if c.NArg() != 1 {
+		return errors.New("invalid number of arguments provided")
+	}
+	targetName := c.Args().First()
+	target, err := domain.ParseTarget(targetName)
+	if err != nil {
+		return errors.Wrapf(err, "parse target name %s", targetName)
+	}
+	if target.IsRemote() {
+		return fmt.Errorf("export-dockerfile is only available for local targets: %s", targetName)
+	}
+	earthfilePath := filepath.Join(filepath.FromSlash(target.LocalPath), "Earthfile")
+	dockerfile, unsupported, err := earthfile2llb.ExportDockerfile(earthfilePath, target.Target)
+	if err != nil {
+		return errors.Wrapf(err, "export %s", targetName)
+	}
+	if app.exportDockerfilePath == "-" {
+		_, err = app.stdout.Write(dockerfile)
+	} else {
+		if fileutil.FileExists(app.exportDockerfilePath) {
+			return fmt.Errorf("%s already exists; please delete it if you wish to continue", app.exportDockerfilePath)
+		}
+		err = ioutil.WriteFile(app.exportDockerfilePath, dockerfile, 0644)
+	}
+	if err != nil {
+		return errors.Wrap(err, "write dockerfile")
+	}
+	if unsupported > 0 {
+		app.console.Warnf("%d command(s) of %s have no Dockerfile equivalent and were commented out\n", unsupported, targetName)
+	}
+	app.console.Printf("Build the Dockerfile with %s as the build context\n", target.LocalPath)
+	return nil
 }
 
 func (app *earthlyApp) actionBuild(c *cli.Context) error {
 	app.commandName = "build"
 
+	if app.printConfigPath || app.printRunPath {
+		return app.printPaths()
+	}
+
 	if app.ci {
 		app.useInlineCache = true
 		app.noOutput = true
@@ -1961,6 +3866,30 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 	if app.imageMode && app.artifactMode {
 		return errors.New("both image and artifact modes cannot be active at the same time")
 	}
+	if app.recordPath != "" && !app.interactiveDebugging {
+		return errors.New("--record requires --interactive")
+	}
+	if app.offline && app.pull {
+		return errors.New("--pull cannot be used with --offline")
+	}
+	if app.maxRemoteCache {
+		if app.remoteCache == "" {
+			return errors.New("--max-remote-cache requires --remote-cache")
+		}
+		if !app.push {
+			app.console.Warnf("Warning: --max-remote-cache has no effect without --push; the remote cache is only exported when pushing\n")
+		}
+	}
+	if app.cacheHintAll {
+		if app.remoteCache == "" {
+			return errors.New("--cache-hint-all requires --remote-cache")
+		}
+		if app.maxRemoteCache {
+			// --max-remote-cache exports every intermediate layer already, which is a
+			// superset of the target results that --cache-hint-all would add.
+			app.console.Warnf("Warning: --cache-hint-all has no effect with --max-remote-cache, which already exports all layers\n")
+		}
+	}
 	if (app.imageMode && app.noOutput) || (app.artifactMode && app.noOutput) {
 		if app.ci {
 			app.noOutput = false
@@ -1997,6 +3926,9 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		}
 		artifactName := c.Args().Get(0)
 		if c.NArg() == 2 {
+			if app.outputDir != "" {
+				return errors.New("the dest path and --output-dir conflict; use only one of them to set where the artifact is written")
+			}
 			destPath = c.Args().Get(1)
 		}
 		var err error
@@ -2006,21 +3938,59 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		}
 		target = artifact.Target
 	} else {
+		targetName := c.Args().Get(0)
 		if c.NArg() == 0 {
-			cli.ShowAppHelp(c)
-			return fmt.Errorf(
-				"no target reference provided. Try %s +<target-name>", c.App.Name)
+			var found bool
+			var err error
+			targetName, found, err = findDefaultTarget()
+			if err != nil {
+				return err
+			}
+			if !found {
+				cli.ShowAppHelp(c)
+				return fmt.Errorf(
+					"no target reference provided. Try %s +<target-name>", c.App.Name)
+			}
+			app.console.Printf("No target reference provided; building the default target %s\n", targetName)
+		} else if c.NArg() == 2 && (c.Args().Get(1) == "--help" || c.Args().Get(1) == "-h") {
+			return app.printTargetHelp(c.Args().Get(0))
 		} else if c.NArg() != 1 {
 			cli.ShowAppHelp(c)
 			return errors.New("invalid number of args")
 		}
-		targetName := c.Args().Get(0)
 		var err error
 		target, err = domain.ParseTarget(targetName)
 		if err != nil {
 			return errors.Wrapf(err, "parse target name %s", targetName)
 		}
 	}
+	if app.offline && target.IsRemote() {
+		return fmt.Errorf("cannot build remote target %s in offline mode", target.String())
+	}
+	if app.parseOnly {
+		warnings, err := earthfile2llb.Lint(target, app.cfg.Global.LintDisable)
+		if err != nil {
+			return errors.Wrapf(err, "validate %s", target.String())
+		}
+		for _, w := range warnings {
+			app.console.Warnf("Warning: %s\n", w.String())
+		}
+		app.console.Printf("%s is valid\n", target.String())
+		return nil
+	}
+	if app.dryRun {
+		return app.printBuildPlan(target)
+	}
+	if app.push && app.cfg.Global.ConfirmPush && !app.ci {
+		ok, err := app.confirm(fmt.Sprintf("Build %s and push its images and RUN --push commands?", target.String()))
+		if err != nil {
+			return errors.Wrap(err, "global.confirm_push is set")
+		}
+		if !ok {
+			app.console.Printf("Aborted\n")
+			return nil
+		}
+	}
 	bkClient, bkIP, err := app.newBuildkitdClient(c.Context)
 	if err != nil {
 		return errors.Wrap(err, "buildkitd new client")
@@ -2029,6 +3999,17 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 
 	platformsSlice := make([]*specs.Platform, 0, len(app.platformsStr.Value()))
 	for _, p := range app.platformsStr.Value() {
+		switch p {
+		case "native":
+			// The native platform of the buildkit daemon.
+			platformsSlice = append(platformsSlice, nil)
+			continue
+		case "local":
+			// The platform of the host (using linux on Mac hosts, as Docker Desktop does).
+			platform := llbutil.DefaultPlatform()
+			platformsSlice = append(platformsSlice, &platform)
+			continue
+		}
 		platform, err := llbutil.ParsePlatform(p)
 		if err != nil {
 			return errors.Wrapf(err, "parse platform %s", p)
@@ -2046,7 +4027,7 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 			return errors.Wrapf(err, "read %s", dotEnvPath)
 		}
 	}
-	secretsMap, err := processSecrets(app.secrets.Value(), app.secretFiles.Value(), dotEnvMap)
+	secretsMap, err := processSecrets(app.secrets.Value(), app.secretFiles.Value(), app.jsonSecretFiles.Value(), app.yamlSecretFiles.Value(), dotEnvMap)
 	if err != nil {
 		return err
 	}
@@ -2057,6 +4038,14 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		RepeaterAddr:      fmt.Sprintf("%s:8373", bkIP),
 		Term:              os.Getenv("TERM"),
 	}
+	if app.verbose {
+		if bkIP != "" {
+			app.console.Printf("buildkitd container IP: %s\n", bkIP)
+		} else {
+			app.console.Printf("buildkitd container IP: unknown (using buildkit host %s)\n", app.buildkitHost)
+		}
+		app.console.Printf("Debugger repeater address: %s\n", debuggerSettings.RepeaterAddr)
+	}
 
 	debuggerSettingsData, err := json.Marshal(&debuggerSettings)
 	if err != nil {
@@ -2064,7 +4053,7 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 	}
 	secretsMap[debuggercommon.DebuggerSettingsSecretsKey] = debuggerSettingsData
 
-	sc, err := secretsclient.NewClient(app.apiServer, app.sshAuthSock, app.authToken, app.console.Warnf)
+	sc, err := app.newSecretsClient()
 	if err != nil {
 		return errors.Wrap(err, "failed to create secretsclient")
 	}
@@ -2114,13 +4103,57 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 	defer cleanCollection.Close()
 
 	if app.interactiveDebugging {
-		go terminal.ConnectTerm(c.Context, fmt.Sprintf("127.0.0.1:%d", app.buildkitdSettings.DebuggerPort))
+		debuggerCtx, cancelDebugger := context.WithCancel(c.Context)
+		// Tear down the debugger connection once the build completes.
+		defer cancelDebugger()
+		go func() {
+			err := terminal.ConnectTerm(
+				debuggerCtx, fmt.Sprintf("127.0.0.1:%d", app.buildkitdSettings.DebuggerPort), app.recordPath)
+			if err != nil && debuggerCtx.Err() == nil {
+				app.console.Warnf("Warning: interactive debugging is unavailable: %v\n", err)
+			}
+		}()
 	}
 
-	varCollection, err := variables.ParseCommandLineBuildArgs(app.buildArgs.Value(), dotEnvMap)
+	fileBuildArgs, err := variables.ReadBuildArgFiles(app.buildArgFiles.Value(), app.buildArgFileNewline)
+	if err != nil {
+		return err
+	}
+	// The build args read from files come last, so they take precedence over --build-arg.
+	buildArgs := append(app.buildArgs.Value(), fileBuildArgs...)
+	varCollection, err := variables.ParseCommandLineBuildArgs(buildArgs, dotEnvMap, app.strictDuplicates)
 	if err != nil {
 		return errors.Wrap(err, "parse build args")
 	}
+	if app.sourceDateEpoch != "" {
+		epoch, err := strconv.ParseInt(app.sourceDateEpoch, 10, 64)
+		if err != nil || epoch < 0 {
+			return fmt.Errorf("invalid --source-date-epoch %q: must be a unix timestamp", app.sourceDateEpoch)
+		}
+	}
+	buildkitAttrs := make(map[string]string)
+	for _, attr := range app.buildkitAttrs.Value() {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid --buildkit-attr %q: must be of the form <key>=<value>", attr)
+		}
+		err := builder.ValidateBuildkitAttr(kv[0])
+		if err != nil {
+			return err
+		}
+		buildkitAttrs[kv[0]] = kv[1]
+	}
+	var cacheMountSharing llb.CacheMountSharingMode
+	switch app.cacheMountSharing {
+	case "shared":
+		cacheMountSharing = llb.CacheMountShared
+	case "private":
+		cacheMountSharing = llb.CacheMountPrivate
+	case "locked":
+		cacheMountSharing = llb.CacheMountLocked
+	default:
+		return fmt.Errorf("invalid --cache-mount-sharing value %q: must be one of shared, private or locked", app.cacheMountSharing)
+	}
 	imageResolveMode := llb.ResolveModePreferLocal
 	if app.pull {
 		imageResolveMode = llb.ResolveModeForcePull
@@ -2139,6 +4172,15 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 			cacheExport = app.remoteCache
 		}
 	}
+	var progressJSON io.Writer
+	if app.outputJSONStream != "" {
+		f, err := os.OpenFile(app.outputJSONStream, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "open json stream output %s", app.outputJSONStream)
+		}
+		defer f.Close()
+		progressJSON = f
+	}
 	builderOpts := builder.Opt{
 		BkClient:             bkClient,
 		Console:              app.console,
@@ -2149,6 +4191,8 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		CacheImports:         cacheImports,
 		CacheExport:          cacheExport,
 		MaxCacheExport:       maxCacheExport,
+		CacheHintAll:         app.cacheHintAll,
+		EarthlyVersion:       getVersion(),
 		UseInlineCache:       app.useInlineCache,
 		SaveInlineCache:      app.saveInlineCache,
 		SessionID:            app.sessionID,
@@ -2158,12 +4202,17 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		BuildContextProvider: buildContextProvider,
 		GitLookup:            gitLookup,
 		UseFakeDep:           !app.noFakeDep,
+		LocalGitTagDefault:   app.localGitTagDefault,
+		CacheMountSharing:    cacheMountSharing,
+		NoCacheMounts:        app.noCacheMounts,
+		BuildkitAttrs:        buildkitAttrs,
+		SourceDateEpoch:      app.sourceDateEpoch,
+		Strict:               app.strict,
+		ProgressJSON:         progressJSON,
+		ExplainCache:         app.explainCache,
+		Offline:              app.offline,
+		ContainerRuntime:     app.containerRuntime,
 	}
-	b, err := builder.NewBuilder(c.Context, builderOpts)
-	if err != nil {
-		return errors.Wrap(err, "new builder")
-	}
-
 	if len(platformsSlice) != 1 {
 		return errors.Errorf("multi-platform builds are not yet supported on the command line. You may, however, create a target with the instruction BUILD --plaform ... --platform ... %s", target)
 	}
@@ -2173,16 +4222,34 @@ func (app *earthlyApp) actionBuild(c *cli.Context) error {
 		NoOutput:              app.noOutput,
 		OnlyFinalTargetImages: app.imageMode,
 		Platform:              platformsSlice[0],
+		OutputDir:             app.outputDir,
 	}
 	if app.artifactMode {
 		buildOpts.OnlyArtifact = &artifact
 		buildOpts.OnlyArtifactDestPath = destPath
 	}
-	_, err = b.BuildTarget(c.Context, target, buildOpts)
+	// The builder, along with the attachables of its session, is shared by the rebuilds of
+	// --watch. Each solve still runs its own buildkit session: the vendored buildkit client
+	// closes the session at the end of every solve, including a SharedSession.
+	b, err := builder.NewBuilder(c.Context, builderOpts)
 	if err != nil {
-		return errors.Wrap(err, "build target")
+		return errors.Wrap(err, "new builder")
 	}
-	return nil
+	build := func(ctx context.Context) error {
+		_, err := b.BuildTarget(ctx, target, buildOpts)
+		if err != nil {
+			return errors.Wrap(err, "build target")
+		}
+		if c.IsSet("use-inline-cache") && app.useInlineCache && len(b.InlineCacheSources()) == 0 {
+			app.console.Warnf(
+				"Warning: --use-inline-cache had no effect, as the build has no SAVE IMAGE --push or --cache-from images to use as cache\n")
+		}
+		return nil
+	}
+	if app.watch {
+		return app.watchBuild(c.Context, target, build)
+	}
+	return build(c.Context)
 }
 
 func (app *earthlyApp) newBuildkitdClient(ctx context.Context, opts ...client.ClientOpt) (*client.Client, string, error) {
@@ -2195,7 +4262,7 @@ func (app *earthlyApp) newBuildkitdClient(ctx context.Context, opts ...client.Cl
 		if err != nil {
 			return nil, "", errors.Wrap(err, "buildkitd new client (own)")
 		}
-		bkIP, err := buildkitd.GetContainerIP(ctx)
+		bkIP, err := buildkitd.GetContainerIP(ctx, app.containerRuntime)
 		if err != nil {
 			return nil, "", errors.Wrap(err, "get container ip")
 		}
@@ -2203,6 +4270,14 @@ func (app *earthlyApp) newBuildkitdClient(ctx context.Context, opts ...client.Cl
 	}
 
 	// Use provided.
+	if strings.HasPrefix(app.buildkitHost, "ssh://") && app.sshAuthSock != "" && fileutil.FileExists(app.sshAuthSock) {
+		// The ssh connection helper runs ssh, which authenticates via this agent. (On Mac the
+		// default agent socket is only valid within docker, hence the check for its existence.)
+		err := os.Setenv("SSH_AUTH_SOCK", app.sshAuthSock)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "set SSH_AUTH_SOCK")
+		}
+	}
 	bkClient, err := client.New(ctx, app.buildkitHost, opts...)
 	if err != nil {
 		return nil, "", errors.Wrap(err, "buildkitd new client (provided)")
@@ -2251,7 +4326,7 @@ func (app *earthlyApp) updateGitLookupConfig(gitLookup *buildcontext.GitLookup)
 			if !strings.Contains(host, ".") {
 				host += ".com"
 			}
-			pattern = host + "/[^/]+/[^/]+"
+			pattern = regexp.QuoteMeta(host) + "/[^/]+/[^/]+"
 		}
 		auth := v.Auth
 		if auth == "auto" {
@@ -2261,15 +4336,181 @@ func (app *earthlyApp) updateGitLookupConfig(gitLookup *buildcontext.GitLookup)
 		if suffix == "" {
 			suffix = ".git"
 		}
-		err := gitLookup.AddMatcher(k, pattern, v.Substitute, v.User, v.Password, suffix, auth, v.KeyScan)
+		keyScan, err := gitHostKeys(v)
+		if err != nil {
+			return errors.Wrapf(err, "host keys of %s", k)
+		}
+		err = gitLookup.AddMatcher(k, pattern, v.Substitute, v.User, v.Password, suffix, auth, keyScan)
 		if err != nil {
 			return errors.Wrap(err, "gitlookup")
 		}
+		if keyScan == "" && v.TrustOnFirstUse {
+			// The host keys are only scanned once a remote target resolves to the site.
+			err = gitLookup.SetHostKeysFunc(k, app.trustOnFirstUseHostKeys(k))
+			if err != nil {
+				return errors.Wrap(err, "gitlookup")
+			}
+		}
+	}
+
+	if app.gitConfigFromHost {
+		err := app.importHostGitConfig(gitLookup)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trustedHostsFile is the file, within the earthly dir, holding the host keys trusted on first use.
+const trustedHostsFile = "known_hosts"
+
+// gitHostKeys returns the SSH host keys, in known_hosts format, configured for a git site. An
+// empty string means that none are configured.
+func gitHostKeys(v config.GitConfig) (string, error) {
+	if v.KeyScan != "" {
+		return v.KeyScan, nil
+	}
+	if v.KnownHosts != "" {
+		data, err := ioutil.ReadFile(v.KnownHosts)
+		if err != nil {
+			return "", errors.Wrapf(err, "read %s", v.KnownHosts)
+		}
+		return string(data), nil
+	}
+	return "", nil
+}
+
+// trustOnFirstUseHostKeys returns the function obtaining the host keys of a git site with
+// trust_on_first_use enabled. The keys are obtained at most once per run.
+func (app *earthlyApp) trustOnFirstUseHostKeys(site string) buildcontext.HostKeysFunc {
+	var once sync.Once
+	var keys string
+	var err error
+	return func() (string, error) {
+		once.Do(func() {
+			keys, err = app.scanHostKeys(site)
+		})
+		return keys, err
+	}
+}
+
+// scanHostKeys returns the host keys of a git site, in known_hosts format. An empty string
+// means that the site is already known in ~/.ssh/known_hosts. Otherwise, the keys trusted on
+// first use are returned, the site being scanned if it has not been trusted yet.
+func (app *earthlyApp) scanHostKeys(site string) (string, error) {
+	host, port := site, ""
+	if h, p, err := net.SplitHostPort(site); err == nil {
+		host, port = h, p
+	}
+	// The name of the site in known_hosts files, as written by ssh-keyscan.
+	name := host
+	if port != "" && port != "22" {
+		name = "[" + host + "]:" + port
+	}
+
+	known, err := knownInUserKnownHosts(name)
+	if err != nil {
+		return "", err
+	}
+	if known {
+		return "", nil
+	}
+
+	trustedHostsPath := filepath.Join(filepath.Dir(app.configPath), trustedHostsFile)
+	var trusted []string
+	if fileutil.FileExists(trustedHostsPath) {
+		data, err := ioutil.ReadFile(trustedHostsPath)
+		if err != nil {
+			return "", errors.Wrapf(err, "read %s", trustedHostsPath)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[0] == name {
+				trusted = append(trusted, line)
+			}
+		}
+	}
+	if len(trusted) > 0 {
+		return strings.Join(trusted, "\n") + "\n", nil
+	}
+
+	app.console.Warnf(
+		"Warning: trusting the SSH host key of %s on first use (trust_on_first_use is enabled); "+
+			"the key is saved in %s and checked on subsequent uses\n", site, trustedHostsPath)
+	args := []string{host}
+	if port != "" {
+		args = []string{"-p", port, host}
+	}
+	out, err := exec.Command("ssh-keyscan", args...).Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "ssh-keyscan %s", site)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return "", fmt.Errorf("ssh-keyscan %s returned no keys", site)
+	}
+	err = os.MkdirAll(filepath.Dir(trustedHostsPath), 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "create %s", filepath.Dir(trustedHostsPath))
+	}
+	f, err := os.OpenFile(trustedHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", trustedHostsPath)
+	}
+	defer f.Close()
+	_, err = f.Write(out)
+	if err != nil {
+		return "", errors.Wrapf(err, "write %s", trustedHostsPath)
+	}
+	return string(out), nil
+}
+
+// knownInUserKnownHosts returns whether ~/.ssh/known_hosts holds a key of the given host
+// (e.g. example.com or [example.com]:2222). ssh-keygen is used, as the entries may be hashed.
+func knownInUserKnownHosts(name string) (bool, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get user home dir")
+	}
+	knownHosts := filepath.Join(homeDir, ".ssh", "known_hosts")
+	if !fileutil.FileExists(knownHosts) {
+		return false, nil
+	}
+	err = exec.Command("ssh-keygen", "-F", name, "-f", knownHosts).Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// Not found.
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "ssh-keygen -F %s", name)
+	}
+	return true, nil
+}
+
+// importHostGitConfig adds the url insteadOf rules and the credential helpers of the host's
+// git config to the git lookup.
+func (app *earthlyApp) importHostGitConfig(gitLookup *buildcontext.GitLookup) error {
+	ctx := context.Background()
+	rules, err := gitutil.HostInsteadOfRules(ctx)
+	if err != nil {
+		return errors.Wrap(err, "import host git config")
+	}
+	for _, rule := range rules {
+		gitLookup.AddInsteadOf(rule)
+	}
+	if gitutil.HostCredentialHelperConfigured(ctx) {
+		gitLookup.SetCredentialsFunc(func(protocol, host string) (string, string, error) {
+			return gitutil.HostCredentials(ctx, protocol, host)
+		})
 	}
 	return nil
 }
 
-func processSecrets(secrets, secretFiles []string, dotEnvMap map[string]string) (map[string][]byte, error) {
+// processSecrets merges the secrets of the .env file, of --secret, of --secret-file and of the
+// JSON and YAML secrets files. Unlike build args, which override one another, a secret may only
+// be defined once across all the sources, as silently overriding a secret is hard to notice.
+func processSecrets(secrets, secretFiles, jsonSecretFiles, yamlSecretFiles []string, dotEnvMap map[string]string) (map[string][]byte, error) {
 	finalSecrets := make(map[string][]byte)
 	for k, v := range dotEnvMap {
 		finalSecrets[k] = []byte(v)
@@ -2310,9 +4551,67 @@ func processSecrets(secrets, secretFiles []string, dotEnvMap map[string]string)
 		}
 		finalSecrets[k] = []byte(data)
 	}
+	for _, files := range []struct {
+		paths     []string
+		unmarshal func([]byte, interface{}) error
+	}{
+		{jsonSecretFiles, json.Unmarshal},
+		{yamlSecretFiles, yaml.Unmarshal},
+	} {
+		for _, path := range files.paths {
+			fileSecrets, err := loadSecretsFile(path, files.unmarshal)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range fileSecrets {
+				if _, ok := finalSecrets[k]; ok {
+					return nil, fmt.Errorf("secret %q already contains a value", k)
+				}
+				finalSecrets[k] = []byte(v)
+			}
+		}
+	}
 	return finalSecrets, nil
 }
 
+// printPaths prints the paths of the config file and of the run directory in use, as
+// requested via --print-config-path and --print-run-path.
+func (app *earthlyApp) printPaths() error {
+	if app.printConfigPath {
+		configPath, err := filepath.Abs(app.configPath)
+		if err != nil {
+			return errors.Wrapf(err, "abs path of %s", app.configPath)
+		}
+		fmt.Fprintln(app.stdout, configPath)
+		if !fileutil.FileExists(configPath) {
+			app.console.Warnf("The config file %s does not exist; the default settings are in use\n", configPath)
+		}
+	}
+	if app.printRunPath {
+		yamlData, err := ioutil.ReadFile(app.configPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to read from %s", app.configPath)
+		}
+		runPath, err := config.Get(yamlData, "global.run_path")
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the run path from %s", app.configPath)
+		}
+		fmt.Fprintln(app.stdout, runPath)
+	}
+	return nil
+}
+
+// configFlagPassed returns true if the config path was passed explicitly on the command line,
+// as opposed to set via EARTHLY_CONFIG or left to its default. A --config flag with the same
+// value as EARTHLY_CONFIG is taken as coming from the env var.
+func configFlagPassed(context *cli.Context, configPath string) bool {
+	if !context.IsSet("config") {
+		return false
+	}
+	envPath, found := os.LookupEnv("EARTHLY_CONFIG")
+	return !found || envPath != configPath
+}
+
 func defaultConfigPath() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
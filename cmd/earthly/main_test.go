@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/earthly/earthly/conslogging"
+	"github.com/moby/buildkit/client"
+	. "github.com/stretchr/testify/assert"
+)
+
+// runApp runs earthly with the given arguments and returns what was printed to stdout and
+// to stderr.
+func runApp(t *testing.T, args ...string) (int, string, string) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	configPath := filepath.Join(dir, "config.yml")
+	configData := fmt.Sprintf("global:\n  run_path: %s\n", filepath.Join(dir, "run"))
+	NoError(t, ioutil.WriteFile(configPath, []byte(configData), 0644))
+
+	var stdout, stderr bytes.Buffer
+	console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+	app := newEarthlyApp(context.Background(), console)
+	app.stdout = &stdout
+	exitCode := app.run(context.Background(), append([]string{"earthly", "--config", configPath}, args...))
+	return exitCode, stdout.String(), stderr.String()
+}
+
+func writeEarthfile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "Earthfile"), []byte(content), 0644))
+	return dir
+}
+
+func TestOutputStreams(t *testing.T) {
+	var tests = []struct {
+		name          string
+		earthfile     string
+		args          []string
+		exitCode      int
+		stdout        string
+		stderrContent []string
+	}{
+		{
+			name:          "bootstrap source",
+			args:          []string{"bootstrap", "--source", "bash"},
+			stdout:        bashCompleteEntry,
+			stderrContent: []string{"loading config values"},
+		},
+		{
+			name:   "completion fish",
+			args:   []string{"completion", "fish"},
+			stdout: fishCompleteEntry,
+		},
+		{
+			name:          "completion unknown shell",
+			args:          []string{"completion", "tcsh"},
+			exitCode:      1,
+			stderrContent: []string{`unsupported shell "tcsh"`},
+		},
+		{
+			name:          "config get",
+			args:          []string{"config", "global.debugger_port"},
+			stdout:        "8373\n",
+			stderrContent: []string{"loading config values"},
+		},
+		{
+			name:          "config unknown key",
+			args:          []string{"config", "global.unknown", "1"},
+			exitCode:      1,
+			stderrContent: []string{"unknown config key global.unknown"},
+		},
+		{
+			name:          "deprecated flag",
+			args:          []string{"--buildkit-cache-size-mb", "1000", "completion", "fish"},
+			stdout:        fishCompleteEntry,
+			stderrContent: []string{"Warning: the --buildkit-cache-size-mb command flag is deprecated"},
+		},
+		{
+			name:          "deprecated flag with strict",
+			args:          []string{"--strict", "--buildkit-cache-size-mb", "1000", "completion", "fish"},
+			exitCode:      1,
+			stderrContent: []string{"the --buildkit-cache-size-mb command flag is deprecated", "failing due to --strict"},
+		},
+		{
+			name: "register terms not accepted by assume yes",
+			args: []string{"--assume-yes", "--ssh-auth-sock", "", "account", "register",
+				"--email", "user@example.com", "--token", "token", "--password", "password"},
+			exitCode:      1,
+			stderrContent: []string{"the terms of service must be accepted (see --accept-terms-of-service-privacy)"},
+		},
+		{
+			name:          "explain",
+			earthfile:     "FROM alpine\n\nbuild:\n\tBUILD +dep\n\ndep:\n\tRUN true\n",
+			args:          []string{"explain", "{dir}+build"},
+			stdout:        "{dir}+build\n  {dir}+dep\n",
+			stderrContent: []string{"loading config values"},
+		},
+		{
+			name:          "explain with cycle",
+			earthfile:     "FROM alpine\n\na:\n\tBUILD +b\n\nb:\n\tBUILD +a\n",
+			args:          []string{"explain", "{dir}+a"},
+			exitCode:      1,
+			stdout:        "{dir}+a\n  {dir}+b\n    {dir}+a (cycle)\n",
+			stderrContent: []string{"Dependency cycle", "found 1 dependency cycle(s)"},
+		},
+		{
+			name:      "explain dot",
+			earthfile: "FROM alpine\n\nbuild:\n\tBUILD +dep\n\ndep:\n\tRUN true\n",
+			args:      []string{"explain", "--dot", "{dir}+build"},
+			stdout: "digraph earthly {\n\tnode [shape=box];\n\t\"{dir}+build\";\n\t\"{dir}+dep\";\n" +
+				"\t\"{dir}+build\" -> \"{dir}+dep\";\n}\n",
+			stderrContent: []string{"loading config values"},
+		},
+		{
+			name:      "explain dot with cycle",
+			earthfile: "FROM alpine\n\na:\n\tBUILD +b\n\nb:\n\tBUILD +a\n",
+			args:      []string{"explain", "--dot", "{dir}+a"},
+			stdout: "digraph earthly {\n\tnode [shape=box];\n\t\"{dir}+a\";\n\t\"{dir}+b\";\n" +
+				"\t\"{dir}+a\" -> \"{dir}+b\";\n\t\"{dir}+b\" -> \"{dir}+a\" [color=red];\n}\n",
+			stderrContent: []string{"loading config values"},
+		},
+		{
+			name:          "parse only",
+			earthfile:     "VERSION 0.5\nFROM alpine\n\nbuild:\n\tBUILD +dep\n\ndep:\n\tRUN true\n",
+			args:          []string{"--parse-only", "{dir}+build"},
+			stderrContent: []string{"+build is valid"},
+		},
+		{
+			name:          "parse only with lint warnings",
+			earthfile:     "FROM alpine\nARG UNUSED\n\nbuild:\n\tRUN true\n",
+			args:          []string{"--parse-only", "{dir}+build"},
+			stderrContent: []string{"Earthfile:2: ARG UNUSED is declared but never used [unused-arg]", "+build is valid"},
+		},
+		{
+			name:          "parse only with missing target",
+			earthfile:     "FROM alpine\n\nbuild:\n\tBUILD +dep\n",
+			args:          []string{"--parse-only", "{dir}+build"},
+			exitCode:      1,
+			stderrContent: []string{"target dep not defined"},
+		},
+		{
+			name:          "parse only with unknown command",
+			earthfile:     "FROM alpine\n\nbuild:\n\tRUNN true\n",
+			args:          []string{"--parse-only", "{dir}+build"},
+			exitCode:      1,
+			stderrContent: []string{"invalid command RUNN"},
+		},
+		{
+			name:          "parse only with syntax error",
+			earthfile:     "FROM alpine\n\nbuild:\n\tRUN true\n  bad indent\n",
+			args:          []string{"--parse-only", "{dir}+build"},
+			exitCode:      1,
+			stderrContent: []string{"syntax error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			stdout := tt.stdout
+			if tt.earthfile != "" {
+				dir := writeEarthfile(t, tt.earthfile)
+				args = nil
+				for _, arg := range tt.args {
+					args = append(args, strings.ReplaceAll(arg, "{dir}", dir))
+				}
+				stdout = strings.ReplaceAll(tt.stdout, "{dir}", dir)
+			}
+			exitCode, actualStdout, actualStderr := runApp(t, args...)
+			Equal(t, tt.exitCode, exitCode)
+			Equal(t, stdout, actualStdout)
+			for _, s := range tt.stderrContent {
+				Contains(t, actualStderr, s)
+			}
+		})
+	}
+}
+
+func TestExplainOutput(t *testing.T) {
+	var tests = []struct {
+		name      string
+		earthfile string
+		args      []string
+		exitCode  int
+		output    string
+	}{
+		{
+			name:      "tree",
+			earthfile: "FROM alpine\n\nbuild:\n\tBUILD +dep\n\ndep:\n\tRUN true\n",
+			args:      []string{"explain"},
+			output:    "{dir}+build\n  {dir}+dep\n",
+		},
+		{
+			name:      "tree with cycle",
+			earthfile: "FROM alpine\n\nbuild:\n\tBUILD +build\n",
+			args:      []string{"explain"},
+			exitCode:  1,
+			output:    "{dir}+build\n  {dir}+build (cycle)\n",
+		},
+		{
+			name:      "dot",
+			earthfile: "FROM alpine\n\nbuild:\n\tBUILD +dep\n\ndep:\n\tRUN true\n",
+			args:      []string{"explain", "--dot"},
+			output: "digraph earthly {\n\tnode [shape=box];\n\t\"{dir}+build\";\n\t\"{dir}+dep\";\n" +
+				"\t\"{dir}+build\" -> \"{dir}+dep\";\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeEarthfile(t, tt.earthfile)
+			outputPath := filepath.Join(dir, "graph.out")
+			args := append(append([]string{}, tt.args...), "--output", outputPath, dir+"+build")
+			exitCode, stdout, _ := runApp(t, args...)
+			Equal(t, tt.exitCode, exitCode)
+			// The graph is written to the output file only.
+			Equal(t, "", stdout)
+			data, err := ioutil.ReadFile(outputPath)
+			NoError(t, err)
+			Equal(t, strings.ReplaceAll(tt.output, "{dir}", dir), string(data))
+		})
+	}
+
+	dir := writeEarthfile(t, "FROM alpine\n\nbuild:\n\tRUN true\n")
+	exitCode, _, stderr := runApp(t, "explain", "--output", filepath.Join(dir, "missing", "graph.out"), dir+"+build")
+	Equal(t, 1, exitCode)
+	Contains(t, stderr, "create output file")
+}
+
+func TestProcessSecretsDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	secretPath := filepath.Join(dir, "secret")
+	NoError(t, ioutil.WriteFile(secretPath, []byte("file"), 0644))
+	yamlPath := filepath.Join(dir, "secrets.yml")
+	NoError(t, ioutil.WriteFile(yamlPath, []byte("db:\n  password: yaml\n"), 0644))
+	dotEnv := map[string]string{"A": "dotenv"}
+
+	var tests = []struct {
+		name        string
+		secrets     []string
+		secretFiles []string
+		yamlFiles   []string
+		expected    map[string]string
+	}{
+		{"no duplicates", []string{"B=arg"}, []string{"C=" + secretPath}, []string{yamlPath},
+			map[string]string{"A": "dotenv", "B": "arg", "C": "file", "db.password": "yaml"}},
+		{"secret and dotenv", []string{"A=arg"}, nil, nil, nil},
+		{"secrets", []string{"B=1", "B=2"}, nil, nil, nil},
+		{"secret and secret file", []string{"C=arg"}, []string{"C=" + secretPath}, nil, nil},
+		{"secret and yaml file", []string{"db.password=arg"}, nil, []string{yamlPath}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secrets, err := processSecrets(tt.secrets, tt.secretFiles, nil, tt.yamlFiles, dotEnv)
+			if tt.expected == nil {
+				Error(t, err)
+				Contains(t, err.Error(), "already contains a value")
+				return
+			}
+			NoError(t, err)
+			actual := make(map[string]string)
+			for k, v := range secrets {
+				actual[k] = string(v)
+			}
+			Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestMissingConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	// The default run path is within the home directory.
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+	missingPath := filepath.Join(dir, "missing.yml")
+
+	var tests = []struct {
+		name     string
+		env      bool
+		args     []string
+		exitCode int
+	}{
+		{"via env var", true, nil, 0},
+		{"via flag", false, []string{"--config", missingPath}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env {
+				defer os.Unsetenv("EARTHLY_CONFIG")
+				os.Setenv("EARTHLY_CONFIG", missingPath)
+			}
+			var stdout, stderr bytes.Buffer
+			console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+			app := newEarthlyApp(context.Background(), console)
+			app.stdout = &stdout
+			args := append(append([]string{"earthly"}, tt.args...), "bootstrap", "--source", "bash")
+			exitCode := app.run(context.Background(), args)
+			Equal(t, tt.exitCode, exitCode, stderr.String())
+			if tt.exitCode == 0 {
+				Equal(t, bashCompleteEntry, stdout.String())
+			} else {
+				Contains(t, stderr.String(), "failed to read from")
+			}
+		})
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	var tests = []struct {
+		name     string
+		config   string
+		args     []string
+		exitCode int
+		stdout   string
+		expected string
+	}{
+		{
+			name:     "set keeps comments",
+			config:   "# Earthly config.\nglobal:\n  # The port.\n  debugger_port: 8373\n",
+			args:     []string{"global.debugger_port", "8374"},
+			expected: "# Earthly config.\nglobal:\n  # The port.\n  debugger_port: 8374\n",
+		},
+		{
+			name:     "set fixes malformed config",
+			config:   "global:\n  cache_size_mb: lots\n",
+			args:     []string{"global.cache_size_mb", "1000"},
+			expected: "global:\n  cache_size_mb: 1000\n",
+		},
+		{
+			name:     "get with run path which cannot be created",
+			config:   "global:\n  run_path: /dev/null/run\n",
+			args:     []string{"global.debugger_port"},
+			stdout:   "8373\n",
+			expected: "global:\n  run_path: /dev/null/run\n",
+		},
+		{
+			name:     "set run path which cannot be created",
+			config:   "",
+			args:     []string{"global.run_path", "/dev/null/run"},
+			exitCode: 1,
+			expected: "",
+		},
+		{
+			name:     "set unknown container runtime",
+			config:   "",
+			args:     []string{"global.container_runtime", "lxc"},
+			exitCode: 1,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "earthly-main-test")
+			NoError(t, err)
+			defer os.RemoveAll(dir)
+			configPath := filepath.Join(dir, "config.yml")
+			NoError(t, ioutil.WriteFile(configPath, []byte(tt.config), 0644))
+
+			var stdout, stderr bytes.Buffer
+			console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+			app := newEarthlyApp(context.Background(), console)
+			app.stdout = &stdout
+			args := append([]string{"earthly", "--config", configPath, "config"}, tt.args...)
+			exitCode := app.run(context.Background(), args)
+			Equal(t, tt.exitCode, exitCode, stderr.String())
+			Equal(t, tt.stdout, stdout.String())
+			data, err := ioutil.ReadFile(configPath)
+			NoError(t, err)
+			Equal(t, tt.expected, string(data))
+		})
+	}
+}
+
+func TestPrintPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.yml")
+	// The run directory cannot be created: only its path is printed.
+	NoError(t, ioutil.WriteFile(configPath, []byte("global:\n  run_path: /dev/null/run\n"), 0644))
+
+	var tests = []struct {
+		name   string
+		args   []string
+		stdout string
+	}{
+		{"config path", []string{"--print-config-path"}, configPath + "\n"},
+		{"run path", []string{"--print-run-path"}, "/dev/null/run\n"},
+		{"both", []string{"--print-config-path", "--print-run-path"}, configPath + "\n/dev/null/run\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+			app := newEarthlyApp(context.Background(), console)
+			app.stdout = &stdout
+			args := append([]string{"earthly", "--config", configPath}, tt.args...)
+			exitCode := app.run(context.Background(), args)
+			Equal(t, 0, exitCode, stderr.String())
+			Equal(t, tt.stdout, stdout.String())
+		})
+	}
+}
+
+func TestScanHostKeysTrusted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	// No ~/.ssh/known_hosts, so that the keys trusted on first use are looked up.
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+	trusted := "example.com ssh-ed25519 AAAA\n[example.com]:2222 ssh-ed25519 BBBB\n"
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, trustedHostsFile), []byte(trusted), 0644))
+
+	var tests = []struct {
+		site     string
+		expected string
+	}{
+		{"example.com", "example.com ssh-ed25519 AAAA\n"},
+		{"example.com:22", "example.com ssh-ed25519 AAAA\n"},
+		{"example.com:2222", "[example.com]:2222 ssh-ed25519 BBBB\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		app := newEarthlyApp(context.Background(), conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding))
+		app.configPath = filepath.Join(dir, "config.yml")
+		keys, err := app.trustOnFirstUseHostKeys(tt.site)()
+		NoError(t, err, tt.site)
+		Equal(t, tt.expected, keys, tt.site)
+		Empty(t, stderr.String(), tt.site)
+	}
+}
+
+func TestStaleBuildRecords(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration) *time.Time {
+		t := now.Add(-ago)
+		return &t
+	}
+	records := []*client.UsageInfo{
+		{ID: "build1-a", LastUsedAt: at(time.Minute)},
+		{ID: "build1-b", LastUsedAt: at(3 * time.Minute)},
+		{ID: "build2-a", LastUsedAt: at(time.Hour)},
+		{ID: "build2-in-use", LastUsedAt: at(time.Hour), InUse: true},
+		{ID: "build3-a", CreatedAt: now.Add(-47*time.Hour - 2*time.Minute)},
+		{ID: "build3-b", LastUsedAt: at(47 * time.Hour)},
+	}
+
+	var tests = []struct {
+		keepBuilds int
+		expected   []string
+	}{
+		{1, []string{"build2-a", "build3-b", "build3-a"}},
+		{2, []string{"build3-b", "build3-a"}},
+		{3, nil},
+		{10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("keep %d", tt.keepBuilds), func(t *testing.T) {
+			Equal(t, tt.expected, staleBuildRecords(records, tt.keepBuilds, defaultPruneBuildGap))
+		})
+	}
+	// With a larger gap, the first two builds count as one.
+	Equal(t, []string{"build3-b", "build3-a"}, staleBuildRecords(records, 1, 2*time.Hour))
+}
+
+func TestBinaryName(t *testing.T) {
+	var tests = []struct {
+		binPath  string
+		expected string
+	}{
+		{"earthly", "earthly"},
+		{"earth", "earth"},
+		{"/usr/local/bin/earthly", "earthly"},
+		{"./earth", "earth"},
+		{"../bin/earthly", "earthly"},
+		{"earthly.exe", "earthly"},
+		{"EARTH.EXE", "EARTH"},
+		{"earthly-v0.5", "earthly-v0.5"},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.expected, binaryName(tt.binPath), tt.binPath)
+	}
+}
+
+func TestInvokedBinaryPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-main-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	NoError(t, err)
+	earthlyPath := filepath.Join(dir, "earthly")
+	earthPath := filepath.Join(dir, "earth")
+	NoError(t, ioutil.WriteFile(earthlyPath, []byte("binary"), 0755))
+	NoError(t, os.Symlink(earthlyPath, earthPath))
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	lookPath := func(name string) (string, error) {
+		if name == "earth" || name == "earthly" {
+			return filepath.Join(dir, name), nil
+		}
+		return "", fmt.Errorf("%s not found in PATH", name)
+	}
+	var tests = []struct {
+		name     string
+		arg0     string
+		expected string
+	}{
+		{"absolute", earthPath, earthPath},
+		{"relative", "./earth", earthPath},
+		{"relative parent", filepath.Join("..", filepath.Base(dir), "earthly"), earthlyPath},
+		{"path lookup", "earth", earthPath},
+		{"path lookup earthly", "earthly", earthlyPath},
+		{"not in path", "other", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := invokedBinaryPath(tt.arg0, lookPath)
+			if tt.expected == "" {
+				Error(t, err)
+				return
+			}
+			NoError(t, err)
+			Equal(t, tt.expected, actual)
+			// The symlink is not resolved, so that earth can be told apart from earthly.
+			Equal(t, binaryName(tt.arg0), binaryName(actual))
+		})
+	}
+
+	Equal(t, earthlyPath, siblingBinaryPath(earthPath, "earthly"))
+	Equal(t, filepath.Join(dir, "earthly.exe"), siblingBinaryPath(filepath.Join(dir, "earth.exe"), "earthly"))
+}
+
+func TestSymlinkEarthlyToEarth(t *testing.T) {
+	for _, noEarthSymlink := range []bool{false, true} {
+		t.Run(fmt.Sprintf("no earth symlink %v", noEarthSymlink), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "earthly-main-test")
+			NoError(t, err)
+			defer os.RemoveAll(dir)
+			// The legacy earth binary is recognized by its contents.
+			binary := []byte("docs.earthly.dev api.earthly.dev Earthfile")
+			earthlyPath := filepath.Join(dir, "earthly")
+			earthPath := filepath.Join(dir, "earth")
+			NoError(t, ioutil.WriteFile(earthlyPath, binary, 0755))
+			NoError(t, ioutil.WriteFile(earthPath, binary, 0755))
+
+			var stdout, stderr bytes.Buffer
+			console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+			app := newEarthlyApp(context.Background(), console)
+			app.noEarthSymlink = noEarthSymlink
+			NoError(t, app.symlinkEarthlyToEarth(earthlyPath))
+
+			fi, err := os.Lstat(earthPath)
+			NoError(t, err)
+			if noEarthSymlink {
+				True(t, fi.Mode().IsRegular())
+				return
+			}
+			Equal(t, os.ModeSymlink, fi.Mode()&os.ModeSymlink)
+			target, err := os.Readlink(earthPath)
+			NoError(t, err)
+			Equal(t, earthlyPath, target)
+		})
+	}
+}
+
+func TestTokenExpiryString(t *testing.T) {
+	expiry := time.Date(2031, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	Equal(t, "never expire", tokenExpiryString(expiry, true, false))
+	Equal(t, "never expire", tokenExpiryString(expiry, true, true))
+	Regexp(t, `^expire at 2031-03-01T11:30:00Z \(.+ from now\)$`, tokenExpiryString(expiry, false, true))
+	Regexp(t, `^expire in .+ from now$`, tokenExpiryString(expiry, false, false))
+}
+
+func TestFindDefaultTarget(t *testing.T) {
+	var tests = []struct {
+		name      string
+		earthfile string
+		target    string
+		found     bool
+		err       bool
+	}{
+		{"default target", "default:\n\tRUN echo a\n", "+default", true, false},
+		{"no default target", "other:\n\tRUN echo a\n", "", false, false},
+		{"syntax error", "default:\n\tRUN echo a\n    RUN echo b\n", "", false, true},
+	}
+
+	wd, err := os.Getwd()
+	NoError(t, err)
+	defer os.Chdir(wd)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NoError(t, os.Chdir(writeEarthfile(t, tt.earthfile)))
+			target, found, err := findDefaultTarget()
+			if tt.err {
+				Error(t, err)
+				return
+			}
+			NoError(t, err)
+			Equal(t, tt.found, found)
+			Equal(t, tt.target, target)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// loadSecretsFile reads a JSON or YAML file holding a map of secret names to values. Nested
+// maps are flattened, joining the keys with dots (e.g. {"db": {"password": ...}} defines the
+// secret db.password).
+func loadSecretsFile(path string, unmarshal func([]byte, interface{}) error) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %q", path)
+	}
+	var value interface{}
+	err = unmarshal(data, &value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %q", path)
+	}
+	secrets := make(map[string]string)
+	err = flattenSecrets("", value, secrets)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid secrets file %q", path)
+	}
+	return secrets, nil
+}
+
+// flattenSecrets adds the secrets of the given value, named under the given prefix, to secrets.
+func flattenSecrets(prefix string, value interface{}, secrets map[string]string) error {
+	var m map[string]interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		// JSON objects.
+		m = v
+	case map[interface{}]interface{}:
+		// YAML mappings.
+		m = make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			m[fmt.Sprintf("%v", k)] = vv
+		}
+	case []interface{}:
+		return errors.Errorf("the value of %q is a list, which is not supported", prefix)
+	case nil:
+		if prefix == "" {
+			// Empty file.
+			return nil
+		}
+		secrets[prefix] = ""
+		return nil
+	case float64:
+		// JSON numbers, which are written back as is (e.g. 1234567890 rather than 1.23456789e+09).
+		if prefix == "" {
+			return errors.New("expected a map of secret names to values")
+		}
+		secrets[prefix] = strconv.FormatFloat(v, 'f', -1, 64)
+		return nil
+	default:
+		if prefix == "" {
+			return errors.New("expected a map of secret names to values")
+		}
+		secrets[prefix] = fmt.Sprintf("%v", v)
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		if _, found := secrets[name]; found {
+			return errors.Errorf("secret %q is defined more than once", name)
+		}
+		err := flattenSecrets(name, m[k], secrets)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
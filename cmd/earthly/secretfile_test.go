@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadSecretsFile(t *testing.T) {
+	var tests = []struct {
+		name      string
+		content   string
+		unmarshal func([]byte, interface{}) error
+		expected  map[string]string
+		err       bool
+	}{
+		{"json", `{"key": "value", "num": 1234567890, "db": {"password": "pw", "port": 5432}}`, json.Unmarshal,
+			map[string]string{"key": "value", "num": "1234567890", "db.password": "pw", "db.port": "5432"}, false},
+		{"yaml", "key: value\nflag: true\ndb:\n  password: pw\n  empty:\n", yaml.Unmarshal,
+			map[string]string{"key": "value", "flag": "true", "db.password": "pw", "db.empty": ""}, false},
+		{"empty", "", yaml.Unmarshal, map[string]string{}, false},
+		{"list", `{"keys": ["a", "b"]}`, json.Unmarshal, nil, true},
+		{"not a map", `"value"`, json.Unmarshal, nil, true},
+		{"duplicate", "db.password: a\ndb:\n  password: b\n", yaml.Unmarshal, nil, true},
+	}
+
+	dir, err := ioutil.TempDir("", "earthly-secrets-file-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			NoError(t, ioutil.WriteFile(path, []byte(tt.content), 0644))
+			secrets, err := loadSecretsFile(path, tt.unmarshal)
+			if tt.err {
+				Error(t, err)
+				return
+			}
+			NoError(t, err)
+			Equal(t, tt.expected, secrets)
+		})
+	}
+}
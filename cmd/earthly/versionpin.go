@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/earthly/earthly/fileutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// versionPinPath is the path, relative to a repository, of the file pinning the
+	// earthly version to use.
+	versionPinPath = ".earthly/version"
+	// versionPinExecEnv is set when earthly re-executes itself as the pinned version,
+	// to avoid exec loops.
+	versionPinExecEnv  = "EARTHLY_VERSION_PIN_EXEC"
+	releaseDownloadURL = "https://github.com/earthly/earthly/releases/download"
+	// releaseChecksumsFile is the release asset listing the sha256 checksums of the
+	// binaries of the release, one "<checksum>  <file name>" per line.
+	releaseChecksumsFile = "checksums.txt"
+)
+
+// versionRegexp matches the strict semver versions which can be pinned, e.g. v0.5.0 or
+// 0.5.0-rc1.
+var versionRegexp = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+
+// findPinnedVersion looks for a .earthly/version file in the given directory and its
+// parents. It returns the pinned version and the path of the file, or an empty version if
+// no version is pinned.
+func findPinnedVersion(dir string) (string, string, error) {
+	for {
+		pinPath := filepath.Join(dir, versionPinPath)
+		if fileutil.FileExists(pinPath) {
+			data, err := ioutil.ReadFile(pinPath)
+			if err != nil {
+				return "", "", errors.Wrapf(err, "read %s", pinPath)
+			}
+			return strings.TrimSpace(string(data)), pinPath, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// checkPinnedVersion compares the running version against the version pinned by the
+// repository, if any. On mismatch, a warning is printed, unless the version_auto_download
+// setting is enabled, in which case the pinned version is downloaded (if needed) and
+// executed in place of the current process.
+func (app *earthlyApp) checkPinnedVersion() error {
+	if _, isPinExec := os.LookupEnv(versionPinExecEnv); isPinExec {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "get working directory")
+	}
+	pinned, pinPath, err := findPinnedVersion(wd)
+	if err != nil {
+		return err
+	}
+	if pinned == "" {
+		return nil
+	}
+	err = validateVersion(pinned)
+	if err != nil {
+		return errors.Wrapf(err, "read version pinned by %s", pinPath)
+	}
+	if normalizeVersion(pinned) == normalizeVersion(Version) {
+		return nil
+	}
+	if !app.cfg.Global.VersionAutoDownload {
+		app.console.Warnf(
+			"Warning: %s pins earthly version %s, but this is earthly %s; "+
+				"set global.version_auto_download to true in %s to use the pinned version automatically\n",
+			pinPath, pinned, getVersion(), app.configPath)
+		return nil
+	}
+	binPath, err := app.downloadVersion(normalizeVersion(pinned))
+	if err != nil {
+		return errors.Wrapf(err, "download earthly %s pinned by %s", pinned, pinPath)
+	}
+	app.console.Printf("Using earthly %s, as pinned by %s\n", pinned, pinPath)
+	env := append(os.Environ(), fmt.Sprintf("%s=1", versionPinExecEnv))
+	err = syscall.Exec(binPath, append([]string{binPath}, os.Args[1:]...), env)
+	return errors.Wrapf(err, "exec %s", binPath)
+}
+
+// downloadVersion downloads the given release of earthly, unless it has already been
+// downloaded, and returns the path of the binary. The download is verified against the
+// checksums published with the release.
+func (app *earthlyApp) downloadVersion(version string) (string, error) {
+	err := validateVersion(version)
+	if err != nil {
+		return "", err
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "get home dir")
+	}
+	binDir := filepath.Join(homeDir, ".earthly", "versions", version)
+	binPath := filepath.Join(binDir, "earthly")
+	if fileutil.FileExists(binPath) {
+		return binPath, nil
+	}
+	err = os.MkdirAll(binDir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "create %s", binDir)
+	}
+	binName := fmt.Sprintf("earthly-%s-%s", runtime.GOOS, runtime.GOARCH)
+	checksumsURL := fmt.Sprintf("%s/%s/%s", releaseDownloadURL, version, releaseChecksumsFile)
+	checksums, err := httpGetAll(checksumsURL)
+	if err != nil {
+		return "", err
+	}
+	expectedChecksum, err := findChecksum(checksums, binName)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", checksumsURL)
+	}
+	url := fmt.Sprintf("%s/%s/%s", releaseDownloadURL, version, binName)
+	app.console.Printf("Downloading %s\n", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", errors.Wrapf(err, "get %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+	// Download to a temporary file first, so that interrupted downloads are never used.
+	tmpFile, err := ioutil.TempFile(binDir, "earthly-download")
+	if err != nil {
+		return "", errors.Wrap(err, "create temp file")
+	}
+	defer os.Remove(tmpFile.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, hash), resp.Body)
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "download %s", url)
+	}
+	if closeErr != nil {
+		return "", errors.Wrapf(closeErr, "close %s", tmpFile.Name())
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != expectedChecksum {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, expectedChecksum, checksum)
+	}
+	err = os.Chmod(tmpFile.Name(), 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "chmod %s", tmpFile.Name())
+	}
+	err = os.Rename(tmpFile.Name(), binPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "rename %s to %s", tmpFile.Name(), binPath)
+	}
+	return binPath, nil
+}
+
+// httpGetAll returns the body of the given url.
+func httpGetAll(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get %s", url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: unexpected status %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", url)
+	}
+	return data, nil
+}
+
+// findChecksum returns the sha256 checksum of the given file, from a list of checksums in
+// the format of sha256sum.
+func findChecksum(checksums []byte, fileName string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks the files read in binary mode with a leading *.
+		if strings.TrimPrefix(fields[1], "*") != fileName {
+			continue
+		}
+		checksum := strings.ToLower(fields[0])
+		decoded, err := hex.DecodeString(checksum)
+		if err != nil || len(decoded) != sha256.Size {
+			return "", fmt.Errorf("invalid checksum %q for %s", fields[0], fileName)
+		}
+		return checksum, nil
+	}
+	err := scanner.Err()
+	if err != nil {
+		return "", errors.Wrap(err, "scan checksums")
+	}
+	return "", fmt.Errorf("no checksum for %s", fileName)
+}
+
+// validateVersion returns an error if the given version is not a strict semver version.
+func validateVersion(version string) error {
+	if !versionRegexp.MatchString(version) {
+		return fmt.Errorf("invalid version %q: must be of the form v<major>.<minor>.<patch>", version)
+	}
+	return nil
+}
+
+// normalizeVersion returns the version with a leading v (e.g. v0.5.0).
+func normalizeVersion(version string) string {
+	if strings.HasPrefix(version, "v") {
+		return version
+	}
+	return "v" + version
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestFindPinnedVersion(t *testing.T) {
+	root, err := ioutil.TempDir("", "earthly-version-pin-test")
+	NoError(t, err)
+	defer os.RemoveAll(root)
+	sub := filepath.Join(root, "a", "b")
+	NoError(t, os.MkdirAll(sub, 0755))
+
+	pinned, pinPath, err := findPinnedVersion(sub)
+	NoError(t, err)
+	Equal(t, "", pinned)
+	Equal(t, "", pinPath)
+
+	NoError(t, os.MkdirAll(filepath.Join(root, ".earthly"), 0755))
+	rootPin := filepath.Join(root, versionPinPath)
+	NoError(t, ioutil.WriteFile(rootPin, []byte("v0.5.0\n"), 0644))
+	pinned, pinPath, err = findPinnedVersion(sub)
+	NoError(t, err)
+	Equal(t, "v0.5.0", pinned)
+	Equal(t, rootPin, pinPath)
+
+	// The closest pin wins.
+	NoError(t, os.MkdirAll(filepath.Join(root, "a", ".earthly"), 0755))
+	subPin := filepath.Join(root, "a", versionPinPath)
+	NoError(t, ioutil.WriteFile(subPin, []byte("0.4.6"), 0644))
+	pinned, pinPath, err = findPinnedVersion(sub)
+	NoError(t, err)
+	Equal(t, "0.4.6", pinned)
+	Equal(t, subPin, pinPath)
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	Equal(t, "v0.5.0", normalizeVersion("v0.5.0"))
+	Equal(t, "v0.5.0", normalizeVersion("0.5.0"))
+	Equal(t, "v0.5.0-rc1", normalizeVersion("0.5.0-rc1"))
+}
+
+func TestValidateVersion(t *testing.T) {
+	var tests = []struct {
+		version string
+		valid   bool
+	}{
+		{"v0.5.0", true},
+		{"0.5.0", true},
+		{"v0.5.0-rc1", true},
+		{"v0.5.0-rc.1", true},
+		{"", false},
+		{"v0.5", false},
+		{"latest", false},
+		{"v0.5.0/../../x", false},
+		{"../v0.5.0", false},
+		{"v0.5.0 ", false},
+		{"v0.5.0-rc1\nv0.6.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			err := validateVersion(tt.version)
+			if tt.valid {
+				NoError(t, err)
+			} else {
+				Error(t, err)
+			}
+		})
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	checksums := []byte(
+		"0000000000000000000000000000000000000000000000000000000000000000  earthly-darwin-amd64\n" +
+			checksum + " *earthly-linux-amd64\n" +
+			"abc  earthly-linux-arm64\n")
+
+	actual, err := findChecksum(checksums, "earthly-linux-amd64")
+	NoError(t, err)
+	Equal(t, checksum, actual)
+
+	_, err = findChecksum(checksums, "earthly-linux-arm64")
+	Error(t, err)
+	_, err = findChecksum(checksums, "earthly-windows-amd64")
+	Error(t, err)
+}
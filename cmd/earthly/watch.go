@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/domain"
+
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+const (
+	// watchSettleDelay is how long changes are ignored for after a build completes, as the
+	// outputs of the build (SAVE ARTIFACT ... AS LOCAL) may be written to the build context.
+	watchSettleDelay = 500 * time.Millisecond
+	// watchDebounce is how long the build context needs to stay unchanged before a rebuild
+	// starts, so that rapid edits (e.g. an editor saving several files) cause a single rebuild.
+	watchDebounce = 300 * time.Millisecond
+	// maxListedChanges is the maximum number of changed files listed before a rebuild.
+	maxListedChanges = 3
+)
+
+// watchIgnore tells which paths of a build context are not watched: the .git directories
+// and, as they are not part of the build context, the paths matching its earthignore file.
+type watchIgnore struct {
+	root string
+	pm   *fileutils.PatternMatcher
+}
+
+func newWatchIgnore(root string) (*watchIgnore, error) {
+	patterns, err := buildcontext.ReadEarthIgnore(root)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := fileutils.NewPatternMatcher(patterns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filepath.Join(root, buildcontext.EarthIgnoreFile))
+	}
+	return &watchIgnore{root: root, pm: pm}, nil
+}
+
+// ignored returns true if the changes of the given path do not affect the build.
+func (wi *watchIgnore) ignored(path string) bool {
+	rel, err := filepath.Rel(wi.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == ".git" {
+			return true
+		}
+	}
+	if rel == "Earthfile" || rel == "build.earth" {
+		// The build file is always used, even if the earthignore file matches it.
+		return false
+	}
+	matched, err := wi.pm.Matches(rel)
+	return err == nil && matched
+}
+
+// skipDir returns true if the given directory, and all of its contents, can be left
+// unwatched. This is not the case of ignored directories when the earthignore file has
+// exclusions (!pattern), as they may include some of their contents again.
+func (wi *watchIgnore) skipDir(path string) bool {
+	if filepath.Base(path) == ".git" && path != wi.root {
+		return true
+	}
+	return wi.ignored(path) && !wi.pm.Exclusions()
+}
+
+// newContextWatcher returns a watcher of the given directory and of all of its
+// subdirectories, except for the ones skipped by wi.
+func newContextWatcher(dir string, wi *watchIgnore) (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "new watcher")
+	}
+	err = addWatchDirs(watcher, dir, wi)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+func addWatchDirs(watcher *fsnotify.Watcher, root string, wi *watchIgnore) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if wi.skipDir(path) {
+			return filepath.SkipDir
+		}
+		err = watcher.Add(path)
+		if err != nil {
+			return errors.Wrapf(err, "watch %s", path)
+		}
+		return nil
+	})
+}
+
+// watchBuild runs the build and runs it again each time the build context of the target
+// (which includes its Earthfile) changes, until the context is cancelled (e.g. by Ctrl-C).
+// Failed builds do not end the loop.
+func (app *earthlyApp) watchBuild(ctx context.Context, target domain.Target, build func(context.Context) error) error {
+	if target.IsRemote() {
+		return errors.New("--watch is only supported for local targets")
+	}
+	dir := filepath.FromSlash(target.LocalPath)
+	wi, err := newWatchIgnore(dir)
+	if err != nil {
+		return err
+	}
+	watcher, err := newContextWatcher(dir, wi)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for iteration := 1; ; iteration++ {
+		err := build(ctx)
+		if ctx.Err() != nil {
+			// Interrupted: exit the loop without reporting the build as failed.
+			return nil
+		}
+		if err != nil {
+			app.console.Warnf("Error: %v\n", err)
+		}
+		// Ignore the changes made while building.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchSettleDelay):
+		}
+	drain:
+		for {
+			select {
+			case <-watcher.Events:
+			default:
+				break drain
+			}
+		}
+		app.console.Printf("Watching %s for changes (press Ctrl-C to exit)...\n", dir)
+
+		changed, err := waitForChanges(ctx, watcher, wi)
+		if err != nil {
+			return err
+		}
+		if changed == nil {
+			return nil
+		}
+		app.console.PrintRebuild(fmt.Sprintf("#%d %s: %s", iteration+1, target.String(), summarizeChanges(dir, changed)))
+	}
+}
+
+// waitForChanges waits for the watched files not ignored by wi to change, and then for them
+// to stay unchanged for watchDebounce. It returns the changed paths, or nil if the context
+// is done.
+func waitForChanges(ctx context.Context, watcher *fsnotify.Watcher, wi *watchIgnore) ([]string, error) {
+	changed := make(map[string]bool)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case <-debounce:
+			var paths []string
+			for path := range changed {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			return paths, nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil, nil
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !wi.skipDir(event.Name) {
+					err = addWatchDirs(watcher, event.Name, wi)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			if wi.ignored(event.Name) {
+				continue
+			}
+			changed[event.Name] = true
+			debounce = time.After(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "watch build context")
+		}
+	}
+}
+
+// summarizeChanges returns a short description of the changed paths, relative to dir.
+func summarizeChanges(dir string, paths []string) string {
+	var rel []string
+	for _, path := range paths {
+		if r, err := filepath.Rel(dir, path); err == nil {
+			path = r
+		}
+		rel = append(rel, path)
+	}
+	if len(rel) > maxListedChanges {
+		return fmt.Sprintf("%s and %d more", strings.Join(rel[:maxListedChanges], ", "), len(rel)-maxListedChanges)
+	}
+	return strings.Join(rel, ", ")
+}
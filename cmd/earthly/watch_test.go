@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeChanges(t *testing.T) {
+	var tests = []struct {
+		paths    []string
+		expected string
+	}{
+		{[]string{"ctx/Earthfile"}, "Earthfile"},
+		{[]string{"ctx/a.go", "ctx/sub/b.go"}, "a.go, sub/b.go"},
+		{[]string{"ctx/a", "ctx/b", "ctx/c", "ctx/d", "ctx/e"}, "a, b, c and 2 more"},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.expected, summarizeChanges("ctx", tt.paths))
+	}
+}
+
+func TestWatchIgnore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-watch-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	earthIgnore := "node_modules\n*.log\nEarthfile\n"
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, ".earthignore"), []byte(earthIgnore), 0644))
+
+	wi, err := newWatchIgnore(dir)
+	NoError(t, err)
+	var tests = []struct {
+		path    string
+		ignored bool
+	}{
+		{"main.go", false},
+		{"sub/main.go", false},
+		{"Earthfile", false},
+		{"build.log", true},
+		{"node_modules", true},
+		{"node_modules/pkg/index.js", true},
+		{".git/index", true},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.ignored, wi.ignored(filepath.Join(dir, tt.path)), tt.path)
+	}
+	True(t, wi.skipDir(filepath.Join(dir, "node_modules")))
+	True(t, wi.skipDir(filepath.Join(dir, ".git")))
+	False(t, wi.skipDir(filepath.Join(dir, "sub")))
+	False(t, wi.skipDir(dir))
+
+	// With exclusions, ignored directories may have contents which are not ignored.
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, ".earthignore"), []byte("vendor\n!vendor/keep.go\n"), 0644))
+	wi, err = newWatchIgnore(dir)
+	NoError(t, err)
+	False(t, wi.skipDir(filepath.Join(dir, "vendor")))
+	True(t, wi.ignored(filepath.Join(dir, "vendor/other.go")))
+	False(t, wi.ignored(filepath.Join(dir, "vendor/keep.go")))
+}
+
+func TestWaitForChangesIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-watch-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, ".earthignore"), []byte("*.log\nnode_modules\n"), 0644))
+	NoError(t, os.Mkdir(filepath.Join(dir, "node_modules"), 0755))
+
+	wi, err := newWatchIgnore(dir)
+	NoError(t, err)
+	watcher, err := newContextWatcher(dir, wi)
+	NoError(t, err)
+	defer watcher.Close()
+
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "build.log"), []byte("log"), 0644))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "node_modules", "index.js"), []byte("js"), 0644))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	changed, err := waitForChanges(ctx, watcher, wi)
+	NoError(t, err)
+	Equal(t, []string{filepath.Join(dir, "main.go")}, changed)
+}
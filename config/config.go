@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 var (
@@ -26,8 +28,19 @@ type GlobalConfig struct {
 	BuildkitCacheSizeMb     int      `yaml:"cache_size_mb"`
 	BuildkitImage           string   `yaml:"buildkit_image"`
 	DebuggerPort            int      `yaml:"debugger_port"`
+	LintDisable             []string `yaml:"lint_disable"`
 	BuildkitRestartTimeoutS int      `yaml:"buildkit_restart_timeout_s"`
 	BuildkitAdditionalArgs  []string `yaml:"buildkit_additional_args"`
+	VersionAutoDownload     bool     `yaml:"version_auto_download"`
+	ContainerRuntime        string   `yaml:"container_runtime"`
+	RegistryMirrors         []string `yaml:"registry_mirrors"`
+	ShutdownTimeoutS        int      `yaml:"shutdown_timeout_s"`
+	APITimeoutS             int      `yaml:"api_timeout_s"`
+	APIRetries              int      `yaml:"api_retries"`
+	APICACert               string   `yaml:"api_ca_cert"`
+	APIClientCert           string   `yaml:"api_client_cert"`
+	APIClientKey            string   `yaml:"api_client_key"`
+	ConfirmPush             bool     `yaml:"confirm_push"`
 
 	// Obsolete.
 	CachePath string `yaml:"cache_path"`
@@ -38,6 +51,10 @@ type GitConfig struct {
 	// these are used for global config
 	GitURLInsteadOf string `yaml:"url_instead_of"`
 
+	// Proxy is the http(s) proxy to use for git fetches. In the global section it applies
+	// to all hosts; in a site section it applies to that site only.
+	Proxy string `yaml:"proxy"`
+
 	// these are used for git vendors (e.g. github, gitlab)
 	Pattern    string `yaml:"pattern"`
 	Substitute string `yaml:"substitute"`
@@ -46,12 +63,62 @@ type GitConfig struct {
 	User       string `yaml:"user"`
 	Password   string `yaml:"password"`
 	KeyScan    string `yaml:"serverkey"`
+	// KnownHosts is the path of a known_hosts file holding the host keys of the site.
+	KnownHosts string `yaml:"known_hosts"`
+	// TrustOnFirstUse enables scanning and trusting the host key of the site, if it is not
+	// known yet. The scanned key is kept and checked strictly afterwards.
+	TrustOnFirstUse bool `yaml:"trust_on_first_use"`
+}
+
+// SecretsConfig contains secrets-specific config values
+type SecretsConfig struct {
+	// Validate maps secret path prefixes to regular expressions, which the values of the
+	// secrets set under that prefix must match.
+	Validate map[string]string `yaml:"validate"`
+	// DefaultPath is the path prefix (e.g. /my-org/) of the relative secret paths, i.e. those
+	// which do not start with a /.
+	DefaultPath string `yaml:"default_path"`
+}
+
+// ExpandPath returns the absolute form of the given secret path, which is the path itself
+// unless it is relative and a default path is set. It returns false if the path was not
+// expanded.
+func (sc SecretsConfig) ExpandPath(path string) (string, bool) {
+	if strings.HasPrefix(path, "/") || sc.DefaultPath == "" {
+		return path, false
+	}
+	prefix := sc.DefaultPath
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix + path, true
+}
+
+// ValidationPattern returns the validation pattern of the longest prefix of the given
+// secret path, if any.
+func (sc SecretsConfig) ValidationPattern(path string) (string, string, bool) {
+	var prefix string
+	found := false
+	for k := range sc.Validate {
+		if strings.HasPrefix(path, k) && (!found || len(k) > len(prefix)) {
+			prefix = k
+			found = true
+		}
+	}
+	if !found {
+		return "", "", false
+	}
+	return prefix, sc.Validate[prefix], true
 }
 
 // Config contains user's configuration values from ~/earthly/config.yml
 type Config struct {
-	Global GlobalConfig         `yaml:"global"`
-	Git    map[string]GitConfig `yaml:"git"`
+	Global  GlobalConfig         `yaml:"global"`
+	Git     map[string]GitConfig `yaml:"git"`
+	Secrets SecretsConfig        `yaml:"secrets"`
 }
 
 func ensureTransport(s, transport string) (string, error) {
@@ -74,6 +141,9 @@ func ParseConfigFile(yamlData []byte) (*Config, error) {
 			DebuggerPort:            8373,
 			BuildkitRestartTimeoutS: 60,
 			BuildkitAdditionalArgs:  []string{},
+			ShutdownTimeoutS:        30,
+			APITimeoutS:             30,
+			APIRetries:              3,
 		},
 	}
 
@@ -170,3 +240,124 @@ func defaultRunPath() string {
 	}
 	return filepath.Join(homeDir, ".earthly/run")
 }
+
+// IsSet returns whether the given key, in dotted form (e.g. global.disable_analytics), is
+// set within the config data.
+func IsSet(yamlData []byte, key string) (bool, error) {
+	var root yaml.MapSlice
+	err := yaml.Unmarshal(yamlData, &root)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to parse config")
+	}
+	m := root
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		value, found := lookupMapSlice(m, part)
+		if !found {
+			return false, nil
+		}
+		if i == len(parts)-1 {
+			return true, nil
+		}
+		m, found = value.(yaml.MapSlice)
+		if !found {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// Upsert returns the config data with the given key, in dotted form (e.g.
+// global.disable_analytics), set to value. The other values are kept as they are.
+func Upsert(yamlData []byte, key string, value interface{}) ([]byte, error) {
+	out, err := upsertPath(yamlData, strings.Split(key, "."), value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set %s", key)
+	}
+	return out, nil
+}
+
+func upsertPath(yamlData []byte, path []string, value interface{}) ([]byte, error) {
+	// The data is handled as a yaml.v3 node tree, which keeps the comments and the order of
+	// the keys.
+	var doc yamlv3.Node
+	err := yamlv3.Unmarshal(yamlData, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse config")
+	}
+	var buf bytes.Buffer
+	if doc.Kind == 0 {
+		// Empty data, or only comments, which yaml.v3 drops: they are kept as they are.
+		doc.Kind = yamlv3.DocumentNode
+		buf.Write(yamlData)
+		if buf.Len() > 0 && !bytes.HasSuffix(yamlData, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yamlv3.Node{{Kind: yamlv3.MappingNode, Tag: "!!map"}}
+	}
+	var valueNode yamlv3.Node
+	err = valueNode.Encode(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode value")
+	}
+	err = upsertNode(doc.Content[0], "config", path, &valueNode)
+	if err != nil {
+		return nil, err
+	}
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config")
+	}
+	err = enc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config")
+	}
+	return buf.Bytes(), nil
+}
+
+// upsertNode sets the value at the given path within the mapping node m, named name.
+func upsertNode(m *yamlv3.Node, name string, path []string, value *yamlv3.Node) error {
+	switch {
+	case m.Kind == yamlv3.MappingNode:
+	case m.Kind == yamlv3.ScalarNode && m.Tag == "!!null":
+		// A key without value (e.g. "global:").
+		m.Kind = yamlv3.MappingNode
+		m.Tag = "!!map"
+		m.Value = ""
+	default:
+		return fmt.Errorf("%s is not a map", name)
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			old := m.Content[i+1]
+			value.LineComment = old.LineComment
+			m.Content[i+1] = value
+			return nil
+		}
+		return upsertNode(m.Content[i+1], path[0], path[1:], value)
+	}
+	keyNode := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: path[0]}
+	if len(path) == 1 {
+		m.Content = append(m.Content, keyNode, value)
+		return nil
+	}
+	child := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, keyNode, child)
+	return upsertNode(child, path[0], path[1:], value)
+}
+
+func lookupMapSlice(m yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestUpsert(t *testing.T) {
+	var tests = []struct {
+		in       string
+		key      string
+		value    interface{}
+		expected string
+	}{
+		{"", "global.disable_analytics", true, "global:\n  disable_analytics: true\n"},
+		{"global:\n  run_path: /tmp/run\n", "global.disable_analytics", false, "global:\n  run_path: /tmp/run\n  disable_analytics: false\n"},
+		{"global:\n  disable_analytics: false\ngit:\n  github.com:\n    auth: ssh\n", "global.disable_analytics", true, "global:\n  disable_analytics: true\ngit:\n  github.com:\n    auth: ssh\n"},
+		{"# Only comments.\n", "global.disable_analytics", true, "# Only comments.\nglobal:\n  disable_analytics: true\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Upsert([]byte(tt.in), tt.key, tt.value)
+		NoError(t, err)
+		Equal(t, tt.expected, string(out))
+
+		isSet, err := IsSet(out, tt.key)
+		NoError(t, err)
+		True(t, isSet)
+	}
+}
+
+func TestIsSet(t *testing.T) {
+	isSet, err := IsSet([]byte("global:\n  run_path: /tmp/run\n"), "global.disable_analytics")
+	NoError(t, err)
+	False(t, isSet)
+}
+
+func TestSecretsValidationPattern(t *testing.T) {
+	sc := SecretsConfig{Validate: map[string]string{
+		"/user/":         "^.+$",
+		"/user/stripe/":  "^sk_[a-z]+_[0-9a-zA-Z]+$",
+		"/other/github/": "^ghp_[0-9a-zA-Z]{36}$",
+	}}
+	var tests = []struct {
+		path    string
+		prefix  string
+		pattern string
+		ok      bool
+	}{
+		{"/user/stripe/key", "/user/stripe/", "^sk_[a-z]+_[0-9a-zA-Z]+$", true},
+		{"/user/password", "/user/", "^.+$", true},
+		{"/other/github/token", "/other/github/", "^ghp_[0-9a-zA-Z]{36}$", true},
+		{"/other/password", "", "", false},
+	}
+
+	for _, tt := range tests {
+		prefix, pattern, ok := sc.ValidationPattern(tt.path)
+		Equal(t, tt.ok, ok, tt.path)
+		Equal(t, tt.prefix, prefix, tt.path)
+		Equal(t, tt.pattern, pattern, tt.path)
+	}
+}
+
+func TestSecretsExpandPath(t *testing.T) {
+	var tests = []struct {
+		defaultPath string
+		path        string
+		expected    string
+		ok          bool
+	}{
+		{"", "db/password", "db/password", false},
+		{"/acme/", "db/password", "/acme/db/password", true},
+		{"acme/team", "db/password", "/acme/team/db/password", true},
+		{"/acme/", "/other/db/password", "/other/db/password", false},
+	}
+
+	for _, tt := range tests {
+		sc := SecretsConfig{DefaultPath: tt.defaultPath}
+		expanded, ok := sc.ExpandPath(tt.path)
+		Equal(t, tt.ok, ok, tt.path)
+		Equal(t, tt.expected, expanded, tt.path)
+	}
+}
+
+func TestSet(t *testing.T) {
+	var tests = []struct {
+		in       string
+		key      string
+		value    string
+		expected string
+	}{
+		{"", "global.cache_size_mb", "20000", "global:\n  cache_size_mb: 20000\n"},
+		{"global:\n  run_path: /tmp/run\n", "global.disable_analytics", "true", "global:\n  run_path: /tmp/run\n  disable_analytics: true\n"},
+		{"", "global.buildkit_additional_args", "[--cpus, 2]", "global:\n  buildkit_additional_args:\n    - --cpus\n    - \"2\"\n"},
+		{"# Earthly config.\nglobal:\n  # Fast disk.\n  run_path: /tmp/run # tmpfs\n", "global.run_path", "/mnt/run", "# Earthly config.\nglobal:\n  # Fast disk.\n  run_path: /mnt/run # tmpfs\n"},
+		{"global:\ngit:\n  # Mirror.\n  global:\n    url_instead_of: a=b\n", "global.cache_size_mb", "1", "global:\n  cache_size_mb: 1\ngit:\n  # Mirror.\n  global:\n    url_instead_of: a=b\n"},
+		{"", "git.github.com.auth", "ssh", "git:\n  github.com:\n    auth: ssh\n"},
+		{"", "git.global.url_instead_of", "git@example.com:=https://mirror/", "git:\n  global:\n    url_instead_of: git@example.com:=https://mirror/\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Set([]byte(tt.in), tt.key, tt.value)
+		NoError(t, err, tt.key)
+		Equal(t, tt.expected, string(out))
+
+		value, err := Get(out, tt.key)
+		NoError(t, err, tt.key)
+		if tt.key != "global.buildkit_additional_args" {
+			Equal(t, tt.value, value)
+		}
+	}
+}
+
+func TestSetMalformedConfig(t *testing.T) {
+	var tests = []struct {
+		in       string
+		key      string
+		value    string
+		expected string
+	}{
+		{"global:\n  cache_size_mb: lots\n", "global.cache_size_mb", "1000", "global:\n  cache_size_mb: 1000\n"},
+		{"global:\n  cache_size_mb: lots\n", "global.run_path", "/tmp/run", "global:\n  cache_size_mb: lots\n  run_path: /tmp/run\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Set([]byte(tt.in), tt.key, tt.value)
+		NoError(t, err, tt.key)
+		Equal(t, tt.expected, string(out))
+	}
+}
+
+func TestSetInvalid(t *testing.T) {
+	for _, kv := range [][2]string{
+		{"global.unknown", "1"},
+		{"unknown", "1"},
+		{"global", "1"},
+		{"git.github.com", "1"},
+		{"global.cache_size_mb", "lots"},
+		{"global.disable_analytics", "maybe"},
+	} {
+		_, err := Set(nil, kv[0], kv[1])
+		Error(t, err, kv[0])
+	}
+}
+
+func TestGetDefault(t *testing.T) {
+	value, err := Get(nil, "global.debugger_port")
+	NoError(t, err)
+	Equal(t, "8373", value)
+
+	value, err = Get([]byte("git:\n  github.com:\n    auth: ssh\n"), "git.gitlab.com.auth")
+	NoError(t, err)
+	Equal(t, "", value)
+
+	_, err = Get(nil, "global.unknown")
+	Error(t, err)
+}
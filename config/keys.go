@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Get returns the value of the given key, in dotted form (e.g. global.cache_size_mb), within
+// the config data. The default value is returned for the keys which are not set. Sections
+// (e.g. global) are returned as YAML.
+func Get(yamlData []byte, key string) (string, error) {
+	path, _, err := resolveKey(key)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := ParseConfigFile(yamlData)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse config")
+	}
+	v := reflect.ValueOf(*cfg)
+	for _, part := range path {
+		switch v.Kind() {
+		case reflect.Struct:
+			f, _ := fieldByTag(v.Type(), part)
+			v = v.FieldByIndex(f.Index)
+		case reflect.Map:
+			elem := v.MapIndex(reflect.ValueOf(part))
+			if !elem.IsValid() {
+				elem = reflect.Zero(v.Type().Elem())
+			}
+			v = elem
+		}
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return fmt.Sprintf("%v", v.Interface()), nil
+	default:
+		out, err := yaml.Marshal(v.Interface())
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to marshal %s", key)
+		}
+		return strings.TrimSuffix(string(out), "\n"), nil
+	}
+}
+
+// Set returns the config data with the given key, in dotted form (e.g. global.cache_size_mb),
+// set to the given value. The value is parsed according to the type of the key: list values
+// are written in YAML flow form (e.g. [a, b]). Unknown keys and invalid values are rejected.
+// The other values and the comments are kept as they are.
+func Set(yamlData []byte, key string, value string) ([]byte, error) {
+	path, t, err := resolveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var typed interface{}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return nil, errors.Errorf("%s is a section; set one of its keys instead", key)
+	case reflect.String:
+		// Taken as is, as strings such as git URLs are not necessarily valid YAML scalars.
+		typed = value
+	default:
+		ptr := reflect.New(t)
+		err = yaml.UnmarshalStrict([]byte(value), ptr.Interface())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value %q for %s", value, key)
+		}
+		typed = ptr.Elem().Interface()
+	}
+	out, err := upsertPath(yamlData, path, typed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set %s", key)
+	}
+	_, err = ParseConfigFile(out)
+	if err != nil {
+		_, origErr := ParseConfigFile(yamlData)
+		if origErr == nil {
+			return nil, errors.Wrapf(err, "invalid value %q for %s", value, key)
+		}
+		// The config data was already invalid: the other keys may still need fixing.
+	}
+	return out, nil
+}
+
+// resolveKey splits a key, in dotted form, into its path within the config data, and returns
+// the type of its value. The names of the git sites (e.g. github.com in git.github.com.auth)
+// may contain dots.
+func resolveKey(key string) ([]string, reflect.Type, error) {
+	parts := strings.Split(key, ".")
+	t := reflect.TypeOf(Config{})
+	var path []string
+	for len(parts) > 0 {
+		switch t.Kind() {
+		case reflect.Struct:
+			f, found := fieldByTag(t, parts[0])
+			if !found {
+				return nil, nil, errors.Errorf("unknown config key %s", key)
+			}
+			path = append(path, parts[0])
+			t = f.Type
+			parts = parts[1:]
+		case reflect.Map:
+			n := len(parts)
+			if t.Elem().Kind() == reflect.Struct {
+				// The last part is a key of the struct.
+				n--
+			}
+			if n < 1 {
+				return nil, nil, errors.Errorf("unknown config key %s", key)
+			}
+			path = append(path, strings.Join(parts[:n], "."))
+			t = t.Elem()
+			parts = parts[n:]
+		default:
+			return nil, nil, errors.Errorf("unknown config key %s", key)
+		}
+	}
+	return path, t, nil
+}
+
+// fieldByTag returns the field of the struct type which has the given yaml name.
+func fieldByTag(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Split(f.Tag.Get("yaml"), ",")[0] == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -46,6 +46,8 @@ type ConsoleLogger struct {
 	colorMode ColorMode
 	isCached  bool
 	isFailed  bool
+	// quiet suppresses informational output; only warnings and failures are printed.
+	quiet bool
 
 	// The following are shared between instances and are protected by the mutex.
 	mu             *sync.Mutex
@@ -59,9 +61,14 @@ type ConsoleLogger struct {
 
 // Current returns the current console.
 func Current(colorMode ColorMode, prefixPadding int) ConsoleLogger {
+	return New(os.Stdout, os.Stderr, colorMode, prefixPadding)
+}
+
+// New returns a console which prints warnings to errW and everything else to outW.
+func New(outW, errW io.Writer, colorMode ColorMode, prefixPadding int) ConsoleLogger {
 	return ConsoleLogger{
-		outW:           os.Stdout,
-		errW:           os.Stderr,
+		outW:           outW,
+		errW:           errW,
 		colorMode:      colorMode,
 		saltColors:     make(map[string]*color.Color),
 		nextColorIndex: new(int),
@@ -79,6 +86,7 @@ func (cl ConsoleLogger) clone() ConsoleLogger {
 		salt:           cl.salt,
 		isCached:       cl.isCached,
 		isFailed:       cl.isFailed,
+		quiet:          cl.quiet,
 		saltColors:     cl.saltColors,
 		colorMode:      cl.colorMode,
 		nextColorIndex: cl.nextColorIndex,
@@ -129,8 +137,51 @@ func (cl ConsoleLogger) WithFailed(isFailed bool) ConsoleLogger {
 	return ret
 }
 
+// WithErrOutput returns a ConsoleLogger which prints all of its output to the error writer,
+// leaving the output writer free for data.
+func (cl ConsoleLogger) WithErrOutput() ConsoleLogger {
+	ret := cl.clone()
+	ret.outW = cl.errW
+	return ret
+}
+
+// WithColorMode returns a ConsoleLogger with the color mode set accordingly.
+func (cl ConsoleLogger) WithColorMode(colorMode ColorMode) ConsoleLogger {
+	ret := cl.clone()
+	ret.colorMode = colorMode
+	return ret
+}
+
+// WithPrefixPadding returns a ConsoleLogger with the target prefix padding set accordingly.
+func (cl ConsoleLogger) WithPrefixPadding(prefixPadding int) ConsoleLogger {
+	ret := cl.clone()
+	ret.prefixPadding = prefixPadding
+	return ret
+}
+
+// WithTee returns a ConsoleLogger which, in addition to its regular output, writes all of
+// its output to w, without colors.
+func (cl ConsoleLogger) WithTee(w io.Writer) ConsoleLogger {
+	ret := cl.clone()
+	noColorW := &stripColorWriter{w: w}
+	ret.outW = io.MultiWriter(cl.outW, noColorW)
+	ret.errW = io.MultiWriter(cl.errW, noColorW)
+	return ret
+}
+
+// WithQuiet returns a ConsoleLogger with quiet mode set accordingly. In quiet mode,
+// only warnings and failures are printed.
+func (cl ConsoleLogger) WithQuiet(quiet bool) ConsoleLogger {
+	ret := cl.clone()
+	ret.quiet = quiet
+	return ret
+}
+
 // PrintSuccess prints the success message.
 func (cl ConsoleLogger) PrintSuccess(msg string) {
+	if cl.quiet {
+		return
+	}
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 	cl.printBar(successColor, " SUCCESS ", msg)
@@ -144,6 +195,16 @@ func (cl ConsoleLogger) PrintFailure(msg string) {
 	cl.printBar(warnColor, " FAILURE ", msg)
 }
 
+// PrintRebuild prints the separator between consecutive builds.
+func (cl ConsoleLogger) PrintRebuild(msg string) {
+	if cl.quiet {
+		return
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.printBar(metadataModeColor, " REBUILD ", msg)
+}
+
 func (cl ConsoleLogger) printBar(c *color.Color, center, msg string) {
 	if msg != "" {
 		center = fmt.Sprintf("%s[%s] ", center, msg)
@@ -179,6 +240,9 @@ func (cl ConsoleLogger) Warnf(format string, args ...interface{}) {
 
 // Printf prints formatted text to the console.
 func (cl ConsoleLogger) Printf(format string, args ...interface{}) {
+	if cl.quiet {
+		return
+	}
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
 	c := cl.color(noColor)
@@ -282,6 +346,22 @@ func (cl ConsoleLogger) color(c *color.Color) *color.Color {
 	return noColor
 }
 
+var colorRegexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// stripColorWriter is a writer which removes color escape sequences before writing to the
+// underlying writer.
+type stripColorWriter struct {
+	w io.Writer
+}
+
+func (scw *stripColorWriter) Write(p []byte) (int, error) {
+	_, err := scw.w.Write(colorRegexp.ReplaceAll(p, nil))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 var bracketsRegexp = regexp.MustCompile("\\(([^\\]]*)\\)")
 
 func (cl ConsoleLogger) prettyPrefix() string {
@@ -0,0 +1,24 @@
+package conslogging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTee(t *testing.T) {
+	// The colors are disabled globally when the tests do not run in a terminal.
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = false
+
+	var out, errOut, log bytes.Buffer
+	cl := New(&out, &errOut, ForceColor, NoPadding).WithTee(&log)
+	cl.WithPrefix("+build").Printf("hello\n")
+	cl.Warnf("oops\n")
+
+	assert.Contains(t, out.String(), "\x1b[")
+	assert.Contains(t, errOut.String(), "oops")
+	assert.Equal(t, "+build | hello\noops\n", log.String())
+}
@@ -0,0 +1,96 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Recorder records the output of an interactive session in the asciicast v2 format, which
+// can be played back with asciinema. See
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md.
+type Recorder struct {
+	w     io.Writer
+	start time.Time
+	now   func() time.Time
+	// pending holds the bytes of an incomplete UTF-8 sequence, which are recorded together
+	// with the data following them.
+	pending []byte
+	mu      sync.Mutex
+}
+
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// NewRecorder returns a new Recorder writing to w, for a terminal of the given size. The
+// timing of the recorded events is relative to the creation of the recorder.
+func NewRecorder(w io.Writer, width, height int, term string) (*Recorder, error) {
+	return newRecorder(w, width, height, term, time.Now)
+}
+
+func newRecorder(w io.Writer, width, height int, term string, now func() time.Time) (*Recorder, error) {
+	r := &Recorder{
+		w:     w,
+		start: now(),
+		now:   now,
+	}
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+	}
+	if term != "" {
+		header.Env = map[string]string{"TERM": term}
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal asciicast header")
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	if err != nil {
+		return nil, errors.Wrap(err, "write asciicast header")
+	}
+	return r, nil
+}
+
+// Output records data written to the terminal.
+func (r *Recorder) Output(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data = append(r.pending, data...)
+	r.pending = nil
+	// Hold back a trailing incomplete UTF-8 sequence.
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				r.pending = append([]byte{}, data[i:]...)
+				data = data[:i]
+			}
+			break
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	elapsed := r.now().Sub(r.start).Seconds()
+	b, err := json.Marshal([]interface{}{elapsed, "o", string(data)})
+	if err != nil {
+		return errors.Wrap(err, "marshal asciicast event")
+	}
+	_, err = fmt.Fprintf(r.w, "%s\n", b)
+	if err != nil {
+		return errors.Wrap(err, "write asciicast event")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package terminal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	now := start
+	var buf bytes.Buffer
+	r, err := newRecorder(&buf, 80, 24, "xterm", func() time.Time { return now })
+	NoError(t, err)
+
+	now = start.Add(500 * time.Millisecond)
+	NoError(t, r.Output([]byte("$ ls\r\n")))
+	// A multi-byte character split across two writes.
+	now = start.Add(time.Second)
+	NoError(t, r.Output([]byte("caf\xc3")))
+	now = start.Add(1500 * time.Millisecond)
+	NoError(t, r.Output([]byte("\xa9\r\n")))
+
+	Equal(t,
+		`{"version":2,"width":80,"height":24,"timestamp":1600000000,"env":{"TERM":"xterm"}}`+"\n"+
+			`[0.5,"o","$ ls\r\n"]`+"\n"+
+			`[1,"o","caf"]`+"\n"+
+			`[1.5,"o","é\r\n"]`+"\n",
+		buf.String())
+}
@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/earthly/earthly/debugger/common"
 	"github.com/earthly/earthly/logging"
@@ -18,6 +19,45 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// connectTimeout is how long to keep trying to connect to the shell repeater.
+const connectTimeout = 30 * time.Second
+
+// interruptByte is what a terminal sends to the shell on Ctrl-C.
+const interruptByte = 0x03
+
+var (
+	sessionMu sync.Mutex
+	// sessionWriteCh is the channel writing to the connection of the active interactive
+	// session, or nil if there is none.
+	sessionWriteCh chan []byte
+)
+
+// Interrupt forwards an interrupt (Ctrl-C) to the shell of the active interactive session.
+// It returns false if there is no active session.
+func Interrupt() bool {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	if sessionWriteCh == nil {
+		return false
+	}
+	data, err := common.SerializeDataPacket(common.PtyData, []byte{interruptByte})
+	if err != nil {
+		return false
+	}
+	select {
+	case sessionWriteCh <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+func setActiveSession(writeCh chan []byte) {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	sessionWriteCh = writeCh
+}
+
 // Terminal provides a terminal for a user to type commands into
 // and to display the output of the shell.
 // The terminal does not run commands, but rather passes them to the shell
@@ -26,14 +66,28 @@ type Terminal struct {
 	conn net.Conn
 }
 
-func handlePtyData(data []byte) error {
+func handlePtyData(data []byte, rec *Recorder) error {
 	_, err := os.Stdout.Write(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to write data to stdout")
 	}
+	if rec != nil {
+		err = rec.Output(data)
+		if err != nil {
+			return errors.Wrap(err, "failed to record data")
+		}
+	}
 	return nil
 }
 
+func newFileRecorder(f *os.File) (*Recorder, error) {
+	rows, cols, err := pty.Getsize(os.Stdin)
+	if err != nil {
+		rows, cols = 24, 80
+	}
+	return NewRecorder(f, cols, rows, os.Getenv("TERM"))
+}
+
 func getWindowSizePayload() ([]byte, error) {
 	size, err := pty.GetsizeFull(os.Stdin)
 	if err != nil {
@@ -46,13 +100,23 @@ func getWindowSizePayload() ([]byte, error) {
 	return common.SerializeDataPacket(common.WinSizeData, b)
 }
 
-// ConnectTerm presents a terminal to the shell repeater
-func ConnectTerm(ctx context.Context, addr string) error {
+// ConnectTerm presents a terminal to the shell repeater. If recordPath is not empty, the
+// interactive sessions are recorded to that file, in the asciicast v2 format.
+func ConnectTerm(ctx context.Context, addr string, recordPath string) error {
 	log := logging.GetLogger(ctx)
 
-	var d net.Dialer
+	var recordFile *os.File
+	if recordPath != "" {
+		var err error
+		recordFile, err = os.Create(recordPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s", recordPath)
+		}
+		defer recordFile.Close()
+	}
+	var rec *Recorder
 
-	conn, err := d.DialContext(ctx, "tcp", addr)
+	conn, err := dial(ctx, addr)
 	if err != nil {
 		return err
 	}
@@ -67,6 +131,7 @@ func ConnectTerm(ctx context.Context, addr string) error {
 	signal.Notify(sigs, syscall.SIGWINCH)
 
 	writeCh := make(chan []byte, 10)
+	defer setActiveSession(nil)
 
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -86,15 +151,25 @@ func ConnectTerm(ctx context.Context, addr string) error {
 					log.Error(err)
 					break outer
 				}
+				if recordFile != nil && rec == nil {
+					// The recording starts with the first session.
+					rec, err = newFileRecorder(recordFile)
+					if err != nil {
+						log.Error(errors.Wrap(err, "failed to start recording"))
+						break outer
+					}
+				}
 				sigs <- syscall.SIGWINCH
+				setActiveSession(writeCh)
 			case common.EndShellSession:
+				setActiveSession(nil)
 				err := ts.restore()
 				if err != nil {
 					log.Error(err)
 					break outer
 				}
 			case common.PtyData:
-				err := handlePtyData(data)
+				err := handlePtyData(data, rec)
 				if err != nil {
 					log.Error(errors.Wrap(err, "failed to handle pty data"))
 					break outer
@@ -158,7 +233,9 @@ func ConnectTerm(ctx context.Context, addr string) error {
 	}()
 
 	<-ctx.Done()
-	fmt.Fprintf(os.Stderr, "exiting interactive debugger shell\n")
+	if ts.hasStarted() {
+		fmt.Fprintf(os.Stderr, "exiting interactive debugger shell\n")
+	}
 	err = ts.restore()
 	if err != nil {
 		return err
@@ -166,14 +243,41 @@ func ConnectTerm(ctx context.Context, addr string) error {
 	return nil
 }
 
+// dial connects to the shell repeater, retrying until it becomes available or until
+// connectTimeout elapses.
+func dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	var d net.Dialer
+	for {
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrapf(err, "failed to connect to the interactive debugger at %s", addr)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
 type termState struct {
 	oldState *terminal.State
+	started  bool
 	mu       sync.Mutex
 }
 
+func (ts *termState) hasStarted() bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.started
+}
+
 func (ts *termState) makeRaw() error {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
+	ts.started = true
 	if ts.oldState == nil {
 		var err error
 		ts.oldState, err = terminal.MakeRaw(int(os.Stdin.Fd()))
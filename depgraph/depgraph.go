@@ -0,0 +1,214 @@
+package depgraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb"
+	"github.com/pkg/errors"
+)
+
+// Node is a target within the dependency graph.
+type Node struct {
+	// Target is the target, relative to the current directory.
+	Target domain.Target
+	// Deps are the targets this target references directly.
+	Deps []*Node
+	// Cycle is set if this node closes a dependency cycle. Its deps are not expanded.
+	Cycle bool
+	// Dynamic is set if the reference depends on build args and cannot be resolved statically.
+	Dynamic bool
+}
+
+// Graph is the dependency graph of a target.
+type Graph struct {
+	Root *Node
+	// Cycles is a list of the dependency cycles found, each listed as the chain of targets
+	// which leads back to its first element.
+	Cycles [][]domain.Target
+}
+
+type builder struct {
+	earthfileDeps map[string]map[string][]string
+	nodes         map[string]*Node
+	stack         []domain.Target
+	cycles        [][]domain.Target
+}
+
+// Build statically resolves the dependency graph of a target, by parsing the Earthfiles
+// involved. Local targets are followed recursively; remote targets are leaves.
+func Build(target domain.Target) (*Graph, error) {
+	b := &builder{
+		earthfileDeps: make(map[string]map[string][]string),
+		nodes:         make(map[string]*Node),
+	}
+	root, err := b.visit(target)
+	if err != nil {
+		return nil, err
+	}
+	return &Graph{
+		Root:   root,
+		Cycles: b.cycles,
+	}, nil
+}
+
+func (b *builder) visit(target domain.Target) (*Node, error) {
+	key := target.StringCanonical()
+	for i, t := range b.stack {
+		if t.StringCanonical() == key {
+			cycle := append([]domain.Target{}, b.stack[i:]...)
+			b.cycles = append(b.cycles, append(cycle, target))
+			return &Node{Target: target, Cycle: true}, nil
+		}
+	}
+	if node, found := b.nodes[key]; found {
+		return node, nil
+	}
+	node := &Node{Target: target}
+	b.nodes[key] = node
+	if target.IsRemote() {
+		return node, nil
+	}
+	if isDynamic(target) {
+		node.Dynamic = true
+		return node, nil
+	}
+
+	refs, err := b.targetDeps(target)
+	if err != nil {
+		return nil, err
+	}
+	b.stack = append(b.stack, target)
+	defer func() {
+		b.stack = b.stack[:len(b.stack)-1]
+	}()
+	for _, ref := range refs {
+		relTarget, err := domain.ParseTarget(ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse target %s referenced by %s", ref, target.String())
+		}
+		depTarget, err := domain.JoinTargets(target, relTarget)
+		if err != nil {
+			return nil, errors.Wrapf(err, "join targets %s and %s", target.String(), ref)
+		}
+		depNode, err := b.visit(depTarget)
+		if err != nil {
+			return nil, err
+		}
+		node.Deps = append(node.Deps, depNode)
+	}
+	return node, nil
+}
+
+func (b *builder) targetDeps(target domain.Target) ([]string, error) {
+	earthfilePath := filepath.Join(filepath.FromSlash(target.LocalPath), "Earthfile")
+	deps, found := b.earthfileDeps[earthfilePath]
+	if !found {
+		var err error
+		deps, err = earthfile2llb.GetTargetDeps(earthfilePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get deps of %s", earthfilePath)
+		}
+		b.earthfileDeps[earthfilePath] = deps
+	}
+	targetDeps, found := deps[target.Target]
+	if !found {
+		return nil, fmt.Errorf("target %s not defined in %s", target.Target, earthfilePath)
+	}
+	if target.Target == "base" {
+		return targetDeps, nil
+	}
+	// Every target starts with an implicit FROM +base.
+	return append(append([]string{}, deps["base"]...), targetDeps...), nil
+}
+
+func isDynamic(target domain.Target) bool {
+	return strings.Contains(target.LocalPath, "$") || strings.Contains(target.Target, "$")
+}
+
+// PrintTree prints the dependency graph as an indented tree. Targets which have already
+// been expanded earlier in the tree are not expanded again.
+func (g *Graph) PrintTree(w io.Writer) {
+	printed := make(map[*Node]bool)
+	printNode(w, g.Root, 0, printed)
+}
+
+func printNode(w io.Writer, node *Node, depth int, printed map[*Node]bool) {
+	line := strings.Repeat("  ", depth) + node.Target.String()
+	switch {
+	case node.Cycle:
+		line += " (cycle)"
+	case node.Dynamic:
+		line += " (depends on build args)"
+	case node.Target.IsRemote():
+		tag := node.Target.Tag
+		if tag == "" {
+			tag = "<default branch>"
+		}
+		line += fmt.Sprintf(" (remote: %s @ %s)", node.Target.GitURL, tag)
+	case printed[node] && len(node.Deps) > 0:
+		line += " (see above)"
+		fmt.Fprintln(w, line)
+		return
+	}
+	fmt.Fprintln(w, line)
+	printed[node] = true
+	for _, dep := range node.Deps {
+		printNode(w, dep, depth+1, printed)
+	}
+}
+
+// CycleString returns a human readable representation of a dependency cycle.
+func CycleString(cycle []domain.Target) string {
+	parts := make([]string, 0, len(cycle))
+	for _, t := range cycle {
+		parts = append(parts, t.String())
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// WriteDot writes the dependency graph in Graphviz DOT format. Remote targets are drawn
+// as dashed boxes and targets which cannot be resolved statically as dotted ellipses.
+func (g *Graph) WriteDot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph earthly {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+	visited := make(map[*Node]bool)
+	var edges []string
+	var visit func(node *Node)
+	visit = func(node *Node) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+		name := node.Target.StringCanonical()
+		switch {
+		case node.Target.IsRemote():
+			fmt.Fprintf(bw, "\t%q [style=dashed, color=blue];\n", name)
+		case node.Dynamic:
+			fmt.Fprintf(bw, "\t%q [shape=ellipse, style=dotted];\n", name)
+		case node.Cycle:
+			// The node itself is emitted by its first occurrence.
+		default:
+			fmt.Fprintf(bw, "\t%q;\n", name)
+		}
+		for _, dep := range node.Deps {
+			attrs := ""
+			if dep.Cycle {
+				attrs = " [color=red]"
+			}
+			edges = append(edges, fmt.Sprintf("\t%q -> %q%s;", name, dep.Target.StringCanonical(), attrs))
+			visit(dep)
+		}
+	}
+	visit(g.Root)
+	for _, edge := range edges {
+		fmt.Fprintln(bw, edge)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,100 @@
+package depgraph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestBuild(t *testing.T) {
+	var tests = []struct {
+		name      string
+		earthfile string
+		tree      string
+		dot       string
+		cycles    []string
+		err       string
+	}{
+		{
+			name:      "deps",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    BUILD +dep\n    COPY ./lib+lib/out .\n\ndep:\n    FROM +base2\n\nbase2:\n    RUN true\n",
+			tree:      "+build\n  +dep\n    +base2\n  ./lib+lib\n",
+			dot: "digraph earthly {\n\tnode [shape=box];\n\t\"+build\";\n\t\"+dep\";\n\t\"+base2\";\n\t\"./lib+lib\";\n" +
+				"\t\"+build\" -> \"+dep\";\n\t\"+dep\" -> \"+base2\";\n\t\"+build\" -> \"./lib+lib\";\n}\n",
+		},
+		{
+			name:      "shared dep",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    BUILD +a\n    BUILD +b\n\na:\n    BUILD +b\n\nb:\n    BUILD +c\n\nc:\n    RUN true\n",
+			tree:      "+build\n  +a\n    +b\n      +c\n  +b (see above)\n",
+			dot: "digraph earthly {\n\tnode [shape=box];\n\t\"+build\";\n\t\"+a\";\n\t\"+b\";\n\t\"+c\";\n" +
+				"\t\"+build\" -> \"+a\";\n\t\"+a\" -> \"+b\";\n\t\"+b\" -> \"+c\";\n\t\"+build\" -> \"+b\";\n}\n",
+		},
+		{
+			name:      "remote and dynamic",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    BUILD +$TARGET\n    BUILD github.com/earthly/hello-world:main+hello\n",
+			tree:      "+build\n  +$TARGET (depends on build args)\n  github.com/earthly/hello-world:main+hello (remote: github.com/earthly/hello-world @ main)\n",
+			dot: "digraph earthly {\n\tnode [shape=box];\n\t\"+build\";\n\t\"+$TARGET\" [shape=ellipse, style=dotted];\n" +
+				"\t\"github.com/earthly/hello-world:main+hello\" [style=dashed, color=blue];\n" +
+				"\t\"+build\" -> \"+$TARGET\";\n\t\"+build\" -> \"github.com/earthly/hello-world:main+hello\";\n}\n",
+		},
+		{
+			name:      "cycle",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    BUILD +a\n\na:\n    BUILD +b\n\nb:\n    BUILD +a\n",
+			tree:      "+build\n  +a\n    +b\n      +a (cycle)\n",
+			dot: "digraph earthly {\n\tnode [shape=box];\n\t\"+build\";\n\t\"+a\";\n\t\"+b\";\n" +
+				"\t\"+build\" -> \"+a\";\n\t\"+a\" -> \"+b\";\n\t\"+b\" -> \"+a\" [color=red];\n}\n",
+			cycles: []string{"+a -> +b -> +a"},
+		},
+		{
+			name:      "missing target",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    BUILD +missing\n",
+			err:       "target missing not defined",
+		},
+		{
+			name:      "syntax error",
+			earthfile: "FROM alpine:3.13\n\nbuild:\n    RUN true\n  bad indent\n",
+			err:       "syntax error",
+		},
+	}
+	dir, err := ioutil.TempDir("", "earthly-depgraph-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0755))
+	err = ioutil.WriteFile(filepath.Join(dir, "lib", "Earthfile"), []byte("lib:\n    FROM alpine:3.13\n    SAVE ARTIFACT /etc/os-release out\n"), 0644)
+	NoError(t, err)
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NoError(t, ioutil.WriteFile("Earthfile", []byte(tt.earthfile), 0644))
+			g, err := Build(domain.Target{LocalPath: ".", Target: "build"})
+			if tt.err != "" {
+				Error(t, err)
+				Contains(t, err.Error(), tt.err)
+				return
+			}
+			NoError(t, err)
+
+			var tree bytes.Buffer
+			g.PrintTree(&tree)
+			Equal(t, tt.tree, tree.String())
+
+			var dot bytes.Buffer
+			NoError(t, g.WriteDot(&dot))
+			Equal(t, tt.dot, dot.String())
+
+			var cycles []string
+			for _, cycle := range g.Cycles {
+				cycles = append(cycles, CycleString(cycle))
+			}
+			Equal(t, tt.cycles, cycles)
+		})
+	}
+}
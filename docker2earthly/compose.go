@@ -0,0 +1,215 @@
+package docker2earthly
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/fileutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	Image string        `yaml:"image"`
+	Build *composeBuild `yaml:"build"`
+}
+
+type composeBuild struct {
+	Context    string      `yaml:"context"`
+	Dockerfile string      `yaml:"dockerfile"`
+	Target     string      `yaml:"target"`
+	Args       composeArgs `yaml:"args"`
+}
+
+// UnmarshalYAML supports both the short (build: <context>) and the long form of build.
+func (b *composeBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var context string
+	if err := unmarshal(&context); err == nil {
+		b.Context = context
+		return nil
+	}
+	type plain composeBuild
+	return unmarshal((*plain)(b))
+}
+
+// composeArgs are the build args of a service. A nil value means that the value is taken
+// from the environment of docker-compose.
+type composeArgs map[string]*string
+
+// UnmarshalYAML supports both the list (- KEY=VALUE) and the map (KEY: VALUE) form of args.
+func (a *composeArgs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*a = composeArgs{}
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, kv := range list {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) == 2 {
+				(*a)[parts[0]] = &parts[1]
+			} else {
+				(*a)[parts[0]] = nil
+			}
+		}
+		return nil
+	}
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if v == nil {
+			(*a)[k] = nil
+			continue
+		}
+		s := fmt.Sprint(v)
+		(*a)[k] = &s
+	}
+	return nil
+}
+
+var invalidTargetChars = regexp.MustCompile(`[^a-zA-Z0-9.-]`)
+
+// Compose2Earthly converts a docker-compose file into an Earthfile, with a target per service
+// which has a build section, and an +all target which builds all of them. The services which
+// only reference an image are skipped. An error is returned if the Earthfile already exists.
+func Compose2Earthly(composePath, earthfilePath string) error {
+	if earthfilePath != "-" && fileutil.FileExists(earthfilePath) {
+		return fmt.Errorf("Earthfile already exists; please delete it if you wish to continue")
+	}
+	data, err := ioutil.ReadFile(composePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", composePath)
+	}
+	var cf composeFile
+	err = yaml.Unmarshal(data, &cf)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse compose file %q", composePath)
+	}
+	if len(cf.Services) == 0 {
+		return fmt.Errorf("no services found in %q; only compose files with a services section are supported", composePath)
+	}
+
+	composeDir, err := filepath.Abs(filepath.Dir(composePath))
+	if err != nil {
+		return errors.Wrap(err, "abs compose dir")
+	}
+	earthfileDir := "."
+	if earthfilePath != "-" {
+		earthfileDir = filepath.Dir(earthfilePath)
+	}
+	earthfileDir, err = filepath.Abs(earthfileDir)
+	if err != nil {
+		return errors.Wrap(err, "abs earthfile dir")
+	}
+	project := invalidTargetChars.ReplaceAllString(strings.ToLower(filepath.Base(composeDir)), "")
+
+	var names []string
+	for name := range cf.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# This Earthfile was generated using compose2earthly\n")
+	fmt.Fprintf(&out, "# the conversion is done on a best-effort basis\n")
+	fmt.Fprintf(&out, "# and might not follow best practices, please\n")
+	fmt.Fprintf(&out, "# visit http://docs.earthly.dev for Earthfile guides\n")
+	var targets []string
+	var notes []string
+	for _, name := range names {
+		svc := cf.Services[name]
+		if svc.Build == nil {
+			notes = append(notes, fmt.Sprintf("service %s has no build section (image %s); skipped", name, svc.Image))
+			continue
+		}
+		context := svc.Build.Context
+		if context == "" {
+			context = "."
+		}
+		if strings.Contains(context, "://") || strings.HasPrefix(context, "git@") {
+			notes = append(notes, fmt.Sprintf("service %s has a remote build context (%s); skipped", name, context))
+			continue
+		}
+		if !filepath.IsAbs(context) {
+			context = filepath.Join(composeDir, context)
+		}
+		rel, err := filepath.Rel(earthfileDir, context)
+		if err != nil {
+			return errors.Wrapf(err, "build context of service %s", name)
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && !strings.HasPrefix(rel, "../") {
+			rel = "./" + rel
+		}
+
+		args := []string{"FROM DOCKERFILE"}
+		if svc.Build.Dockerfile != "" {
+			args = append(args, "-f", svc.Build.Dockerfile)
+		}
+		if svc.Build.Target != "" {
+			args = append(args, "--target", svc.Build.Target)
+		}
+		var argNames []string
+		for k := range svc.Build.Args {
+			argNames = append(argNames, k)
+		}
+		sort.Strings(argNames)
+		for _, k := range argNames {
+			v := svc.Build.Args[k]
+			if v == nil {
+				notes = append(notes, fmt.Sprintf("service %s: build arg %s takes its value from the environment; it is not passed on", name, k))
+				continue
+			}
+			args = append(args, fmt.Sprintf("--build-arg %s=%s", k, quoteIfNeeded(*v)))
+		}
+		args = append(args, rel)
+
+		image := svc.Image
+		if image == "" {
+			image = fmt.Sprintf("%s_%s:latest", project, name)
+		}
+		target := invalidTargetChars.ReplaceAllString(name, "-")
+		targets = append(targets, target)
+		fmt.Fprintf(&out, "\n%s:\n", target)
+		fmt.Fprintf(&out, "    %s\n", strings.Join(args, " "))
+		fmt.Fprintf(&out, "    SAVE IMAGE %s\n", image)
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no services with a build section found in %q", composePath)
+	}
+	fmt.Fprintf(&out, "\nall:\n")
+	for _, target := range targets {
+		fmt.Fprintf(&out, "    BUILD +%s\n", target)
+	}
+
+	if earthfilePath == "-" {
+		_, err = os.Stdout.Write(out.Bytes())
+	} else {
+		err = ioutil.WriteFile(earthfilePath, out.Bytes(), 0644)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write Earthfile")
+	}
+	for _, note := range notes {
+		fmt.Fprintf(os.Stderr, "Note: %s\n", note)
+	}
+	fmt.Fprintf(os.Stderr, "An Earthfile has been generated; to build all the services use: earthly +all\n")
+	return nil
+}
+
+func quoteIfNeeded(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"'") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/earthly/earthly/fileutil"
@@ -20,9 +21,130 @@ func getArtifactName(s string) string {
 	return split[n-1]
 }
 
+// conversionIssue is a Dockerfile instruction which could not be converted exactly.
+type conversionIssue struct {
+	line        int
+	instruction string
+	note        string
+}
+
+func (ci conversionIssue) String() string {
+	if ci.line == 0 {
+		return fmt.Sprintf("%s: %s", ci.instruction, ci.note)
+	}
+	return fmt.Sprintf("line %d: %s: %s", ci.line, ci.instruction, ci.note)
+}
+
+func newConversionIssue(cmd instructions.Command, note string) conversionIssue {
+	ci := conversionIssue{
+		instruction: fmt.Sprintf("%v", cmd),
+		note:        note,
+	}
+	if l, ok := cmd.(interface{ Location() []parser.Range }); ok && len(l.Location()) > 0 {
+		ci.line = l.Location()[0].Start.Line
+	}
+	return ci
+}
+
+func formatIssues(issues []conversionIssue) string {
+	var lines []string
+	for _, ci := range issues {
+		lines = append(lines, fmt.Sprintf("  %s", ci))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// argLines returns the Earthfile ARG declarations of a Dockerfile ARG instruction. The
+// declarations without a default value of global args (the args declared before the first
+// FROM) are dropped, as global args are in scope in all the targets of an Earthfile.
+func argLines(c *instructions.ArgCommand, globalArgs map[string]bool) []string {
+	var lines []string
+	for _, kv := range c.Args {
+		if kv.Value == nil {
+			if !globalArgs[kv.Key] {
+				lines = append(lines, fmt.Sprintf("ARG %s", kv.Key))
+			}
+			continue
+		}
+		value := *kv.Value
+		if value == "" || strings.ContainsAny(value, " \t\"'") {
+			value = strconv.Quote(value)
+		}
+		lines = append(lines, fmt.Sprintf("ARG %s=%s", kv.Key, value))
+	}
+	return lines
+}
+
+// stageIndex returns the index of the stage referenced by name (or by index) from the stage
+// at index i.
+func stageIndex(stages []instructions.Stage, i int, name string) (int, bool) {
+	for j := 0; j < i; j++ {
+		if stages[j].Name != "" && stages[j].Name == strings.ToLower(name) {
+			return j, true
+		}
+	}
+	j, err := strconv.Atoi(name)
+	if err == nil && j >= 0 && j < i {
+		return j, true
+	}
+	return 0, false
+}
+
+// stageDeps returns the indices of the stages which the stage at index i depends on, via
+// FROM <stage> or COPY --from=<stage>.
+func stageDeps(stages []instructions.Stage, i int) []int {
+	var deps []int
+	if j, ok := stageIndex(stages, i, stages[i].BaseName); ok {
+		deps = append(deps, j)
+	}
+	for _, cmd := range stages[i].Commands {
+		if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+			if j, ok := stageIndex(stages, i, c.From); ok {
+				deps = append(deps, j)
+			}
+		}
+	}
+	return deps
+}
+
+// selectStages returns the stages needed to build the stage with the given name (or index),
+// which is the last stage if the name is empty, along with the index of that stage.
+func selectStages(stages []instructions.Stage, name string) (map[int]bool, int, error) {
+	target := len(stages) - 1
+	if name != "" {
+		var ok bool
+		target, ok = stageIndex(stages, len(stages), name)
+		if !ok {
+			var available []string
+			for j, stage := range stages {
+				if stage.Name != "" {
+					available = append(available, stage.Name)
+				} else {
+					available = append(available, strconv.Itoa(j))
+				}
+			}
+			return nil, 0, fmt.Errorf("stage %q not found in the Dockerfile; available stages: %s", name, strings.Join(available, ", "))
+		}
+	}
+	needed := map[int]bool{}
+	queue := []int{target}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if needed[i] {
+			continue
+		}
+		needed[i] = true
+		queue = append(queue, stageDeps(stages, i)...)
+	}
+	return needed, target, nil
+}
+
 // Docker2Earthly converts an existing Dockerfile in the current directory and writes out an Earthfile in the current directory
-// and error is returned if an Earthfile already exists.
-func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
+// and error is returned if an Earthfile already exists. If stageTarget is not empty, only the stage with that name (or index)
+// and the stages it depends on are converted. The instructions which are approximated or dropped are reported on stderr,
+// or cause an error if strict is set.
+func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag, stageTarget string, strict bool) error {
 	if fileutil.FileExists(EarthfilePath) {
 		return fmt.Errorf("Earthfile already exists; please delete it if you wish to continue")
 	}
@@ -53,12 +175,34 @@ func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
 		return errors.Wrapf(err, "failed to parse Dockerfile located at %q", dockerfilePath)
 	}
 
-	stages, _, err := instructions.Parse(dockerfile.AST)
+	stages, metaArgs, err := instructions.Parse(dockerfile.AST)
 	if err != nil {
 		return errors.Wrapf(err, "failed to parse Dockerfile located at %q", dockerfilePath)
 	}
+	if len(stages) == 0 {
+		return fmt.Errorf("no stages found in the Dockerfile located at %q", dockerfilePath)
+	}
+	needed, target, err := selectStages(stages, stageTarget)
+	if err != nil {
+		return err
+	}
+
+	// The args declared before the first FROM are global args, which are declared in the
+	// base target of the Earthfile.
+	globalArgs := map[string]bool{}
+	if len(metaArgs) > 0 {
+		targets[0] = append(targets[0], "")
+		for i := range metaArgs {
+			targets[0] = append(targets[0], argLines(&metaArgs[i], nil)...)
+			for _, kv := range metaArgs[i].Args {
+				globalArgs[kv.Key] = true
+			}
+		}
+		targets[0] = append(targets[0], "")
+	}
 
 	names := map[string]int{}
+	var issues []conversionIssue
 
 	for i, stage := range stages {
 		targets = append(targets, []string{
@@ -71,6 +215,22 @@ func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
 		}
 
 		for _, cmd := range stage.Commands {
+			switch c := cmd.(type) {
+			case *instructions.ArgCommand:
+				targets[i+1] = append(targets[i+1], argLines(c, globalArgs)...)
+				continue
+			case *instructions.OnbuildCommand, *instructions.StopSignalCommand, *instructions.ShellCommand:
+				if needed[i] {
+					issues = append(issues, newConversionIssue(cmd, "dropped, as it is not supported in Earthfiles"))
+				}
+				continue
+			case *instructions.MaintainerCommand:
+				if needed[i] {
+					issues = append(issues, newConversionIssue(cmd, "approximated as LABEL maintainer"))
+				}
+				targets[i+1] = append(targets[i+1], fmt.Sprintf("LABEL maintainer=%s", strconv.Quote(c.Maintainer)))
+				continue
+			}
 			l := fmt.Sprintf("%v", cmd)
 			if strings.HasPrefix(l, "COPY ") && strings.Contains(l, "--from") {
 				parts := strings.Split(l, " ")
@@ -88,12 +248,21 @@ func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
 				targets[n+1] = append(targets[n+1], fmt.Sprintf("SAVE ARTIFACT %s %s\n", parts[2], artifactName))
 			}
 			if strings.HasPrefix(l, "ADD ") {
-				return fmt.Errorf("earthly does not support ADD, please convert to COPY instead")
+				if strings.Contains(l, "://") {
+					return fmt.Errorf("earthly does not support ADD of remote URLs (%s), please download the file via RUN instead", l)
+				}
+				if needed[i] {
+					issues = append(issues, newConversionIssue(cmd, "approximated as COPY; local archives are not extracted"))
+				}
+				l = "COPY " + strings.TrimPrefix(l, "ADD ")
 			}
 			targets[i+1] = append(targets[i+1], l)
 		}
 	}
-	i := len(targets) - 1
+	if strict && len(issues) > 0 {
+		return fmt.Errorf("the following instructions cannot be converted exactly (strict mode):\n%s", formatIssues(issues))
+	}
+	i := target + 1
 	targets[i] = append(targets[i], fmt.Sprintf("SAVE IMAGE %s", imageTag))
 
 	var out io.Writer
@@ -113,6 +282,9 @@ func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
 	fmt.Fprintf(out, "\n")
 
 	for i, lines := range targets {
+		if i > 0 && !needed[i-1] {
+			continue
+		}
 		for j, l := range lines {
 			if i == 0 {
 				fmt.Fprintf(out, "%s\n", l)
@@ -127,6 +299,9 @@ func Docker2Earthly(dockerfilePath, EarthfilePath, imageTag string) error {
 
 	fmt.Fprintf(out, "\nbuild:\n    BUILD +subbuild%d\n", i)
 
+	if len(issues) > 0 {
+		fmt.Fprintf(os.Stderr, "The following instructions were approximated or dropped; please review the generated Earthfile:\n%s\n", formatIssues(issues))
+	}
 	fmt.Fprintf(os.Stderr, "An Earthfile has been generated; to run it use: earthly +build; then run with docker run -ti %s\n", imageTag)
 	return nil
 }
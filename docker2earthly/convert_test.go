@@ -0,0 +1,167 @@
+package docker2earthly
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func convert(t *testing.T, dockerfile, stageTarget string, strict bool) (string, error) {
+	dir, err := ioutil.TempDir("", "docker2earthly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	earthfile := filepath.Join(dir, "Earthfile")
+	err = Docker2Earthly(filepath.Join("testdata", dockerfile), earthfile, "test:latest", stageTarget, strict)
+	if err != nil {
+		return "", err
+	}
+	out, err := ioutil.ReadFile(earthfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), nil
+}
+
+// targetLines returns the (trimmed, non-empty) lines of the given target of an Earthfile.
+func targetLines(earthfile, target string) []string {
+	var lines []string
+	in := false
+	for _, l := range strings.Split(earthfile, "\n") {
+		if strings.HasSuffix(l, ":") && !strings.HasPrefix(l, " ") {
+			in = l == target+":"
+			continue
+		}
+		if in && strings.TrimSpace(l) != "" {
+			lines = append(lines, strings.TrimSpace(l))
+		}
+	}
+	return lines
+}
+
+func TestDocker2EarthlyArgs(t *testing.T) {
+	out, err := convert(t, "args.Dockerfile", "", false)
+	NoError(t, err)
+
+	// Global args are declared in the base target, before the first target.
+	base := out[:strings.Index(out, "subbuild1:")]
+	Contains(t, base, "\nARG ALPINE_VERSION=3.13\n")
+	Contains(t, base, "\nARG GREETING\n")
+
+	// Redeclarations of global args are dropped, as they are in scope anyway; stage args are kept.
+	Equal(t, []string{
+		"FROM alpine:${ALPINE_VERSION}",
+		"ARG NAME=world",
+		`RUN echo "$GREETING $NAME" > /greeting.txt`,
+		"SAVE ARTIFACT /greeting.txt greeting.txt",
+	}, targetLines(out, "subbuild1"))
+	Equal(t, []string{
+		"FROM alpine:${ALPINE_VERSION}",
+		"ARG VERBOSE=1",
+		"ARG QUIET",
+		"COPY +subbuild1/greeting.txt /greeting.txt",
+		`CMD ["cat", "/greeting.txt"]`,
+		"SAVE IMAGE test:latest",
+	}, targetLines(out, "subbuild2"))
+}
+
+func TestDocker2EarthlyStageTarget(t *testing.T) {
+	out, err := convert(t, "multistage.Dockerfile", "build", false)
+	NoError(t, err)
+	Contains(t, out, "subbuild1:")
+	Contains(t, out, "subbuild2:")
+	NotContains(t, out, "subbuild3:")
+	NotContains(t, out, "subbuild4:")
+	Contains(t, targetLines(out, "subbuild2"), "SAVE IMAGE test:latest")
+	Equal(t, []string{"BUILD +subbuild2"}, targetLines(out, "build"))
+
+	out, err = convert(t, "multistage.Dockerfile", "", false)
+	NoError(t, err)
+	NotContains(t, out, "subbuild3:")
+	Equal(t, []string{"BUILD +subbuild4"}, targetLines(out, "build"))
+
+	_, err = convert(t, "multistage.Dockerfile", "test", false)
+	Error(t, err)
+	Contains(t, err.Error(), "available stages: deps, build, lint, 3")
+}
+
+func TestDocker2EarthlyApproximations(t *testing.T) {
+	out, err := convert(t, "unsupported.Dockerfile", "", false)
+	NoError(t, err)
+	Equal(t, []string{
+		"FROM alpine:3.13",
+		`LABEL maintainer="someone@example.com"`,
+		"COPY app.tar.gz /app/",
+		"RUN echo hello",
+		`CMD ["/app/run"]`,
+		"SAVE IMAGE test:latest",
+	}, targetLines(out, "subbuild1"))
+
+	_, err = convert(t, "unsupported.Dockerfile", "", true)
+	Error(t, err)
+	for _, expected := range []string{
+		"line 2: MAINTAINER",
+		"line 3: ADD app.tar.gz /app/",
+		"line 4: SHELL",
+		"line 6: STOPSIGNAL",
+		"line 7: ONBUILD",
+	} {
+		Contains(t, err.Error(), expected)
+	}
+}
+
+func TestCompose2Earthly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compose2earthly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	project := filepath.Join(dir, "shop")
+	err = os.Mkdir(project, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "compose", "docker-compose.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	composePath := filepath.Join(project, "docker-compose.yml")
+	err = ioutil.WriteFile(composePath, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	earthfile := filepath.Join(project, "Earthfile")
+	err = Compose2Earthly(composePath, earthfile)
+	NoError(t, err)
+	b, err := ioutil.ReadFile(earthfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+
+	Equal(t, []string{
+		"FROM DOCKERFILE ./web",
+		"SAVE IMAGE shop_web:latest",
+	}, targetLines(out, "web"))
+	// Args without a value are taken from the environment by docker-compose, and are not passed on.
+	Equal(t, []string{
+		`FROM DOCKERFILE -f Dockerfile.api --target release --build-arg GO_VERSION=1.15 --build-arg GREETING="hello world" ./backend`,
+		"SAVE IMAGE example/api:dev",
+	}, targetLines(out, "api"))
+	Equal(t, []string{
+		"FROM DOCKERFILE --build-arg CONCURRENCY=4 --build-arg QUEUE=jobs .",
+		"SAVE IMAGE shop_worker_1:latest",
+	}, targetLines(out, "worker-1"))
+	// Services which only reference an image are skipped.
+	NotContains(t, out, "db:")
+	Equal(t, []string{"BUILD +api", "BUILD +web", "BUILD +worker-1"}, targetLines(out, "all"))
+
+	// An existing Earthfile is not overwritten.
+	err = Compose2Earthly(composePath, earthfile)
+	Error(t, err)
+}
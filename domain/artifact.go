@@ -37,7 +37,7 @@ func ParseArtifact(artifactName string) (Artifact, error) {
 	if len(partsSlash) != 2 {
 		return Artifact{}, errors.Errorf("invalid artifact name %s", artifactName)
 	}
-	earthTargetName := fmt.Sprintf("%s+%s", escapePlus(parts[0]), partsSlash[0])
+	earthTargetName := fmt.Sprintf("%s+%s", escapePlus(parts[0]), escapePlus(partsSlash[0]))
 	target, err := ParseTarget(earthTargetName)
 	if err != nil {
 		return Artifact{}, errors.Wrapf(err, "invalid artifact name %s", artifactName)
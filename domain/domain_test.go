@@ -93,3 +93,134 @@ func TestArtifactToString(t *testing.T) {
 		})
 	}
 }
+
+var joinTargetTests = []struct {
+	parent Target
+	child  Target
+	out    Target
+}{
+	// Local parent, internal child.
+	{
+		Target{Target: "parent", LocalPath: "./a/dir"},
+		Target{Target: "child", LocalPath: "."},
+		Target{Target: "child", LocalPath: "./a/dir"},
+	},
+	// Local parent, external child.
+	{
+		Target{Target: "parent", LocalPath: "./a/dir"},
+		Target{Target: "child", LocalPath: "../b/dir"},
+		Target{Target: "child", LocalPath: "./a/b/dir"},
+	},
+	{
+		Target{Target: "parent", LocalPath: "./a/dir"},
+		Target{Target: "child", LocalPath: "/abs/dir"},
+		Target{Target: "child", LocalPath: "/abs/dir"},
+	},
+	// Local parent, tagged local child: the tag is dropped, as local targets have none.
+	{
+		Target{Target: "parent", LocalPath: "./a/dir"},
+		Target{Target: "child", LocalPath: "../b/dir", Tag: "v1"},
+		Target{Target: "child", LocalPath: "./a/b/dir"},
+	},
+	{
+		Target{Target: "parent", LocalPath: "./a/dir"},
+		Target{Target: "child", LocalPath: ".", Tag: "v1"},
+		Target{Target: "child", LocalPath: "./a/dir"},
+	},
+	// Remote parent, internal child.
+	{
+		Target{Target: "parent", GitURL: "github.com/foo/bar", Tag: "v1"},
+		Target{Target: "child", LocalPath: "."},
+		Target{Target: "child", GitURL: "github.com/foo/bar", Tag: "v1"},
+	},
+	// Remote parent, external child.
+	{
+		Target{Target: "parent", GitURL: "github.com/foo/bar/sub", Tag: "v1"},
+		Target{Target: "child", LocalPath: "./other"},
+		Target{Target: "child", GitURL: "github.com/foo/bar/sub/other", Tag: "v1"},
+	},
+	{
+		Target{Target: "parent", GitURL: "github.com/foo/bar/sub"},
+		Target{Target: "child", LocalPath: "./other"},
+		Target{Target: "child", GitURL: "github.com/foo/bar/sub/other"},
+	},
+	// Remote child keeps its own tag, regardless of parent.
+	{
+		Target{Target: "parent", LocalPath: "."},
+		Target{Target: "child", GitURL: "github.com/baz/qux", Tag: "v2"},
+		Target{Target: "child", GitURL: "github.com/baz/qux", Tag: "v2"},
+	},
+	{
+		Target{Target: "parent", GitURL: "github.com/foo/bar", Tag: "v1"},
+		Target{Target: "child", GitURL: "github.com/baz/qux"},
+		Target{Target: "child", GitURL: "github.com/baz/qux"},
+	},
+}
+
+func TestJoinTargets(t *testing.T) {
+	for _, tt := range joinTargetTests {
+		t.Run(tt.parent.String()+" "+tt.child.String(), func(t *testing.T) {
+			out, err := JoinTargets(tt.parent, tt.child)
+			NoError(t, err, "join targets failed")
+			Equal(t, tt.out, out)
+		})
+	}
+}
+
+func TestJoinTargetsRemoteAbsolutePath(t *testing.T) {
+	_, err := JoinTargets(
+		Target{Target: "parent", GitURL: "github.com/foo/bar", Tag: "v1"},
+		Target{Target: "child", LocalPath: "/abs/dir"})
+	Error(t, err)
+}
+
+var roundTripTargets = []Target{
+	{Target: "target", LocalPath: "."},
+	{Target: "target-with-+", LocalPath: "."},
+	{Target: "target", LocalPath: "./a/local/dir"},
+	{Target: "target", LocalPath: "../rel/local/dir"},
+	{Target: "target", LocalPath: "/abs/local/dir"},
+	{Target: "target", LocalPath: "./dir-with-+-in-it"},
+	{Target: "target-with-+", LocalPath: "./a/local/dir"},
+	{Target: "target", GitURL: "github.com/foo/bar"},
+	{Target: "target", GitURL: "github.com/foo/bar", Tag: "tag"},
+	{Target: "target", GitURL: "github.com/foo/bar", Tag: "tag/with/slash"},
+	{Target: "target", GitURL: "github.com/foo/bar/sub/dir"},
+	{Target: "target", GitURL: "github.com/foo/bar/sub/dir", Tag: "v1.2.3"},
+	{Target: "target-with-+", GitURL: "github.com/foo/bar", Tag: "tag-with-+"},
+}
+
+func assertTargetRoundTrip(t *testing.T, target Target) {
+	out, err := ParseTarget(target.String())
+	NoError(t, err, "parse target failed")
+	Equal(t, target, out)
+	if target.IsRemote() {
+		out, err = ParseTarget(target.StringCanonical())
+		NoError(t, err, "parse canonical target failed")
+		Equal(t, target, out)
+	}
+}
+
+func TestArtifactRoundTrip(t *testing.T) {
+	for _, target := range roundTripTargets {
+		artifact := Artifact{Target: target, Artifact: "/some/artifact"}
+		t.Run(artifact.String(), func(t *testing.T) {
+			out, err := ParseArtifact(artifact.String())
+			NoError(t, err, "parse artifact failed")
+			Equal(t, artifact, out)
+		})
+	}
+}
+
+func TestTargetRoundTrip(t *testing.T) {
+	for _, target := range roundTripTargets {
+		t.Run(target.String(), func(t *testing.T) {
+			assertTargetRoundTrip(t, target)
+		})
+	}
+	for _, tt := range targetTests {
+		t.Run(tt.in, func(t *testing.T) {
+			assertTargetRoundTrip(t, tt.out)
+		})
+	}
+}
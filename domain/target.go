@@ -48,7 +48,7 @@ func (et Target) DebugString() string {
 // String returns a string representation of the Target.
 func (et Target) String() string {
 	if et.IsLocalExternal() {
-		return fmt.Sprintf("%s+%s", escapePlus(et.LocalPath), et.Target)
+		return fmt.Sprintf("%s+%s", escapePlus(et.LocalPath), escapePlus(et.Target))
 	}
 	if et.IsRemote() {
 		s := escapePlus(et.GitURL)
@@ -59,7 +59,7 @@ func (et Target) String() string {
 		return s
 	}
 	// Local internal.
-	return fmt.Sprintf("+%s", et.Target)
+	return fmt.Sprintf("+%s", escapePlus(et.Target))
 }
 
 // StringCanonical returns a string representation of the Target, in canonical form.
@@ -158,6 +158,10 @@ func JoinTargets(target1 Target, target2 Target) (Target, error) {
 			}
 		}
 	} else {
+		if !ret.IsRemote() {
+			// Tags are only meaningful for remote targets.
+			ret.Tag = ""
+		}
 		if ret.IsLocalExternal() {
 			if path.IsAbs(ret.LocalPath) {
 				ret.LocalPath = path.Clean(ret.LocalPath)
@@ -19,5 +19,18 @@ func NewReturnErrorListener() *ReturnErrorListener {
 
 // SyntaxError implements ErrorListener SyntaxError.
 func (rel *ReturnErrorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
-	rel.Errs = append(rel.Errs, fmt.Errorf("syntax error: line %d:%d %s", line, column, msg))
+	rel.Errs = append(rel.Errs, &SyntaxError{Line: line, Column: column, Msg: msg})
+}
+
+// SyntaxError is a syntax error, at a position within the source.
+type SyntaxError struct {
+	// Line is the line of the error, starting at 1.
+	Line int
+	// Column is the column of the error, starting at 0.
+	Column int
+	Msg    string
+}
+
+func (se *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error: line %d:%d %s", se.Line, se.Column, se.Msg)
 }
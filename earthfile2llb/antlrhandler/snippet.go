@@ -0,0 +1,53 @@
+package antlrhandler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// snippetContextLines is the number of lines printed before and after the line of an error.
+const snippetContextLines = 2
+
+// Snippet returns the lines of source around the given position (line starting at 1, column
+// starting at 0), with a caret pointing at the column, in the style of compiler diagnostics.
+// An empty string is returned if the position is not within the source.
+func Snippet(source []byte, line, column int) string {
+	lines := strings.Split(strings.ReplaceAll(string(source), "\r\n", "\n"), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	first := line - snippetContextLines
+	if first < 1 {
+		first = 1
+	}
+	last := line + snippetContextLines
+	if last > len(lines) {
+		last = len(lines)
+	}
+	numWidth := len(fmt.Sprintf("%d", last))
+	var sb strings.Builder
+	for i := first; i <= last; i++ {
+		fmt.Fprintf(&sb, "%*d | %s\n", numWidth, i, lines[i-1])
+		if i == line {
+			fmt.Fprintf(&sb, "%*s | %s^\n", numWidth, "", caretPadding(lines[i-1], column))
+		}
+	}
+	return sb.String()
+}
+
+// caretPadding returns the whitespace which aligns a caret with the given column of a line.
+// Tabs are kept, so that the caret lines up however tabs are displayed.
+func caretPadding(line string, column int) string {
+	var sb strings.Builder
+	for i, r := range []rune(line) {
+		if i >= column {
+			break
+		}
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	return sb.String()
+}
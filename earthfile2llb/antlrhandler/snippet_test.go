@@ -0,0 +1,22 @@
+package antlrhandler
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSnippet(t *testing.T) {
+	source := []byte("FROM alpine\n\nbuild:\n\tRUN echo hi\n\tSAVE ARTIFACT\n\nother:\n\tRUN true\n")
+	var tests = []struct {
+		line, column int
+		expected     string
+	}{
+		{1, 5, "1 | FROM alpine\n  |      ^\n2 | \n3 | build:\n"},
+		{5, 6, "3 | build:\n4 | \tRUN echo hi\n5 | \tSAVE ARTIFACT\n  | \t     ^\n6 | \n7 | other:\n"},
+		{100, 0, ""},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.expected, Snippet(source, tt.line, tt.column))
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -42,6 +43,8 @@ type Converter struct {
 	directDeps       []*states.SingleTarget
 	directDepIndices []int
 	buildContext     llb.State
+	localContext     func(includePatterns []string) llb.State
+	ftrs             *Features
 	cacheContext     llb.State
 	varCollection    *variables.Collection
 	nextArgIndex     int
@@ -49,7 +52,7 @@ type Converter struct {
 }
 
 // NewConverter constructs a new converter for a given earthly target.
-func NewConverter(ctx context.Context, target domain.Target, bc *buildcontext.Data, opt ConvertOpt) (*Converter, error) {
+func NewConverter(ctx context.Context, target domain.Target, bc *buildcontext.Data, opt ConvertOpt, ftrs *Features) (*Converter, error) {
 	sts := &states.SingleTarget{
 		Target:   target,
 		Platform: opt.Platform,
@@ -79,9 +82,11 @@ func NewConverter(ctx context.Context, target domain.Target, bc *buildcontext.Da
 		opt:          opt,
 		mts:          mts,
 		buildContext: bc.BuildContext,
+		localContext: bc.LocalContextInclude,
+		ftrs:         ftrs,
 		cacheContext: makeCacheContext(target),
 		varCollection: opt.VarCollection.WithBuiltinBuildArgs(
-			target, llbutil.PlatformWithDefault(opt.Platform), bc.GitMetadata),
+			target, llbutil.PlatformWithDefault(opt.Platform), bc.GitMetadata, opt.SourceDateEpoch),
 	}, nil
 }
 
@@ -155,6 +160,40 @@ func (c *Converter) fromTarget(ctx context.Context, targetName string, platform
 	return nil
 }
 
+// dockerfileName returns the path of the Dockerfile given via FROM DOCKERFILE -f, which is
+// relative to the build context and defaults to Dockerfile.
+func dockerfileName(dfPath string) (string, error) {
+	if dfPath == "" {
+		return "Dockerfile", nil
+	}
+	if path.IsAbs(dfPath) {
+		return "", errors.Errorf("FROM DOCKERFILE -f %s must be relative to the build context", dfPath)
+	}
+	dfName := path.Clean(dfPath)
+	if dfName == ".." || strings.HasPrefix(dfName, "../") {
+		return "", errors.Errorf("FROM DOCKERFILE -f %s must be within the build context", dfPath)
+	}
+	return dfName, nil
+}
+
+// checkWithinDir returns an error if the given file, once its symlinks are resolved, is not
+// within dir.
+func checkWithinDir(dir, file string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.Wrapf(err, "eval symlinks %s", dir)
+	}
+	realFile, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return errors.Wrapf(err, "eval symlinks %s", file)
+	}
+	rel, err := filepath.Rel(realDir, realFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.Errorf("%s is not within the build context %s", file, dir)
+	}
+	return nil
+}
+
 // FromDockerfile applies the earthly FROM DOCKERFILE command.
 func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPath string, dfTarget string, platform *specs.Platform, buildArgs []string) error {
 	platform, err := llbutil.ResolvePlatform(platform, c.opt.Platform)
@@ -164,12 +203,9 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 	c.setPlatform(platform)
 	plat := llbutil.PlatformWithDefault(platform)
 	c.nonSaveCommand()
-	if dfPath != "" {
-		// TODO: It's not yet very clear what -f should do. Should it be referencing a Dockerfile
-		//       from the build context or the build environment?
-		//       Build environment is likely better as it gives maximum flexibility to do
-		//       anything.
-		return errors.New("FROM DOCKERFILE -f not yet supported")
+	dfName, err := dockerfileName(dfPath)
+	if err != nil {
+		return err
 	}
 	var buildContext llb.State
 	var dfData []byte
@@ -183,7 +219,7 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 			return err
 		}
 		dfArtifact := contextArtifact
-		dfArtifact.Artifact = path.Join(dfArtifact.Artifact, "Dockerfile")
+		dfArtifact.Artifact = path.Join(dfArtifact.Artifact, dfName)
 		dfData, err = c.readArtifact(ctx, mts, dfArtifact)
 		if err != nil {
 			return err
@@ -219,6 +255,18 @@ func (c *Converter) FromDockerfile(ctx context.Context, contextPath string, dfPa
 			c.mts.Final.LocalDirs[ldk] = ld
 		}
 		dfPath = data.BuildFilePath
+		if dfName != "Dockerfile" {
+			if dockerfileMetaTarget.IsRemote() {
+				// Only the Dockerfile itself is fetched for remote build contexts.
+				return errors.New("FROM DOCKERFILE -f is not supported for remote build contexts")
+			}
+			contextDir := filepath.Dir(data.BuildFilePath)
+			dfPath = filepath.Join(contextDir, filepath.FromSlash(dfName))
+			err = checkWithinDir(contextDir, dfPath)
+			if err != nil {
+				return errors.Wrapf(err, "FROM DOCKERFILE -f %s", dfName)
+			}
+		}
 		dfData, err = ioutil.ReadFile(dfPath)
 		if err != nil {
 			return errors.Wrapf(err, "read file %s", dfPath)
@@ -303,8 +351,15 @@ func (c *Converter) CopyArtifact(ctx context.Context, artifactName string, dest
 // CopyClassical applies the earthly COPY command, with classical args.
 func (c *Converter) CopyClassical(ctx context.Context, srcs []string, dest string, isDir bool, keepTs bool, keepOwn bool, chown string) {
 	c.nonSaveCommand()
+	buildContext := c.buildContext
+	if c.ftrs.UseCopyIncludePatterns && c.localContext != nil {
+		includePatterns := copyIncludePatterns(srcs)
+		if includePatterns != nil {
+			buildContext = c.localContext(includePatterns)
+		}
+	}
 	c.mts.Final.MainState = llbutil.CopyOp(
-		c.buildContext, srcs, c.mts.Final.MainState, dest, true, isDir, keepTs, c.copyOwner(keepOwn, chown), false,
+		buildContext, srcs, c.mts.Final.MainState, dest, true, isDir, keepTs, c.copyOwner(keepOwn, chown), false,
 		llb.WithCustomNamef(
 			"%sCOPY %s%s %s",
 			c.vertexPrefix(false),
@@ -362,7 +417,7 @@ func (c *Converter) Run(ctx context.Context, args, mounts, secretKeyValues []str
 		return errors.New("RUN --with-docker is obsolete. Please use WITH DOCKER ... RUN ... END instead")
 	}
 	var opts []llb.RunOption
-	mountRunOpts, err := parseMounts(mounts, c.mts.Final.Target, c.mts.Final.TargetInput, c.cacheContext)
+	mountRunOpts, err := parseMounts(mounts, c.mts.Final.Target, c.mts.Final.TargetInput, c.cacheContext, c.opt)
 	if err != nil {
 		return errors.Wrap(err, "parse mounts")
 	}
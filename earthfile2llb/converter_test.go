@@ -0,0 +1,68 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestDockerfileName(t *testing.T) {
+	var tests = []struct {
+		dfPath   string
+		expected string
+		err      bool
+	}{
+		{"", "Dockerfile", false},
+		{"Dockerfile.alt", "Dockerfile.alt", false},
+		{"./docker/Dockerfile", "docker/Dockerfile", false},
+		{"docker/../Dockerfile", "Dockerfile", false},
+		{"/Dockerfile", "", true},
+		{"..", "", true},
+		{"../Dockerfile", "", true},
+		{"docker/../../Dockerfile", "", true},
+	}
+
+	for _, tt := range tests {
+		actual, err := dockerfileName(tt.dfPath)
+		if tt.err {
+			Error(t, err, tt.dfPath)
+			continue
+		}
+		NoError(t, err, tt.dfPath)
+		Equal(t, tt.expected, actual, tt.dfPath)
+	}
+}
+
+func TestCheckWithinDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-converter-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	contextDir := filepath.Join(dir, "context")
+	NoError(t, os.Mkdir(contextDir, 0755))
+	NoError(t, ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM alpine\n"), 0644))
+	NoError(t, ioutil.WriteFile(filepath.Join(dir, "Dockerfile.outside"), []byte("FROM alpine\n"), 0644))
+	NoError(t, os.Symlink(filepath.Join(contextDir, "Dockerfile"), filepath.Join(contextDir, "Dockerfile.inside")))
+	NoError(t, os.Symlink(filepath.Join(dir, "Dockerfile.outside"), filepath.Join(contextDir, "Dockerfile.link")))
+
+	var tests = []struct {
+		name string
+		err  bool
+	}{
+		{"Dockerfile", false},
+		{"Dockerfile.inside", false},
+		{"Dockerfile.link", true},
+		{"missing", true},
+	}
+
+	for _, tt := range tests {
+		err := checkWithinDir(contextDir, filepath.Join(contextDir, tt.name))
+		if tt.err {
+			Error(t, err, tt.name)
+		} else {
+			NoError(t, err, tt.name)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+)
+
+// DebugTokens lexes an Earthfile and writes a table of the emitted tokens (including the
+// comments, the INDENT and DEDENT tokens and the heredoc bodies) to w, in order of
+// emission. Lexer errors are returned once all the tokens have been written.
+func DebugTokens(filename string, w io.Writer) error {
+	errorListener := antlrhandler.NewReturnErrorListener()
+	lexer, err := newEarthfileLexer(filename, true, errorListener)
+	if err != nil {
+		return err
+	}
+	names := lexer.GetSymbolicNames()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tTEXT\tLINE\tCOLUMN\tCHANNEL")
+	for {
+		tok := lexer.NextToken()
+		if tok.GetTokenType() == antlr.TokenEOF {
+			fmt.Fprintf(tw, "EOF\t\t%d\t%d\t%d\n", tok.GetLine(), tok.GetColumn(), tok.GetChannel())
+			break
+		}
+		name := fmt.Sprintf("%d", tok.GetTokenType())
+		if tok.GetTokenType() < len(names) && names[tok.GetTokenType()] != "" {
+			name = names[tok.GetTokenType()]
+		}
+		channel := fmt.Sprintf("%d", tok.GetChannel())
+		if tok.GetChannel() == commentChannel {
+			channel += " (comment)"
+		}
+		fmt.Fprintf(tw, "%s\t%q\t%d\t%d\t%s\n", name, tok.GetText(), tok.GetLine(), tok.GetColumn(), channel)
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+	return parseError(filename, errorListener, antlrhandler.NewReturnErrorStrategy())
+}
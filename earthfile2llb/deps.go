@@ -0,0 +1,158 @@
+package earthfile2llb
+
+import (
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+	"github.com/pkg/errors"
+)
+
+// GetTargetDeps returns the earthly targets referenced by each target of an Earthfile
+// (via FROM, FROM DOCKERFILE, COPY, BUILD and WITH DOCKER --load), keyed by target name.
+// The references are returned as written in the Earthfile, without any build arg expansion.
+// References made before the first target are keyed under "base".
+func GetTargetDeps(filename string) (map[string][]string, error) {
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, errors.Wrap(err, "new earthfile tree")
+	}
+	err = parseError(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, err
+	}
+	dc := newDepsCollector()
+	antlr.ParseTreeWalkerDefault.Walk(dc, tree)
+	return dc.deps, nil
+}
+
+type depsCollector struct {
+	*parser.BaseEarthParserListener
+	currentTarget string
+	stmtWords     []string
+	deps          map[string][]string
+}
+
+func newDepsCollector() *depsCollector {
+	return &depsCollector{
+		currentTarget: "base",
+		deps:          map[string][]string{"base": nil},
+	}
+}
+
+func (dc *depsCollector) EnterTargetHeader(c *parser.TargetHeaderContext) {
+	dc.currentTarget = strings.TrimSuffix(c.GetText(), ":")
+	if _, found := dc.deps[dc.currentTarget]; !found {
+		dc.deps[dc.currentTarget] = nil
+	}
+}
+
+func (dc *depsCollector) EnterStmt(c *parser.StmtContext) {
+	dc.stmtWords = nil
+}
+
+func (dc *depsCollector) EnterStmtWord(c *parser.StmtWordContext) {
+	dc.stmtWords = append(dc.stmtWords, replaceEscape(c.GetText()))
+}
+
+func (dc *depsCollector) ExitFromStmt(c *parser.FromStmtContext) {
+	_, args := parseFlagWords(dc.stmtWords, "build-arg", "platform")
+	if len(args) == 1 {
+		dc.addTarget(args[0])
+	}
+}
+
+func (dc *depsCollector) ExitFromDockerfileStmt(c *parser.FromDockerfileStmtContext) {
+	_, args := parseFlagWords(dc.stmtWords, "build-arg", "platform", "target", "f")
+	if len(args) == 1 {
+		dc.addArtifact(args[0])
+	}
+}
+
+func (dc *depsCollector) ExitCopyStmt(c *parser.CopyStmtContext) {
+	_, args := parseFlagWords(dc.stmtWords, "from", "chown", "platform", "build-arg")
+	if len(args) < 2 {
+		return
+	}
+	for _, src := range args[:len(args)-1] {
+		dc.addArtifact(src)
+	}
+}
+
+func (dc *depsCollector) ExitBuildStmt(c *parser.BuildStmtContext) {
+	_, args := parseFlagWords(dc.stmtWords, "build-arg", "platform")
+	if len(args) == 1 {
+		dc.addTarget(args[0])
+	}
+}
+
+func (dc *depsCollector) ExitWithDockerStmt(c *parser.WithDockerStmtContext) {
+	values, _ := parseFlagWords(dc.stmtWords, "compose", "service", "load", "platform", "build-arg", "pull")
+	for _, loadStr := range values["load"] {
+		_, loadTarget, err := parseLoad(loadStr)
+		if err != nil {
+			continue
+		}
+		dc.addTarget(loadTarget)
+	}
+}
+
+func (dc *depsCollector) addTarget(ref string) {
+	target, err := domain.ParseTarget(ref)
+	if err != nil {
+		// Not a target (e.g. a docker image).
+		return
+	}
+	dc.add(target.String())
+}
+
+func (dc *depsCollector) addArtifact(ref string) {
+	artifact, err := domain.ParseArtifact(ref)
+	if err != nil {
+		// Not an artifact (e.g. a build context path).
+		return
+	}
+	dc.add(artifact.Target.String())
+}
+
+func (dc *depsCollector) add(ref string) {
+	for _, existing := range dc.deps[dc.currentTarget] {
+		if existing == ref {
+			return
+		}
+	}
+	dc.deps[dc.currentTarget] = append(dc.deps[dc.currentTarget], ref)
+}
+
+// parseFlagWords splits statement words into flag values and positional args, in the same
+// way as the flag package would (flags end at the first non-flag word). Only the flags
+// listed in valueFlags consume a separate value word; all others are treated as bools.
+func parseFlagWords(words []string, valueFlags ...string) (map[string][]string, []string) {
+	values := make(map[string][]string)
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if word == "--" {
+			return values, words[i+1:]
+		}
+		if !strings.HasPrefix(word, "-") || word == "-" {
+			return values, words[i:]
+		}
+		name := strings.TrimLeft(word, "-")
+		if eq := strings.Index(name, "="); eq != -1 {
+			values[name[:eq]] = append(values[name[:eq]], name[eq+1:])
+			continue
+		}
+		for _, vf := range valueFlags {
+			if vf == name && i+1 < len(words) {
+				values[name] = append(values[name], words[i+1])
+				i++
+				break
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,43 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGetTargetDeps(t *testing.T) {
+	var tests = []struct {
+		earthfile string
+		deps      map[string][]string
+		ok        bool
+	}{
+		{
+			"FROM +img\n\nbuild:\n    FROM golang:1.16\n    COPY --dir ./lib+lib/out src/ .\n    BUILD --build-arg X=1 +dep\n    BUILD +dep\n\ndep:\n    FROM DOCKERFILE -f ./Dockerfile +ctx/\n    WITH DOCKER --load app:latest=+img\n        RUN true\n    END\n",
+			map[string][]string{
+				"base":  {"+img"},
+				"build": {"./lib+lib", "+dep"},
+				"dep":   {"+ctx", "+img"},
+			},
+			true,
+		},
+		{"FROM alpine:3.13\n\nbuild:\n    RUN true\n  bad indent\n", nil, false},
+	}
+	dir, err := ioutil.TempDir("", "earthly-deps-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	for _, tt := range tests {
+		NoError(t, ioutil.WriteFile(file, []byte(tt.earthfile), 0644))
+		deps, err := GetTargetDeps(file)
+		if !tt.ok {
+			Error(t, err, tt.earthfile)
+			continue
+		}
+		NoError(t, err, tt.earthfile)
+		Equal(t, tt.deps, deps)
+	}
+}
@@ -3,12 +3,14 @@ package earthfile2llb
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"strings"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
 	"github.com/earthly/earthly/buildcontext"
 	"github.com/earthly/earthly/buildcontext/provider"
 	"github.com/earthly/earthly/cleanup"
+	"github.com/earthly/earthly/conslogging"
 	"github.com/earthly/earthly/domain"
 	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
 	"github.com/earthly/earthly/earthfile2llb/parser"
@@ -56,6 +58,21 @@ type ConvertOpt struct {
 	UseInlineCache bool
 	// UseFakeDep is an internal feature flag for fake dep.
 	UseFakeDep bool
+	// CacheMountSharing is the sharing mode of RUN --mount=type=cache mounts which do not
+	// specify one.
+	CacheMountSharing llb.CacheMountSharingMode
+	// NoCacheMounts makes cache mounts start empty, as if nothing had been cached.
+	NoCacheMounts bool
+	// EarthlyVersion is the version of the running earthly, which is checked against the
+	// VERSION declared by Earthfiles. The check is skipped if it is not a release version.
+	EarthlyVersion string
+	// Console is the console the warnings of the conversion are printed to.
+	Console conslogging.ConsoleLogger
+	// Strict turns the deprecation warnings into errors.
+	Strict bool
+	// SourceDateEpoch is the value of the SOURCE_DATE_EPOCH builtin arg. If empty, the timestamp
+	// of the git commit of the build context is used instead.
+	SourceDateEpoch string
 }
 
 // Earthfile2LLB parses a earthfile and executes the statements for a given target.
@@ -117,31 +134,21 @@ func Earthfile2LLB(ctx context.Context, target domain.Target, opt ConvertOpt) (m
 	if err != nil {
 		return nil, err
 	}
-	converter, err := NewConverter(ctx, bc.Target, bc, opt)
+	err = parseError(bc.BuildFilePath, errorListener, errorStrategy)
 	if err != nil {
 		return nil, err
 	}
-	walkErr := walkTree(newListener(ctx, converter, target.Target), tree)
-	if len(errorListener.Errs) > 0 {
-		var errString []string
-		for _, err := range errorListener.Errs {
-			errString = append(errString, err.Error())
-		}
-		return nil, fmt.Errorf(strings.Join(errString, "\n"))
-	}
-	if errorStrategy.Err != nil {
-		var errString []string
-		errString = append(errString,
-			fmt.Sprintf(
-				"syntax error: line %d:%d",
-				errorStrategy.RE.GetOffendingToken().GetLine(),
-				errorStrategy.RE.GetOffendingToken().GetColumn()))
-		errString = append(errString,
-			fmt.Sprintf("Details: %s", errorStrategy.RE.GetMessage()))
-		return nil, errors.Wrapf(errorStrategy.Err, "%s", strings.Join(errString, "\n"))
-	}
-	if walkErr != nil {
-		return nil, walkErr
+	ftrs, err := getFeatures(tree)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get features of %s", bc.BuildFilePath)
+	}
+	converter, err := NewConverter(ctx, bc.Target, bc, opt, ftrs)
+	if err != nil {
+		return nil, err
+	}
+	err = walkTree(newListener(ctx, converter, target.Target), tree)
+	if err != nil {
+		return nil, err
 	}
 	return converter.FinalizeStates(ctx)
 }
@@ -161,24 +168,64 @@ func walkTree(l *listener, tree parser.IEarthFileContext) (err error) {
 	return nil
 }
 
+// parseError returns an error describing the syntax errors collected while parsing an
+// Earthfile, if any, including the relevant source lines.
+func parseError(filename string, errorListener *antlrhandler.ReturnErrorListener, errorStrategy *antlrhandler.ReturnErrorStrategy) error {
+	if len(errorListener.Errs) == 0 && errorStrategy.Err == nil {
+		return nil
+	}
+	// The errors are reported without source snippets if the file cannot be read.
+	source, _ := ioutil.ReadFile(filename)
+	var errString []string
+	appendSnippet := func(line, column int) {
+		snippet := antlrhandler.Snippet(source, line, column)
+		if len(source) > 0 && snippet != "" {
+			errString = append(errString, strings.TrimSuffix(snippet, "\n"))
+		}
+	}
+	if len(errorListener.Errs) > 0 {
+		for _, err := range errorListener.Errs {
+			errString = append(errString, err.Error())
+			var se *antlrhandler.SyntaxError
+			if errors.As(err, &se) {
+				appendSnippet(se.Line, se.Column)
+			}
+		}
+		return errors.New(strings.Join(errString, "\n"))
+	}
+	offendingToken := errorStrategy.RE.GetOffendingToken()
+	errString = append(errString,
+		fmt.Sprintf(
+			"syntax error: line %d:%d",
+			offendingToken.GetLine(),
+			offendingToken.GetColumn()))
+	errString = append(errString,
+		fmt.Sprintf("Details: %s", errorStrategy.RE.GetMessage()))
+	appendSnippet(offendingToken.GetLine(), offendingToken.GetColumn())
+	return errors.Wrapf(errorStrategy.Err, "%s", strings.Join(errString, "\n"))
+}
+
 // ParseDebug parses a earthfile and prints debug information about it.
 func ParseDebug(filename string) error {
-	tree, err := newEarthfileTree(
-		filename, antlr.NewConsoleErrorListener(), antlr.NewBailErrorStrategy())
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(filename, errorListener, errorStrategy)
 	if err != nil {
 		return errors.Wrap(err, "new earthfile tree")
 	}
+	err = parseError(filename, errorListener, errorStrategy)
+	if err != nil {
+		return err
+	}
 	antlr.ParseTreeWalkerDefault.Walk(newDebugListener(), tree)
 	return nil
 }
 
 func newEarthfileTree(filename string, errorListener antlr.ErrorListener, errorStrategy antlr.ErrorStrategy) (parser.IEarthFileContext, error) {
-	input, err := antlr.NewFileStream(filename)
+	stream, err := newEarthfileTokenStream(filename, false, errorListener)
 	if err != nil {
-		return nil, errors.Wrapf(err, "new file stream %s", filename)
+		return nil, err
 	}
-	lexer := newLexer(input)
-	stream := antlr.NewCommonTokenStream(lexer, 0)
 	p := parser.NewEarthParser(stream)
 	p.AddErrorListener(errorListener)
 	p.SetErrorHandler(errorStrategy)
@@ -186,13 +233,42 @@ func newEarthfileTree(filename string, errorListener antlr.ErrorListener, errorS
 	return p.EarthFile(), nil
 }
 
+// newEarthfileTokenStream returns a token stream for an Earthfile. If preserveComments is set,
+// the stream also contains the comments, on the comment channel. Lexer errors are reported to
+// the given error listener.
+func newEarthfileTokenStream(filename string, preserveComments bool, errorListener antlr.ErrorListener) (*antlr.CommonTokenStream, error) {
+	lexer, err := newEarthfileLexer(filename, preserveComments, errorListener)
+	if err != nil {
+		return nil, err
+	}
+	return antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel), nil
+}
+
+// newEarthfileLexer returns a lexer for an Earthfile, which reports its errors to the given
+// error listener.
+func newEarthfileLexer(filename string, preserveComments bool, errorListener antlr.ErrorListener) (antlr.Lexer, error) {
+	input, err := antlr.NewFileStream(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new file stream %s", filename)
+	}
+	lexer := newLexer(input, preserveComments)
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(errorListener)
+	return lexer, nil
+}
+
 // GetTargets returns a list of targets from an Earthfile
 func GetTargets(filename string) ([]string, error) {
-	tree, err := newEarthfileTree(
-		filename, antlr.NewConsoleErrorListener(), antlr.NewBailErrorStrategy())
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(filename, errorListener, errorStrategy)
 	if err != nil {
 		return nil, errors.Wrap(err, "new earthfile tree")
 	}
+	err = parseError(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, err
+	}
 	tc := &targetCollector{}
 	antlr.ParseTreeWalkerDefault.Walk(tc, tree)
 	return tc.targets, nil
@@ -0,0 +1,205 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+	"github.com/pkg/errors"
+)
+
+// unsupportedPrefix marks the commands of an exported Dockerfile which have no Dockerfile
+// equivalent, and which have been commented out.
+const unsupportedPrefix = "# UNSUPPORTED: "
+
+// ExportDockerfile returns a Dockerfile equivalent to the given target of an Earthfile, on a
+// best-effort basis. The commands which have no Dockerfile equivalent (e.g. COPY +other/...,
+// BUILD or WITH DOCKER) are commented out and marked as unsupported; their number is returned
+// too. The paths of the Dockerfile are relative to the directory of the Earthfile, which is
+// the build context to use.
+func ExportDockerfile(filename string, target string) ([]byte, int, error) {
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "new earthfile tree")
+	}
+	err = parseError(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, 0, err
+	}
+	sc := &stmtCollector{
+		currentTarget: "base",
+		recipes:       map[string][]exportStmt{"base": nil},
+	}
+	antlr.ParseTreeWalkerDefault.Walk(sc, tree)
+	recipe, found := sc.recipes[target]
+	if !found || target == "base" {
+		return nil, 0, fmt.Errorf("target %s not defined in %s", target, filename)
+	}
+	if len(recipe) == 0 || !recipe[0].isFrom() {
+		// Targets which do not start with a FROM inherit the base recipe.
+		recipe = append(append([]exportStmt{}, sc.recipes["base"]...), recipe...)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# This Dockerfile was generated from the +%s target using export-dockerfile\n", target)
+	fmt.Fprintf(&sb, "# the conversion is done on a best-effort basis; the commands which have\n")
+	fmt.Fprintf(&sb, "# no Dockerfile equivalent are commented out and marked as UNSUPPORTED\n")
+	unsupported := 0
+	for _, stmt := range recipe {
+		line, ok := stmt.dockerfileLine()
+		if !ok {
+			unsupported++
+			line = unsupportedPrefix + stmt.text
+		}
+		fmt.Fprintf(&sb, "%s\n", line)
+	}
+	return []byte(sb.String()), unsupported, nil
+}
+
+// exportStmt is a command of an Earthfile recipe.
+type exportStmt struct {
+	// command is the command keyword (e.g. RUN or SAVE IMAGE).
+	command string
+	// words are the arguments of the command, for the commands which take plain words.
+	words []string
+	// text is the whole command, on a single line.
+	text string
+	// inWithDocker is set for the commands within a WITH DOCKER block.
+	inWithDocker bool
+}
+
+func (s exportStmt) isFrom() bool {
+	return s.command == "FROM" || s.command == "FROM DOCKERFILE" || s.command == "LOCALLY"
+}
+
+// flags returns the leading flags of the command.
+func (s exportStmt) flags() []string {
+	var flags []string
+	for _, word := range s.words {
+		if !strings.HasPrefix(word, "--") {
+			break
+		}
+		flags = append(flags, word)
+	}
+	return flags
+}
+
+// hasOnlyFlags returns true if all the leading flags of the command are among the given ones.
+func (s exportStmt) hasOnlyFlags(allowed ...string) bool {
+	for _, flag := range s.flags() {
+		name := strings.SplitN(flag, "=", 2)[0]
+		found := false
+		for _, a := range allowed {
+			if name == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// dockerfileLine returns the Dockerfile equivalent of the command, or false if there is none.
+func (s exportStmt) dockerfileLine() (string, bool) {
+	if s.inWithDocker {
+		return "", false
+	}
+	switch s.command {
+	case "FROM":
+		if !s.hasOnlyFlags("--platform") {
+			return "", false
+		}
+		for _, word := range s.words[len(s.flags()):] {
+			if _, err := domain.ParseTarget(word); err == nil {
+				return "", false
+			}
+		}
+		return s.text, true
+	case "COPY":
+		// Flags such as --dir or --keep-ts have no equivalent, and --from means something else.
+		if !s.hasOnlyFlags("--chown") {
+			return "", false
+		}
+		for _, word := range s.words[len(s.flags()):] {
+			// Earthly treats any source which parses as an artifact as one.
+			if _, err := domain.ParseArtifact(word); err == nil {
+				return "", false
+			}
+		}
+		return s.text, true
+	case "RUN":
+		if !s.hasOnlyFlags("--mount") {
+			return "", false
+		}
+		return s.text, true
+	case "SAVE IMAGE":
+		var names []string
+		for _, word := range s.words[len(s.flags()):] {
+			names = append(names, "-t "+word)
+		}
+		if len(names) == 0 {
+			return "# SAVE IMAGE: the image is not named", true
+		}
+		return fmt.Sprintf("# SAVE IMAGE: name the image via docker build %s", strings.Join(names, " ")), true
+	case "ARG", "ENV":
+		if strings.Contains(s.text, "$(") {
+			// Shell-out values are an Earthly feature.
+			return "", false
+		}
+		return s.text, true
+	case "WORKDIR", "USER", "CMD", "ENTRYPOINT", "EXPOSE", "VOLUME", "LABEL",
+		"ADD", "STOPSIGNAL", "ONBUILD", "HEALTHCHECK", "SHELL":
+		return s.text, true
+	default:
+		// FROM DOCKERFILE, LOCALLY, SAVE ARTIFACT, BUILD, GIT CLONE, WITH DOCKER, END, etc.
+		return "", false
+	}
+}
+
+// stmtCollector collects the commands of each recipe of an Earthfile, keyed by target name.
+// The commands of the base recipe are keyed under "base".
+type stmtCollector struct {
+	*parser.BaseEarthParserListener
+	currentTarget string
+	stmt          exportStmt
+	inWithDocker  bool
+	recipes       map[string][]exportStmt
+}
+
+func (sc *stmtCollector) EnterTargetHeader(c *parser.TargetHeaderContext) {
+	sc.currentTarget = strings.TrimSuffix(c.GetText(), ":")
+	sc.recipes[sc.currentTarget] = nil
+	sc.inWithDocker = false
+}
+
+func (sc *stmtCollector) EnterStmt(c *parser.StmtContext) {
+	sc.stmt = exportStmt{
+		command:      c.GetStart().GetText(),
+		text:         strings.TrimSpace(replaceEscape(c.GetText())),
+		inWithDocker: sc.inWithDocker,
+	}
+}
+
+func (sc *stmtCollector) EnterStmtWord(c *parser.StmtWordContext) {
+	sc.stmt.words = append(sc.stmt.words, replaceEscape(c.GetText()))
+}
+
+func (sc *stmtCollector) EnterWithDockerStmt(c *parser.WithDockerStmtContext) {
+	sc.inWithDocker = true
+}
+
+func (sc *stmtCollector) EnterEndStmt(c *parser.EndStmtContext) {
+	sc.inWithDocker = false
+}
+
+func (sc *stmtCollector) ExitStmt(c *parser.StmtContext) {
+	sc.recipes[sc.currentTarget] = append(sc.recipes[sc.currentTarget], sc.stmt)
+}
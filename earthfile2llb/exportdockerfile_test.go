@@ -0,0 +1,70 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestExportDockerfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-export-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte(`FROM golang:1.15-alpine3.12
+WORKDIR /app
+
+deps:
+    COPY go.mod go.sum ./
+    RUN go mod download
+
+build:
+    FROM +deps
+    COPY --dir cmd ./
+    RUN go build -o app ./cmd
+    SAVE ARTIFACT app AS LOCAL app
+
+docker:
+    ARG VERSION=latest
+    COPY +build/app /usr/bin/app
+    COPY --chown=1000 config.yml \
+        /etc/app/
+    ENTRYPOINT ["/usr/bin/app"]
+    WITH DOCKER
+        RUN docker ps
+    END
+    SAVE IMAGE app:$VERSION
+`), 0644)
+	NoError(t, err)
+
+	out, unsupported, err := ExportDockerfile(file, "deps")
+	NoError(t, err)
+	Equal(t, 0, unsupported)
+	Contains(t, string(out), "\nFROM golang:1.15-alpine3.12\nWORKDIR /app\nCOPY go.mod go.sum ./\nRUN go mod download\n")
+
+	out, unsupported, err = ExportDockerfile(file, "build")
+	NoError(t, err)
+	Equal(t, 3, unsupported)
+	NotContains(t, string(out), "WORKDIR /app")
+	Contains(t, string(out), "\n# UNSUPPORTED: FROM +deps\n# UNSUPPORTED: COPY --dir cmd ./\nRUN go build -o app ./cmd\n# UNSUPPORTED: SAVE ARTIFACT app AS LOCAL app\n")
+
+	out, unsupported, err = ExportDockerfile(file, "docker")
+	NoError(t, err)
+	Equal(t, 4, unsupported)
+	Contains(t, string(out), "\nFROM golang:1.15-alpine3.12\nWORKDIR /app\nARG VERSION=latest\n# UNSUPPORTED: COPY +build/app /usr/bin/app\n")
+	Contains(t, string(out), "\nCOPY --chown=1000 config.yml /etc/app/\n")
+	Contains(t, string(out), "\n# UNSUPPORTED: WITH DOCKER\n# UNSUPPORTED: RUN docker ps\n# UNSUPPORTED: END\n")
+	Contains(t, string(out), "\n# SAVE IMAGE: name the image via docker build -t app:$VERSION\n")
+
+	_, _, err = ExportDockerfile(file, "missing")
+	Error(t, err)
+
+	err = ioutil.WriteFile(file, []byte("FROM alpine:3.13\n\nbuild:\n    RUN true\n  bad indent\n"), 0644)
+	NoError(t, err)
+	_, _, err = ExportDockerfile(file, "build")
+	Error(t, err)
+	Contains(t, err.Error(), "syntax error")
+}
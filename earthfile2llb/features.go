@@ -0,0 +1,107 @@
+package earthfile2llb
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+)
+
+// Features are the behavior changes which an Earthfile opts into, via flags of its VERSION
+// command (e.g. VERSION --use-copy-include-patterns 0.5). They apply to the whole Earthfile.
+type Features struct {
+	// UseCopyIncludePatterns makes the COPY commands from the local build context only
+	// transfer the files which are copied, rather than the whole build context.
+	UseCopyIncludePatterns bool
+}
+
+// parseVersionArgs parses the arguments of a VERSION command into the required earthly
+// version and the features enabled.
+func parseVersionArgs(args []string) (string, *Features, error) {
+	ftrs := &Features{}
+	fs := flag.NewFlagSet("VERSION", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.BoolVar(&ftrs.UseCopyIncludePatterns, "use-copy-include-patterns", false,
+		"Only transfer the copied files from the build context")
+	err := fs.Parse(args)
+	if err != nil {
+		var known []string
+		fs.VisitAll(func(f *flag.Flag) {
+			known = append(known, "--"+f.Name)
+		})
+		return "", nil, fmt.Errorf(
+			"invalid VERSION arguments %v: %s; the known feature flags are %s",
+			args, err.Error(), strings.Join(known, ", "))
+	}
+	if fs.NArg() != 1 {
+		return "", nil, fmt.Errorf("invalid number of arguments for VERSION: %s", args)
+	}
+	return fs.Arg(0), ftrs, nil
+}
+
+// getFeatures returns the features enabled by the VERSION command of an Earthfile. They
+// are needed for every target of the Earthfile, while the listener only handles the
+// statements of the base recipe when converting the base target.
+func getFeatures(tree parser.IEarthFileContext) (*Features, error) {
+	vc := &versionCollector{currentTarget: "base"}
+	antlr.ParseTreeWalkerDefault.Walk(vc, tree)
+	if vc.versionArgs == nil {
+		return &Features{}, nil
+	}
+	version, ftrs, err := parseVersionArgs(vc.versionArgs)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := parseVersion(version); !ok {
+		return nil, fmt.Errorf("invalid VERSION %s; expected a version such as 0.5 or 0.5.2", version)
+	}
+	return ftrs, nil
+}
+
+// versionCollector collects the arguments of the VERSION command of the base recipe.
+type versionCollector struct {
+	*parser.BaseEarthParserListener
+	currentTarget string
+	stmtWords     []string
+	versionArgs   []string
+}
+
+func (vc *versionCollector) EnterTargetHeader(c *parser.TargetHeaderContext) {
+	vc.currentTarget = strings.TrimSuffix(c.GetText(), ":")
+}
+
+func (vc *versionCollector) EnterStmt(c *parser.StmtContext) {
+	vc.stmtWords = nil
+}
+
+func (vc *versionCollector) EnterStmtWord(c *parser.StmtWordContext) {
+	vc.stmtWords = append(vc.stmtWords, replaceEscape(c.GetText()))
+}
+
+func (vc *versionCollector) ExitGenericCommandStmt(c *parser.GenericCommandStmtContext) {
+	if vc.currentTarget != "base" || vc.versionArgs != nil {
+		return
+	}
+	if c.CommandName().GetText() == "VERSION" {
+		vc.versionArgs = append([]string{}, vc.stmtWords...)
+	}
+}
+
+// copyIncludePatterns returns the patterns of the build context files copied by a COPY
+// command, or nil if the whole build context is needed.
+func copyIncludePatterns(srcs []string) []string {
+	var patterns []string
+	for _, src := range srcs {
+		pattern := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(src)), "/")
+		if pattern == "" {
+			return nil
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
@@ -0,0 +1,77 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseVersionArgs(t *testing.T) {
+	version, ftrs, err := parseVersionArgs([]string{"0.5"})
+	NoError(t, err)
+	Equal(t, "0.5", version)
+	False(t, ftrs.UseCopyIncludePatterns)
+
+	version, ftrs, err = parseVersionArgs([]string{"--use-copy-include-patterns", "0.5"})
+	NoError(t, err)
+	Equal(t, "0.5", version)
+	True(t, ftrs.UseCopyIncludePatterns)
+
+	_, _, err = parseVersionArgs([]string{"--no-such-feature", "0.5"})
+	Error(t, err)
+	Contains(t, err.Error(), "--use-copy-include-patterns")
+
+	_, _, err = parseVersionArgs([]string{"--use-copy-include-patterns"})
+	Error(t, err)
+	_, _, err = parseVersionArgs(nil)
+	Error(t, err)
+}
+
+func TestGetFeatures(t *testing.T) {
+	var tests = []struct {
+		earthfile string
+		ftrs      *Features
+		ok        bool
+	}{
+		{"FROM alpine:3.13\n\nbuild:\n    RUN true\n", &Features{}, true},
+		{"VERSION 0.5\nFROM alpine:3.13\n", &Features{}, true},
+		{"VERSION --use-copy-include-patterns 0.5\nFROM alpine:3.13\n\nbuild:\n    RUN true\n", &Features{UseCopyIncludePatterns: true}, true},
+		{"VERSION --copy-everything 0.5\nFROM alpine:3.13\n", nil, false},
+	}
+	dir, err := ioutil.TempDir("", "earthly-features-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	for _, tt := range tests {
+		NoError(t, ioutil.WriteFile(file, []byte(tt.earthfile), 0644))
+		tree, err := newEarthfileTree(file, antlrhandler.NewReturnErrorListener(), antlrhandler.NewReturnErrorStrategy())
+		NoError(t, err)
+		ftrs, err := getFeatures(tree)
+		if !tt.ok {
+			Error(t, err, tt.earthfile)
+			continue
+		}
+		NoError(t, err, tt.earthfile)
+		Equal(t, tt.ftrs, ftrs, tt.earthfile)
+	}
+}
+
+func TestCopyIncludePatterns(t *testing.T) {
+	var tests = []struct {
+		srcs     []string
+		patterns []string
+	}{
+		{[]string{"go.mod", "go.sum"}, []string{"go.mod", "go.sum"}},
+		{[]string{"./src/", "../other/*.go"}, []string{"src", "other/*.go"}},
+		{[]string{"/abs/file"}, []string{"abs/file"}},
+		{[]string{"src", "."}, nil},
+		{[]string{"./"}, nil},
+	}
+	for _, tt := range tests {
+		Equal(t, tt.patterns, copyIncludePatterns(tt.srcs), "%v", tt.srcs)
+	}
+}
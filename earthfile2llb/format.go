@@ -0,0 +1,230 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+)
+
+const formatIndent = "    "
+
+// Format returns the contents of an Earthfile, formatted in canonical form: commands are
+// indented by four spaces within targets (and within WITH DOCKER blocks), words are
+// separated by a single space, continuation lines are indented one more level and runs of
+// blank lines are collapsed. Comments and heredoc bodies are kept intact.
+func Format(filename string) ([]byte, error) {
+	stream, err := newEarthfileTokenStream(filename, true, antlr.NewConsoleErrorListener())
+	if err != nil {
+		return nil, err
+	}
+	stream.Fill()
+	f := &formatter{}
+	for _, tok := range stream.GetAllTokens() {
+		f.token(tok)
+	}
+	out := []byte(f.String())
+
+	// Make sure that the formatted output carries the same meaning.
+	formattedStream := antlr.NewCommonTokenStream(
+		newLexer(antlr.NewInputStream(string(out)), false), antlr.TokenDefaultChannel)
+	formattedStream.Fill()
+	if !sameWords(stream.GetAllTokens(), formattedStream.GetAllTokens()) {
+		return nil, fmt.Errorf("formatting %s would change its meaning", filename)
+	}
+	return out, nil
+}
+
+type formatter struct {
+	sb              strings.Builder
+	line            strings.Builder
+	inRecipe        bool
+	withDockerDepth int
+	pendingSpace    bool
+	afterEquals     bool
+	pendingComments []antlr.Token
+	blankLines      int
+	started         bool
+}
+
+func (f *formatter) indent() string {
+	depth := f.withDockerDepth
+	if f.inRecipe {
+		depth++
+	}
+	return strings.Repeat(formatIndent, depth)
+}
+
+func (f *formatter) token(tok antlr.Token) {
+	if tok.GetChannel() == commentChannel {
+		if tok.GetTokenType() == parser.EarthLexerNL {
+			f.pendingComments = append(f.pendingComments, tok)
+		}
+		// Comments within line continuations are handled as part of the WS token.
+		return
+	}
+	switch tok.GetTokenType() {
+	case antlr.TokenEOF, parser.EarthLexerINDENT, parser.EarthLexerDEDENT:
+	case parser.EarthLexerNL:
+		f.endLine()
+	case parser.EarthLexerWS:
+		if f.line.Len() == 0 {
+			// Indentation.
+			return
+		}
+		if strings.Contains(tok.GetText(), "\n") {
+			f.continuation(tok.GetText())
+			return
+		}
+		if !f.afterEquals {
+			f.pendingSpace = true
+		}
+	case parser.EarthLexerEQUALS:
+		f.pendingSpace = false
+		f.line.WriteString(tok.GetText())
+		f.afterEquals = true
+	case parser.EarthLexerTarget:
+		f.withDockerDepth = 0
+		f.line.WriteString(tok.GetText())
+		f.inRecipe = true
+	case parser.EarthLexerAtom:
+		if isHereDocBody(tok.GetText()) {
+			f.flushComments()
+			f.pendingSpace = false
+			f.line.WriteString(tok.GetText())
+			return
+		}
+		f.word(tok.GetText())
+	case parser.EarthLexerEND:
+		if f.withDockerDepth > 0 {
+			f.withDockerDepth--
+		}
+		f.word(tok.GetText())
+	case parser.EarthLexerWITH_DOCKER:
+		f.word(tok.GetText())
+		f.withDockerDepth++
+	default:
+		f.word(tok.GetText())
+	}
+}
+
+func (f *formatter) word(text string) {
+	if f.line.Len() == 0 {
+		f.line.WriteString(f.indent())
+	} else if f.pendingSpace {
+		f.line.WriteString(" ")
+	}
+	f.pendingSpace = false
+	f.afterEquals = false
+	f.line.WriteString(text)
+}
+
+// continuation writes a WS token containing line continuations (and possibly comments),
+// re-indenting the continued lines.
+func (f *formatter) continuation(text string) {
+	contIndent := f.indent() + formatIndent
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		switch {
+		case i == 0:
+			f.line.WriteString(" ")
+			f.line.WriteString(normalizeComment(l))
+		case i == len(lines)-1:
+			f.line.WriteString("\n")
+			f.line.WriteString(contIndent)
+			f.line.WriteString(l)
+		case l == "":
+		default:
+			f.line.WriteString("\n")
+			f.line.WriteString(contIndent)
+			f.line.WriteString(normalizeComment(l))
+		}
+	}
+	f.pendingSpace = false
+}
+
+// normalizeComment normalizes the spacing between a line continuation and its comment.
+func normalizeComment(l string) string {
+	if strings.HasPrefix(l, "\\") && len(l) > 1 {
+		return "\\ " + strings.TrimSpace(l[1:])
+	}
+	return l
+}
+
+func (f *formatter) flushComments() {
+	for _, c := range f.pendingComments {
+		if f.line.Len() == 0 {
+			if c.GetColumn() != 0 {
+				f.line.WriteString(f.indent())
+			}
+		} else {
+			f.line.WriteString(" ")
+		}
+		f.line.WriteString(c.GetText())
+	}
+	f.pendingComments = nil
+}
+
+func (f *formatter) endLine() {
+	f.flushComments()
+	line := strings.TrimRight(f.line.String(), " \t")
+	f.line.Reset()
+	f.pendingSpace = false
+	f.afterEquals = false
+	if line == "" {
+		if f.started {
+			f.blankLines++
+		}
+		return
+	}
+	if f.blankLines > 0 {
+		f.sb.WriteString("\n")
+	}
+	f.blankLines = 0
+	f.started = true
+	f.sb.WriteString(line)
+	f.sb.WriteString("\n")
+}
+
+func (f *formatter) String() string {
+	if f.line.Len() > 0 {
+		f.endLine()
+	}
+	return f.sb.String()
+}
+
+// sameWords returns whether two token lists contain the same sequence of meaningful tokens
+// (ignoring whitespace and new lines).
+func sameWords(a, b []antlr.Token) bool {
+	wa, wb := meaningfulTokens(a), meaningfulTokens(b)
+	if len(wa) != len(wb) {
+		return false
+	}
+	for i := range wa {
+		if wa[i] != wb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func meaningfulTokens(tokens []antlr.Token) []string {
+	var ret []string
+	for _, tok := range tokens {
+		if tok.GetChannel() != antlr.TokenDefaultChannel {
+			continue
+		}
+		switch tok.GetTokenType() {
+		case parser.EarthLexerWS, parser.EarthLexerNL, antlr.TokenEOF:
+			continue
+		case parser.EarthLexerINDENT, parser.EarthLexerDEDENT:
+			// The text of these is the (re-formatted) indentation.
+			ret = append(ret, fmt.Sprintf("%d", tok.GetTokenType()))
+			continue
+		}
+		ret = append(ret, fmt.Sprintf("%d:%s", tok.GetTokenType(), tok.GetText()))
+	}
+	return ret
+}
@@ -0,0 +1,61 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func formatString(t *testing.T, input string) string {
+	dir, err := ioutil.TempDir("", "earthly-format-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte(input), 0644)
+	NoError(t, err)
+	out, err := Format(file)
+	NoError(t, err)
+	return string(out)
+}
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected string
+	}{
+		{
+			"FROM   alpine:3.13\n\n\n\ntest:\n  RUN   echo  hi\n",
+			"FROM alpine:3.13\n\ntest:\n    RUN echo hi\n",
+		},
+		{
+			"ARG a = b\ntest:\n\tENV  x=y\n\tLABEL k= v\n",
+			"ARG a=b\ntest:\n    ENV x=y\n    LABEL k=v\n",
+		},
+		{
+			"# top\ntest: #the target\n  RUN echo a    # trailing\n# column zero\n  # indented\n",
+			"# top\ntest: #the target\n    RUN echo a # trailing\n# column zero\n    # indented\n",
+		},
+		{
+			"test:\n  RUN echo a && \\\n echo b\n",
+			"test:\n    RUN echo a && \\\n        echo b\n",
+		},
+		{
+			"test:\n  WITH DOCKER\n  RUN docker ps\n  END\n",
+			"test:\n    WITH DOCKER\n        RUN docker ps\n    END\n",
+		},
+		{
+			"test:\n  RUN cat <<EOF   \n   keep   this\nEOF\n",
+			"test:\n    RUN cat <<EOF\n   keep   this\nEOF\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted := formatString(t, tt.input)
+		Equal(t, tt.expected, formatted)
+		// Formatting is idempotent.
+		Equal(t, formatted, formatString(t, formatted))
+	}
+}
@@ -1,6 +1,10 @@
 package earthfile2llb
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
 	"github.com/antlr/antlr4/runtime/Go/antlr"
 	"github.com/earthly/earthly/earthfile2llb/parser"
 )
@@ -14,11 +18,37 @@ type lexer struct {
 	afterNewLine                                 bool
 	tokenQueue                                   []antlr.Token
 	wsChannel, wsStart, wsStop, wsLine, wsColumn int
+	hereDocs                                     []hereDoc
+	preserveComments                             bool
+	// The indentation character (tab or space) used by the current recipe and by the
+	// current line, used to detect recipes mixing tabs and spaces.
+	recipeIndentChar, lineIndentChar rune
 }
 
-func newLexer(input antlr.CharStream) antlr.Lexer {
+// commentChannel is the token channel on which comments are emitted, when the lexer is
+// set to preserve them. The parser only consumes the default channel, so comments
+// never affect parsing. Comment tokens keep the type of the token they were part of
+// (NL or WS) and are told apart by their channel.
+const commentChannel = 2
+
+// hereDoc is a heredoc declared on the current command line (e.g. <<EOF), whose
+// body starts after the end of the line. When multiple heredocs are declared on the same
+// line, their bodies follow one another, in the order of declaration.
+type hereDoc struct {
+	terminator string
+	stripTabs  bool // <<-EOF
+}
+
+var hereDocRegexp = regexp.MustCompile(`^[0-9]*<<(-?)(?:"(\w+)"|'(\w+)'|(\w+))$`)
+
+// newLexer returns a new lexer for the given input. All of the lexer state is held by the
+// returned instance, so separate lexers may be used concurrently, from different goroutines.
+// A single lexer is not safe for concurrent use. If preserveComments is set, comments are
+// emitted as tokens on the comment channel, instead of being discarded.
+func newLexer(input antlr.CharStream, preserveComments bool) antlr.Lexer {
 	l := new(lexer)
 	l.EarthLexer = parser.NewEarthLexer(input)
+	l.preserveComments = preserveComments
 	return l
 }
 
@@ -27,15 +57,55 @@ func (l *lexer) NextToken() antlr.Token {
 	ret := peek
 	switch peek.GetTokenType() {
 	case parser.EarthLexerWS:
+		if l.GetInputStream().LA(1) == antlr.TokenEOF {
+			// Whitespace at the very end of the file (without a final new line) carries no
+			// meaning: it is hidden from the parser and does not count as indentation.
+			peek = l.GetTokenFactory().Create(
+				l.GetTokenSourceCharStreamPair(), parser.EarthLexerWS, peek.GetText(),
+				antlr.TokenHiddenChannel, peek.GetStart(), peek.GetStop(), peek.GetLine(), peek.GetColumn())
+			ret = peek
+			l.queueComments(peek)
+			break
+		}
 		if l.afterNewLine {
+			if l.indentLevel == 0 {
+				l.lineIndentChar = []rune(peek.GetText())[0]
+			}
 			l.indentLevel++
 		}
 		l.wsChannel, l.wsStart, l.wsStop, l.wsLine, l.wsColumn =
 			peek.GetChannel(), peek.GetStart(), peek.GetStop(), peek.GetLine(), peek.GetColumn()
+		l.queueComments(peek)
 	case parser.EarthLexerNL:
 		l.indentLevel = 0
 		l.afterNewLine = true
+		l.queueComments(peek)
+		// The heredoc bodies are emitted as extra words of the command, before the
+		// new line token.
+		for i, hd := range l.hereDocs {
+			if i > 0 {
+				// Skip the new line following the previous terminator.
+				l.consumeNewLine()
+			}
+			l.tokenQueue = append(l.tokenQueue, l.readHereDoc(hd))
+		}
+		l.hereDocs = nil
 	default:
+		if peek.GetTokenType() == parser.EarthLexerAtom {
+			m := hereDocRegexp.FindStringSubmatch(peek.GetText())
+			if m != nil {
+				l.hereDocs = append(l.hereDocs, hereDoc{
+					terminator: m[2] + m[3] + m[4],
+					stripTabs:  m[1] == "-",
+				})
+			}
+		}
+		if peek.GetTokenType() == parser.EarthLexerTarget {
+			l.recipeIndentChar = 0
+		}
+		if l.afterNewLine && l.indentLevel > 0 {
+			l.checkIndentChar()
+		}
 		if l.afterNewLine {
 			if l.prevIndentLevel < l.indentLevel {
 				l.tokenQueue = append(l.tokenQueue, l.GetTokenFactory().Create(
@@ -58,3 +128,108 @@ func (l *lexer) NextToken() antlr.Token {
 	}
 	return ret
 }
+
+// readHereDoc consumes the body of a heredoc directly from the input stream, up to and
+// including the line which exactly matches the terminator. The body is kept verbatim (no
+// quote processing or variable expansion takes place), except for the leading tabs being
+// removed in the <<- form. The returned token text starts with a new line, which is how
+// heredoc bodies are told apart from regular words.
+func (l *lexer) readHereDoc(hd hereDoc) antlr.Token {
+	input := l.GetInputStream()
+	start := input.Index()
+	line, column := l.Interpreter.GetLine(), l.Interpreter.GetCharPositionInLine()
+	var body strings.Builder
+	for {
+		if input.LA(1) == antlr.TokenEOF {
+			l.GetErrorListenerDispatch().SyntaxError(
+				l, nil, line, column,
+				fmt.Sprintf("heredoc not terminated: missing %s", hd.terminator), nil)
+			break
+		}
+		var lineBuilder strings.Builder
+		for input.LA(1) != antlr.TokenEOF && input.LA(1) != '\n' {
+			lineBuilder.WriteRune(rune(input.LA(1)))
+			l.Interpreter.Consume(input)
+		}
+		bodyLine := strings.TrimSuffix(lineBuilder.String(), "\r")
+		if hd.stripTabs {
+			bodyLine = strings.TrimLeft(bodyLine, "\t")
+		}
+		body.WriteString("\n")
+		body.WriteString(bodyLine)
+		if bodyLine == hd.terminator {
+			// The new line following the terminator is left for the regular lexer.
+			break
+		}
+		l.consumeNewLine()
+	}
+	return l.GetTokenFactory().Create(
+		l.GetTokenSourceCharStreamPair(), parser.EarthLexerAtom, body.String(),
+		antlr.TokenDefaultChannel, start, input.Index()-1, line, column)
+}
+
+// checkIndentChar reports an error if the indentation of the current line does not use
+// the same character (tab or space) as the previous lines of the recipe.
+func (l *lexer) checkIndentChar() {
+	if l.recipeIndentChar == 0 {
+		l.recipeIndentChar = l.lineIndentChar
+		return
+	}
+	if l.lineIndentChar == l.recipeIndentChar {
+		return
+	}
+	l.GetErrorListenerDispatch().SyntaxError(
+		l, nil, l.wsLine, l.wsColumn,
+		fmt.Sprintf(
+			"inconsistent indentation: line is indented with %s, while the recipe is indented with %s",
+			indentCharName(l.lineIndentChar), indentCharName(l.recipeIndentChar)), nil)
+}
+
+func indentCharName(c rune) string {
+	if c == '\t' {
+		return "tabs"
+	}
+	return "spaces"
+}
+
+// queueComments queues a token on the comment channel for each comment contained within
+// the given NL or WS token.
+func (l *lexer) queueComments(tok antlr.Token) {
+	if !l.preserveComments {
+		return
+	}
+	text := []rune(tok.GetText())
+	line, column := tok.GetLine(), tok.GetColumn()
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			line++
+			column = 0
+			continue
+		case '#':
+			end := i
+			for end < len(text) && text[end] != '\r' && text[end] != '\n' {
+				end++
+			}
+			l.tokenQueue = append(l.tokenQueue, l.GetTokenFactory().Create(
+				l.GetTokenSourceCharStreamPair(), tok.GetTokenType(), string(text[i:end]),
+				commentChannel, tok.GetStart()+i, tok.GetStart()+end-1, line, column))
+			column += end - i
+			i = end - 1
+			continue
+		}
+		column++
+	}
+}
+
+func (l *lexer) consumeNewLine() {
+	input := l.GetInputStream()
+	if input.LA(1) == '\n' {
+		l.Interpreter.Consume(input)
+	}
+}
+
+// isHereDocBody returns whether a statement word is a heredoc body, as produced by the lexer.
+func isHereDocBody(word string) bool {
+	return strings.HasPrefix(word, "\n")
+}
@@ -0,0 +1,289 @@
+package earthfile2llb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	const numFiles = 8
+	var files []string
+	var expected [][]string
+	for i := 0; i < numFiles; i++ {
+		var targets []string
+		content := "FROM alpine:3.13\n\n"
+		for j := 0; j <= i; j++ {
+			target := fmt.Sprintf("target-%d-%d", i, j)
+			targets = append(targets, target)
+			content += fmt.Sprintf("%s:\n    RUN echo %d\n    RUN cat <<EOF\n%d\nEOF\n\n", target, j, j)
+		}
+		file := filepath.Join(dir, fmt.Sprintf("Earthfile%d", i))
+		err := ioutil.WriteFile(file, []byte(content), 0644)
+		NoError(t, err)
+		files = append(files, file)
+		expected = append(expected, targets)
+	}
+
+	var wg sync.WaitGroup
+	for round := 0; round < 4; round++ {
+		for i := range files {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				targets, err := GetTargets(files[i])
+				NoError(t, err)
+				Equal(t, expected[i], targets)
+			}(i)
+		}
+	}
+	wg.Wait()
+}
+
+func TestGetTargetsInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte("default:\nRUN echo not indented\n"), 0644)
+	NoError(t, err)
+	_, err = GetTargets(file)
+	Error(t, err)
+}
+
+func TestParseWritesNoOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	content := "FROM alpine:3.13\n\n# comment\nbuild:\n    RUN cat <<EOF\nhello\nEOF\n    SAVE ARTIFACT ./out\n"
+	err = ioutil.WriteFile(file, []byte(content), 0644)
+	NoError(t, err)
+
+	r, w, err := os.Pipe()
+	NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	targets, err := GetTargets(file)
+	os.Stdout = stdout
+	w.Close()
+	NoError(t, err)
+	Equal(t, []string{"build"}, targets)
+	out, err := ioutil.ReadAll(r)
+	NoError(t, err)
+	Empty(t, string(out))
+}
+
+func lexComments(input string, preserveComments bool) []antlr.Token {
+	stream := antlr.NewCommonTokenStream(
+		newLexer(antlr.NewInputStream(input), preserveComments), antlr.TokenDefaultChannel)
+	stream.Fill()
+	var comments []antlr.Token
+	for _, tok := range stream.GetAllTokens() {
+		if tok.GetChannel() == commentChannel {
+			comments = append(comments, tok)
+		}
+	}
+	return comments
+}
+
+func TestLexerPreserveComments(t *testing.T) {
+	input := "# header\n" +
+		"FROM alpine:3.13 # the base\n" +
+		"\n" +
+		"test:\n" +
+		"    RUN echo a \\ # after continuation\n" +
+		"        b\n"
+
+	Empty(t, lexComments(input, false))
+
+	comments := lexComments(input, true)
+	var texts []string
+	var positions [][2]int
+	for _, tok := range comments {
+		texts = append(texts, tok.GetText())
+		positions = append(positions, [2]int{tok.GetLine(), tok.GetColumn()})
+	}
+	Equal(t, []string{"# header", "# the base", "# after continuation"}, texts)
+	Equal(t, [][2]int{{1, 0}, {2, 17}, {5, 17}}, positions)
+}
+
+func TestLexerMixedIndentation(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"a:\n\tRUN echo a\n    RUN echo b\n",
+			[]string{"syntax error: line 3:0 inconsistent indentation: line is indented with spaces, while the recipe is indented with tabs"},
+		},
+		{
+			"a:\n    RUN echo a\n    RUN echo b\n\tRUN echo c\n",
+			[]string{"syntax error: line 4:0 inconsistent indentation: line is indented with tabs, while the recipe is indented with spaces"},
+		},
+		{
+			// Each recipe may use its own indentation.
+			"a:\n    RUN echo a\n\nb:\n\tRUN echo b\n",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		errorListener := antlrhandler.NewReturnErrorListener()
+		l := newLexer(antlr.NewInputStream(tt.input), false)
+		l.RemoveErrorListeners()
+		l.AddErrorListener(errorListener)
+		antlr.NewCommonTokenStream(l, antlr.TokenDefaultChannel).Fill()
+		var errs []string
+		for _, err := range errorListener.Errs {
+			errs = append(errs, err.Error())
+		}
+		Equal(t, tt.expected, errs)
+	}
+}
+
+func TestLexerHereDocs(t *testing.T) {
+	input := "test:\n" +
+		"    RUN cat <<EOF\n" +
+		"line 1\n" +
+		"EOF\n" +
+		"    RUN cat <<-END >a 2<<'DATA'\n" +
+		"\tx\n" +
+		"\tEND\n" +
+		"y\n" +
+		"DATA\n" +
+		"    RUN echo done\n"
+	stream := antlr.NewCommonTokenStream(newLexer(antlr.NewInputStream(input), false), antlr.TokenDefaultChannel)
+	stream.Fill()
+	var bodies []string
+	var positions [][2]int
+	var done antlr.Token
+	for _, tok := range stream.GetAllTokens() {
+		if isHereDocBody(tok.GetText()) && tok.GetText() != "\n" {
+			bodies = append(bodies, tok.GetText())
+			positions = append(positions, [2]int{tok.GetLine(), tok.GetColumn()})
+		}
+		if tok.GetText() == "done" {
+			done = tok
+		}
+	}
+	Equal(t, []string{"\nline 1\nEOF", "\nx\nEND", "\ny\nDATA"}, bodies)
+	Equal(t, [][2]int{{3, 0}, {6, 0}, {8, 0}}, positions)
+	NotNil(t, done)
+	Equal(t, [2]int{10, 13}, [2]int{done.GetLine(), done.GetColumn()})
+
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte(input+"\nother:\n    RUN echo other\n"), 0644)
+	NoError(t, err)
+	targets, err := GetTargets(file)
+	NoError(t, err)
+	Equal(t, []string{"test", "other"}, targets)
+}
+
+func TestLexerHereDocNotTerminated(t *testing.T) {
+	errorListener := antlrhandler.NewReturnErrorListener()
+	l := newLexer(antlr.NewInputStream("test:\n    RUN cat <<EOF\nline 1\n    RUN echo done\n"), false)
+	l.RemoveErrorListeners()
+	l.AddErrorListener(errorListener)
+	antlr.NewCommonTokenStream(l, antlr.TokenDefaultChannel).Fill()
+	var errs []string
+	for _, err := range errorListener.Errs {
+		errs = append(errs, err.Error())
+	}
+	Equal(t, []string{"syntax error: line 3:0 heredoc not terminated: missing EOF"}, errs)
+}
+
+func TestParseEmptyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"trailing new line", "\n"},
+		{"comments", "# only\n  # comments\n# here"},
+		{"blank lines", "\n\n    \n\t\n\r\n"},
+		{"whitespace", "  \t"},
+		{"whitespace after new line", "\n    "},
+	}
+	for _, tt := range tests {
+		file := filepath.Join(dir, "Earthfile")
+		err := ioutil.WriteFile(file, []byte(tt.input), 0644)
+		NoError(t, err, tt.name)
+
+		errorListener := antlrhandler.NewReturnErrorListener()
+		errorStrategy := antlrhandler.NewReturnErrorStrategy()
+		tree, err := newEarthfileTree(file, errorListener, errorStrategy)
+		NoError(t, err, tt.name)
+		NoError(t, parseError(file, errorListener, errorStrategy), tt.name)
+		tc := &targetCollector{}
+		antlr.ParseTreeWalkerDefault.Walk(tc, tree)
+		Empty(t, tc.targets, tt.name)
+	}
+}
+
+func TestParseTrailingWhitespace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, input := range []string{
+		"FROM alpine:3.13\n  ",
+		"test:\n    RUN echo a\n    ",
+		"test:\n    RUN echo a\n\t",
+	} {
+		file := filepath.Join(dir, "Earthfile")
+		err := ioutil.WriteFile(file, []byte(input), 0644)
+		NoError(t, err)
+		errorListener := antlrhandler.NewReturnErrorListener()
+		errorStrategy := antlrhandler.NewReturnErrorStrategy()
+		_, err = newEarthfileTree(file, errorListener, errorStrategy)
+		NoError(t, err)
+		NoError(t, parseError(file, errorListener, errorStrategy), "%q", input)
+	}
+}
+
+func TestDebugTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-lexer-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte("FROM alpine:3.13\ntest:\n    RUN echo hi # greet\n"), 0644)
+	NoError(t, err)
+
+	var out bytes.Buffer
+	err = DebugTokens(file, &out)
+	NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	Regexp(t, `^TYPE\s+TEXT\s+LINE\s+COLUMN\s+CHANNEL$`, lines[0])
+	Regexp(t, `^FROM\s+"FROM"\s+1\s+0\s+0$`, lines[1])
+	Regexp(t, `(?m)^INDENT\s+"    "\s+3\s+0\s+0$`, out.String())
+	Contains(t, out.String(), `"# greet"`)
+	Regexp(t, `^EOF\s+`, lines[len(lines)-1])
+
+	err = ioutil.WriteFile(file, []byte("test:\n    RUN echo a\n\tRUN echo b\n"), 0644)
+	NoError(t, err)
+	out.Reset()
+	err = DebugTokens(file, &out)
+	Error(t, err)
+	NotEmpty(t, out.String())
+}
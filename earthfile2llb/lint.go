@@ -0,0 +1,261 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/domain"
+)
+
+// LintWarning is a likely mistake found in an Earthfile.
+type LintWarning struct {
+	// Path is the path of the Earthfile.
+	Path string
+	// Line is the line of the statement the warning is about.
+	Line int
+	// Rule is the name of the rule which found the mistake.
+	Rule    string
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s:%d: %s [%s]", w.Path, w.Line, w.Message, w.Rule)
+}
+
+// lintRule finds a kind of likely mistake in an Earthfile. The warnings it returns are
+// missing their Path and Rule, which are filled in by Lint.
+type lintRule struct {
+	name  string
+	check func(ef *validateCollector) []LintWarning
+}
+
+// lintRules are the rules applied by Lint, unless disabled.
+var lintRules = []lintRule{
+	{name: "apt-install-recommends", check: lintAptInstallRecommends},
+	{name: "missing-workdir", check: lintMissingWorkdir},
+	{name: "unused-arg", check: lintUnusedArgs},
+	{name: "artifact-not-output", check: lintArtifactNotOutput},
+}
+
+// LintRuleNames returns the names of the rules applied by Lint.
+func LintRuleNames() []string {
+	var names []string
+	for _, rule := range lintRules {
+		names = append(names, rule.name)
+	}
+	return names
+}
+
+// Lint checks the given local target like Validate, then returns the likely mistakes found
+// in the Earthfiles checked, sorted by Earthfile and line. The rules named in disabled are
+// not applied.
+func Lint(target domain.Target, disabled []string) ([]LintWarning, error) {
+	skip := make(map[string]bool)
+	for _, name := range disabled {
+		known := false
+		for _, rule := range lintRules {
+			if rule.name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf(
+				"unknown lint rule %s; the known rules are %s", name, strings.Join(LintRuleNames(), ", "))
+		}
+		skip[name] = true
+	}
+	if target.IsRemote() {
+		return nil, fmt.Errorf("only local targets can be linted: %s", target.String())
+	}
+	v := newValidator()
+	err := v.visit(target)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []LintWarning
+	for earthfilePath, ef := range v.earthfiles {
+		for _, rule := range lintRules {
+			if skip[rule.name] {
+				continue
+			}
+			for _, w := range rule.check(ef) {
+				w.Path = earthfilePath
+				w.Rule = rule.name
+				warnings = append(warnings, w)
+			}
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Path != warnings[j].Path {
+			return warnings[i].Path < warnings[j].Path
+		}
+		return warnings[i].Line < warnings[j].Line
+	})
+	return warnings, nil
+}
+
+// sortedTargetNames returns the target names of an Earthfile, with base first.
+func sortedTargetNames(ef *validateCollector) []string {
+	var names []string
+	for name := range ef.deps {
+		if name != "base" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{"base"}, names...)
+}
+
+var aptInstallRegexp = regexp.MustCompile(`\bapt(-get)?\s+(-\S+\s+)*install\b`)
+
+// lintAptInstallRecommends warns about the apt installs which also install the recommended
+// packages, which are rarely needed and make the images bigger.
+func lintAptInstallRecommends(ef *validateCollector) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedTargetNames(ef) {
+		for _, stmt := range ef.stmts[name] {
+			if stmt.keyword != "RUN" {
+				continue
+			}
+			cmd := strings.Join(stmt.words, " ")
+			if aptInstallRegexp.MatchString(cmd) && !strings.Contains(cmd, "--no-install-recommends") {
+				warnings = append(warnings, LintWarning{
+					Line:    stmt.line,
+					Message: "apt-get install without --no-install-recommends also installs the recommended packages",
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// lintMissingWorkdir warns about the COPY commands to a relative path in a target which has
+// not set a WORKDIR, as the files then end up relative to the root of the image. The
+// targets starting from another target inherit its WORKDIR, so they are assumed to have
+// one.
+func lintMissingWorkdir(ef *validateCollector) []LintWarning {
+	var warnings []LintWarning
+	baseHasWorkdir := false
+	for _, name := range sortedTargetNames(ef) {
+		hasWorkdir := baseHasWorkdir
+		for _, stmt := range ef.stmts[name] {
+			switch stmt.keyword {
+			case "FROM":
+				hasWorkdir = strings.Contains(strings.Join(stmt.words, " "), "+")
+			case "WORKDIR", "LOCALLY", "FROM DOCKERFILE":
+				hasWorkdir = true
+			case "COPY":
+				if hasWorkdir || len(stmt.words) < 2 {
+					continue
+				}
+				dest := stmt.words[len(stmt.words)-1]
+				if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "$") {
+					continue
+				}
+				warnings = append(warnings, LintWarning{
+					Line:    stmt.line,
+					Message: fmt.Sprintf("COPY to the relative path %s without a WORKDIR copies relative to the root of the image", dest),
+				})
+				// Only the first such COPY of a target is reported.
+				hasWorkdir = true
+			}
+		}
+		if name == "base" {
+			baseHasWorkdir = hasWorkdir
+		}
+	}
+	return warnings
+}
+
+// lintUnusedArgs warns about the ARGs which are not referenced by the recipe declaring them.
+// The ARGs of the base recipe are global, so they may be referenced by any recipe.
+func lintUnusedArgs(ef *validateCollector) []LintWarning {
+	var warnings []LintWarning
+	for _, name := range sortedTargetNames(ef) {
+		for i, stmt := range ef.stmts[name] {
+			if stmt.keyword != "ARG" || stmt.envArgKey == "" {
+				continue
+			}
+			ref := regexp.MustCompile(
+				`(\$\{?|--build-arg[= ])` + regexp.QuoteMeta(stmt.envArgKey) + `([^A-Za-z0-9_]|$)`)
+			used := false
+			for otherName, otherStmts := range ef.stmts {
+				if otherName != name && name != "base" {
+					continue
+				}
+				for j, other := range otherStmts {
+					if otherName == name && i == j {
+						continue
+					}
+					if ref.MatchString(other.text) {
+						used = true
+						break
+					}
+				}
+				if used {
+					break
+				}
+			}
+			if !used {
+				warnings = append(warnings, LintWarning{
+					Line:    stmt.line,
+					Message: fmt.Sprintf("ARG %s is declared but never used", stmt.envArgKey),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// lintArtifactNotOutput warns about the targets which save artifacts, none of them AS LOCAL,
+// and which are not referenced by another target of the Earthfile: such targets seem
+// intended to be built directly for their output, but building them writes nothing.
+func lintArtifactNotOutput(ef *validateCollector) []LintWarning {
+	referenced := make(map[string]bool)
+	for _, refs := range ef.deps {
+		for _, ref := range refs {
+			target, err := domain.ParseTarget(ref)
+			if err != nil {
+				continue
+			}
+			if strings.Contains(target.Target, "$") {
+				// The references via build args are unknown.
+				return nil
+			}
+			if target.IsLocalInternal() {
+				referenced[target.Target] = true
+			}
+		}
+	}
+	var warnings []LintWarning
+	for _, name := range sortedTargetNames(ef) {
+		if name == "base" || referenced[name] {
+			continue
+		}
+		firstSave := 0
+		saveAsLocal := false
+		for _, stmt := range ef.stmts[name] {
+			if stmt.keyword != "SAVE ARTIFACT" {
+				continue
+			}
+			if firstSave == 0 {
+				firstSave = stmt.line
+			}
+			if strings.Contains(" "+strings.Join(stmt.words, " ")+" ", " AS LOCAL ") {
+				saveAsLocal = true
+			}
+		}
+		if firstSave != 0 && !saveAsLocal {
+			warnings = append(warnings, LintWarning{
+				Line: firstSave,
+				Message: fmt.Sprintf(
+					"target %s saves artifacts without AS LOCAL and is not referenced in this Earthfile, so building it outputs nothing",
+					name),
+			})
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,88 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	var tests = []struct {
+		name      string
+		earthfile string
+		target    string
+		disabled  []string
+		warnings  []string
+	}{
+		{
+			name:      "clean",
+			earthfile: "FROM alpine:3.13\nWORKDIR /app\nARG VERSION=1\n\nbuild:\n    RUN apt-get install --no-install-recommends -y git\n    COPY go.mod .\n    RUN echo $VERSION\n    SAVE ARTIFACT out AS LOCAL out\n",
+		},
+		{
+			name:      "apt install",
+			earthfile: "FROM debian:buster\n\nbuild:\n    RUN apt-get update && apt-get -y install git\n    RUN apt install curl\n",
+			warnings: []string{
+				"Earthfile:4: apt-get install without --no-install-recommends also installs the recommended packages [apt-install-recommends]",
+				"Earthfile:5: apt-get install without --no-install-recommends also installs the recommended packages [apt-install-recommends]",
+			},
+		},
+		{
+			name:      "missing workdir",
+			target:    "own",
+			earthfile: "FROM alpine:3.13\n\ndeps:\n    COPY go.mod .\n    COPY go.sum ./\n\nabs:\n    COPY go.mod /app/\n\nchild:\n    FROM +deps\n    COPY main.go .\n\nown:\n    FROM golang:1.16\n    WORKDIR /src\n    COPY main.go .\n",
+			warnings: []string{
+				"Earthfile:4: COPY to the relative path . without a WORKDIR copies relative to the root of the image [missing-workdir]",
+			},
+		},
+		{
+			name:      "unused args",
+			earthfile: "FROM alpine:3.13\nARG GLOBAL\nARG UNUSED_GLOBAL\n\nbuild:\n    ARG NAME=x\n    ARG USED=${NAME}\n    ARG PASSED\n    ARG UNUSED\n    RUN echo $GLOBAL $USED\n    BUILD --build-arg PASSED +other\n\nother:\n    ARG PASSED\n    RUN echo $PASSED\n",
+			warnings: []string{
+				"Earthfile:3: ARG UNUSED_GLOBAL is declared but never used [unused-arg]",
+				"Earthfile:9: ARG UNUSED is declared but never used [unused-arg]",
+			},
+		},
+		{
+			name:      "artifact not output",
+			target:    "local",
+			earthfile: "FROM alpine:3.13\n\ndep:\n    SAVE ARTIFACT /etc/os-release\n\nbuild:\n    COPY +dep/os-release /\n    SAVE ARTIFACT /etc/hostname\n    SAVE ARTIFACT /etc/hosts\n\nlocal:\n    SAVE ARTIFACT /etc/os-release AS LOCAL out\n",
+			warnings: []string{
+				"Earthfile:8: target build saves artifacts without AS LOCAL and is not referenced in this Earthfile, so building it outputs nothing [artifact-not-output]",
+			},
+		},
+		{
+			name:      "disabled rules",
+			earthfile: "FROM debian:buster\nARG UNUSED\n\nbuild:\n    RUN apt-get install -y git\n",
+			disabled:  []string{"apt-install-recommends", "unused-arg"},
+		},
+	}
+	dir, err := ioutil.TempDir("", "earthly-lint-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			NoError(t, ioutil.WriteFile("Earthfile", []byte(tt.earthfile), 0644))
+			target := tt.target
+			if target == "" {
+				target = "build"
+			}
+			warnings, err := Lint(domain.Target{LocalPath: ".", Target: target}, tt.disabled)
+			NoError(t, err)
+			var actual []string
+			for _, w := range warnings {
+				actual = append(actual, w.String())
+			}
+			Equal(t, tt.warnings, actual)
+		})
+	}
+
+	_, err = Lint(domain.Target{LocalPath: ".", Target: "build"}, []string{"no-such-rule"})
+	Error(t, err)
+}
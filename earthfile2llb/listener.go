@@ -179,7 +179,7 @@ func (l *listener) ExitFromDockerfileStmt(c *parser.FromDockerfileStmtContext) {
 	fs.Var(buildArgs, "build-arg", "A build arg override passed on to a referenced Earthly target and also to the Dockerfile build")
 	platformStr := fs.String("platform", "", "The platform to use")
 	dfTarget := fs.String("target", "", "The Dockerfile target to inherit from")
-	dfPath := fs.String("f", "", "Not supported")
+	dfPath := fs.String("f", "", "The path of the Dockerfile, relative to the build context")
 	err := fs.Parse(l.stmtWords)
 	if err != nil {
 		l.err = errors.Wrapf(err, "invalid FROM DOCKERFILE arguments %v", l.stmtWords)
@@ -506,7 +506,10 @@ func (l *listener) ExitSaveImage(c *parser.SaveImageContext) {
 		imageNames[i] = l.expandArgs(img, false)
 	}
 	if len(imageNames) == 0 && !*cacheHint && len(cacheFrom.Args) == 0 {
-		fmt.Printf("Deprecation: using SAVE IMAGE with no arguments is no longer necessary and can be safely removed\n")
+		err = l.deprecated("using SAVE IMAGE with no arguments is no longer necessary and can be safely removed")
+		if err != nil {
+			l.err = err
+		}
 		return
 	}
 	err = l.converter.SaveImage(l.ctx, imageNames, *pushFlag, *insecure, *cacheHint, cacheFrom.Args)
@@ -961,9 +964,31 @@ func (l *listener) ExitGenericCommandStmt(c *parser.GenericCommandStmtContext) {
 	if l.shouldSkip() {
 		return
 	}
+	if c.CommandName().GetText() == "VERSION" {
+		l.version()
+		return
+	}
 	l.err = fmt.Errorf("invalid command %s", c.GetText())
 }
 
+// version applies a VERSION command, which declares the earthly version required by the
+// Earthfile. The lexer does not have a dedicated token for it: it is parsed as a generic
+// command.
+func (l *listener) version() {
+	if l.currentTarget != "base" {
+		l.err = errors.New("VERSION is only allowed at the top of the Earthfile, before the targets")
+		return
+	}
+	// The feature flags are applied to the conversion of every target of the Earthfile,
+	// via getFeatures.
+	version, _, err := parseVersionArgs(l.stmtWords)
+	if err != nil {
+		l.err = err
+		return
+	}
+	l.err = checkEarthlyVersion(version, l.converter.opt.EarthlyVersion)
+}
+
 //
 // Variables.
 
@@ -1017,13 +1042,33 @@ func (l *listener) EnterStmtWord(c *parser.StmtWordContext) {
 	if l.shouldSkip() {
 		return
 	}
-	l.stmtWords = append(l.stmtWords, replaceEscape(c.GetText()))
+	word := c.GetText()
+	if !isHereDocBody(word) {
+		word = replaceEscape(word)
+	} else if len(l.stmtWords) > 0 && isHereDocBody(l.stmtWords[len(l.stmtWords)-1]) {
+		// Consecutive heredoc bodies are kept together, as the words are later joined
+		// by spaces, which would otherwise break the terminator lines.
+		l.stmtWords[len(l.stmtWords)-1] += word
+		return
+	}
+	l.stmtWords = append(l.stmtWords, word)
 }
 
 func (l *listener) shouldSkip() bool {
 	return l.err != nil || l.currentTarget != l.executeTarget
 }
 
+// deprecated reports the use of a deprecated command: as a warning, or as an error if
+// --strict is set.
+func (l *listener) deprecated(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if l.converter.opt.Strict {
+		return errors.Errorf("%s (failing due to --strict)", msg)
+	}
+	l.converter.opt.Console.Warnf("Deprecation: %s\n", msg)
+	return nil
+}
+
 func (l *listener) expandArgs(word string, keepPlusEscape bool) string {
 	ret := l.converter.ExpandArgs(escapeSlashPlus(word))
 	if keepPlusEscape {
@@ -0,0 +1,34 @@
+package earthfile2llb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/earthly/earthly/conslogging"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestListenerDeprecated(t *testing.T) {
+	var tests = []struct {
+		strict         bool
+		expectedErr    string
+		expectedStderr string
+	}{
+		{false, "", "Deprecation: SAVE IMAGE is deprecated\n"},
+		{true, "SAVE IMAGE is deprecated (failing due to --strict)", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		console := conslogging.New(&stdout, &stderr, conslogging.NoColor, conslogging.DefaultPadding)
+		l := &listener{converter: &Converter{opt: ConvertOpt{Console: console, Strict: tt.strict}}}
+		err := l.deprecated("%s is deprecated", "SAVE IMAGE")
+		if tt.expectedErr == "" {
+			NoError(t, err)
+		} else {
+			EqualError(t, err, tt.expectedErr)
+		}
+		Equal(t, tt.expectedStderr, stderr.String())
+		Empty(t, stdout.String())
+	}
+}
@@ -0,0 +1,265 @@
+package earthfile2llb
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+	"github.com/pkg/errors"
+)
+
+// PlanStep is a command that would be executed by a build.
+type PlanStep struct {
+	Target domain.Target
+	// Command is the command as written in the Earthfile, without heredoc bodies. It is
+	// empty for the remote targets, which are not expanded.
+	Command string
+	// Line is the line of the command in the Earthfile.
+	Line int
+}
+
+// GetBuildPlan returns the commands that a build of the given local target would execute,
+// in order: the commands of a referenced target (via FROM, COPY or BUILD) come before the
+// command referencing it, and the base recipe of an Earthfile comes before its targets.
+// Each target is listed once. The plan is worked out from the Earthfiles alone, so the
+// references which use build args cannot be followed, and remote targets are listed
+// without their commands.
+func GetBuildPlan(target domain.Target) ([]PlanStep, error) {
+	if target.IsRemote() {
+		return nil, fmt.Errorf("a build plan is only available for local targets: %s", target.String())
+	}
+	p := &planner{
+		recipes: make(map[string]map[string][]planStmt),
+		visited: make(map[string]bool),
+	}
+	err := p.expand(target)
+	if err != nil {
+		return nil, err
+	}
+	return p.steps, nil
+}
+
+// planStmt is a statement of a recipe.
+type planStmt struct {
+	line    int
+	keyword string
+	command string
+	words   []string
+}
+
+type planner struct {
+	// recipes holds the statements of each target, by Earthfile path and target name.
+	recipes map[string]map[string][]planStmt
+	visited map[string]bool
+	steps   []PlanStep
+}
+
+func (p *planner) expand(target domain.Target) error {
+	key := target.StringCanonical()
+	if p.visited[key] {
+		return nil
+	}
+	p.visited[key] = true
+	if target.IsRemote() {
+		p.steps = append(p.steps, PlanStep{Target: target})
+		return nil
+	}
+	earthfilePath := filepath.Join(filepath.FromSlash(target.LocalPath), "Earthfile")
+	recipes, err := p.getRecipes(earthfilePath)
+	if err != nil {
+		return err
+	}
+	stmts, found := recipes[target.Target]
+	if !found {
+		return fmt.Errorf("target %s not defined in %s", target.Target, earthfilePath)
+	}
+	if target.Target != "base" {
+		baseTarget := target
+		baseTarget.Target = "base"
+		err = p.expand(baseTarget)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		for _, ref := range planTargetRefs(stmt.keyword, stmt.words) {
+			refTarget, err := domain.JoinTargets(target, ref)
+			if err != nil {
+				return errors.Wrapf(err, "join targets %s and %s", target.String(), ref.String())
+			}
+			err = p.expand(refTarget)
+			if err != nil {
+				return err
+			}
+		}
+		p.steps = append(p.steps, PlanStep{
+			Target:  target,
+			Command: stmt.command,
+			Line:    stmt.line,
+		})
+	}
+	return nil
+}
+
+func (p *planner) getRecipes(earthfilePath string) (map[string][]planStmt, error) {
+	if recipes, found := p.recipes[earthfilePath]; found {
+		return recipes, nil
+	}
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(earthfilePath, errorListener, errorStrategy)
+	if err != nil {
+		return nil, errors.Wrap(err, "new earthfile tree")
+	}
+	err = parseError(earthfilePath, errorListener, errorStrategy)
+	if err != nil {
+		return nil, err
+	}
+	pc := &planCollector{
+		currentTarget: "base",
+		recipes:       map[string][]planStmt{"base": nil},
+	}
+	antlr.ParseTreeWalkerDefault.Walk(pc, tree)
+	p.recipes[earthfilePath] = pc.recipes
+	return pc.recipes, nil
+}
+
+// planTargetRefs returns the targets referenced by a statement, which need to be built
+// before it. The references containing variables are skipped, as their value is only
+// known during the build.
+func planTargetRefs(keyword string, words []string) []domain.Target {
+	fs := flag.NewFlagSet(keyword, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	buildArgs := new(StringSliceFlag)
+	fs.Var(buildArgs, "build-arg", "")
+	platforms := new(StringSliceFlag)
+	fs.Var(platforms, "platform", "")
+	var srcs []string
+	switch keyword {
+	case "FROM", "BUILD":
+		if fs.Parse(words) != nil || fs.NArg() != 1 {
+			return nil
+		}
+		srcs = fs.Args()
+	case "COPY":
+		fs.String("from", "", "")
+		fs.Bool("dir", false, "")
+		fs.String("chown", "", "")
+		fs.Bool("keep-ts", false, "")
+		fs.Bool("keep-own", false, "")
+		fs.Bool("if-exists", false, "")
+		if fs.Parse(words) != nil || fs.NArg() < 2 {
+			return nil
+		}
+		srcs = fs.Args()[:fs.NArg()-1]
+	default:
+		return nil
+	}
+	var refs []domain.Target
+	for _, src := range srcs {
+		if !strings.Contains(src, "+") || strings.Contains(src, "$") {
+			continue
+		}
+		if keyword == "COPY" {
+			artifact, err := domain.ParseArtifact(src)
+			if err != nil {
+				continue
+			}
+			refs = append(refs, artifact.Target)
+			continue
+		}
+		target, err := domain.ParseTarget(src)
+		if err != nil {
+			continue
+		}
+		refs = append(refs, target)
+	}
+	return refs
+}
+
+type planCollector struct {
+	*parser.BaseEarthParserListener
+	currentTarget string
+	stmtWords     []string
+	// The ARG and ENV statements have a key and a value rather than words, and the LABEL
+	// statements have key=value pairs.
+	envArgKey      string
+	envArgValue    string
+	envArgHasValue bool
+	labels         []string
+	recipes        map[string][]planStmt
+}
+
+func (pc *planCollector) EnterTargetHeader(c *parser.TargetHeaderContext) {
+	pc.currentTarget = strings.TrimSuffix(c.GetText(), ":")
+	if _, found := pc.recipes[pc.currentTarget]; !found {
+		pc.recipes[pc.currentTarget] = nil
+	}
+}
+
+func (pc *planCollector) EnterStmt(c *parser.StmtContext) {
+	pc.stmtWords = nil
+	pc.envArgKey = ""
+	pc.envArgValue = ""
+	pc.envArgHasValue = false
+	pc.labels = nil
+}
+
+func (pc *planCollector) EnterEnvStmt(c *parser.EnvStmtContext) {
+	pc.envArgHasValue = c.EQUALS() != nil
+}
+
+func (pc *planCollector) EnterArgStmt(c *parser.ArgStmtContext) {
+	pc.envArgHasValue = c.EQUALS() != nil
+}
+
+func (pc *planCollector) EnterEnvArgKey(c *parser.EnvArgKeyContext) {
+	pc.envArgKey = c.GetText()
+}
+
+func (pc *planCollector) EnterEnvArgValue(c *parser.EnvArgValueContext) {
+	pc.envArgValue = c.GetText()
+	pc.envArgHasValue = true
+}
+
+func (pc *planCollector) EnterLabelKey(c *parser.LabelKeyContext) {
+	pc.labels = append(pc.labels, c.GetText())
+}
+
+func (pc *planCollector) EnterLabelValue(c *parser.LabelValueContext) {
+	pc.labels[len(pc.labels)-1] += "=" + c.GetText()
+}
+
+func (pc *planCollector) EnterStmtWord(c *parser.StmtWordContext) {
+	pc.stmtWords = append(pc.stmtWords, replaceEscape(c.GetText()))
+}
+
+func (pc *planCollector) ExitStmt(c *parser.StmtContext) {
+	keyword := c.GetStart().GetText()
+	parts := []string{keyword}
+	for _, word := range pc.stmtWords {
+		if !isHereDocBody(word) {
+			parts = append(parts, word)
+		}
+	}
+	if pc.envArgKey != "" {
+		if pc.envArgHasValue {
+			parts = append(parts, pc.envArgKey+"="+pc.envArgValue)
+		} else {
+			parts = append(parts, pc.envArgKey)
+		}
+	}
+	parts = append(parts, pc.labels...)
+	pc.recipes[pc.currentTarget] = append(pc.recipes[pc.currentTarget], planStmt{
+		line:    c.GetStart().GetLine(),
+		keyword: keyword,
+		command: strings.Join(parts, " "),
+		words:   pc.stmtWords,
+	})
+}
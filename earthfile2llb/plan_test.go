@@ -0,0 +1,90 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGetBuildPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-plan-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "Earthfile"), []byte(`FROM alpine:3.13
+WORKDIR /app
+ARG VERSION=1.0
+ARG EMPTY=
+ARG REQUIRED
+ENV GREETING hello world
+ENV NAME=earthly
+LABEL org.opencontainers.image.version=$VERSION maintainer=me
+
+deps:
+    COPY go.mod .
+
+build:
+    FROM +deps
+    COPY ./lib+lib/out lib/
+    RUN --mount=type=cache,target=/cache cat <<EOF
+hello
+EOF
+    SAVE ARTIFACT out
+
+all:
+    BUILD +build
+    BUILD --platform linux/amd64 +deps
+    BUILD github.com/earthly/hello-world:main+hello
+    COPY +$TARGET/out .
+`), 0644)
+	NoError(t, err)
+	NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0755))
+	err = ioutil.WriteFile(filepath.Join(dir, "lib", "Earthfile"), []byte(`lib:
+    FROM alpine:3.13
+    SAVE ARTIFACT /etc/os-release out
+`), 0644)
+	NoError(t, err)
+
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	steps, err := GetBuildPlan(domain.Target{LocalPath: ".", Target: "all"})
+	NoError(t, err)
+	var actual []string
+	for _, step := range steps {
+		actual = append(actual, step.Target.Target+": "+step.Command)
+	}
+	Equal(t, []string{
+		"base: FROM alpine:3.13",
+		"base: WORKDIR /app",
+		"base: ARG VERSION=1.0",
+		"base: ARG EMPTY=",
+		"base: ARG REQUIRED",
+		"base: ENV GREETING=hello world",
+		"base: ENV NAME=earthly",
+		"base: LABEL org.opencontainers.image.version=$VERSION maintainer=me",
+		"deps: COPY go.mod .",
+		"build: FROM +deps",
+		"lib: FROM alpine:3.13",
+		"lib: SAVE ARTIFACT /etc/os-release out",
+		"build: COPY ./lib+lib/out lib/",
+		"build: RUN --mount=type=cache,target=/cache cat <<EOF",
+		"build: SAVE ARTIFACT out",
+		"all: BUILD +build",
+		"all: BUILD --platform linux/amd64 +deps",
+		"hello: ",
+		"all: BUILD github.com/earthly/hello-world:main+hello",
+		"all: COPY +$TARGET/out .",
+	}, actual)
+	Equal(t, 11, steps[8].Line)
+	Equal(t, "./lib", steps[10].Target.LocalPath)
+	True(t, steps[17].Target.IsRemote())
+
+	_, err = GetBuildPlan(domain.Target{LocalPath: ".", Target: "missing"})
+	Error(t, err)
+}
@@ -12,10 +12,10 @@ import (
 	"github.com/pkg/errors"
 )
 
-func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext llb.State) ([]llb.RunOption, error) {
+func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, cacheContext llb.State, opt ConvertOpt) ([]llb.RunOption, error) {
 	var runOpts []llb.RunOption
 	for _, mount := range mounts {
-		mountRunOpts, err := parseMount(mount, target, ti, cacheContext)
+		mountRunOpts, err := parseMount(mount, target, ti, cacheContext, opt)
 		if err != nil {
 			return nil, errors.Wrap(err, "parse mount")
 		}
@@ -24,14 +24,14 @@ func parseMounts(mounts []string, target domain.Target, ti dedup.TargetInput, ca
 	return runOpts, nil
 }
 
-func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext llb.State) ([]llb.RunOption, error) {
+func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheContext llb.State, opt ConvertOpt) ([]llb.RunOption, error) {
 	var state llb.State
 	var mountSource string
 	var mountTarget string
 	var mountID string
 	var mountType string
 	var mountOpts []llb.MountOption
-	sharingMode := llb.CacheMountShared
+	sharingMode := opt.CacheMountSharing
 	kvPairs := strings.Split(mount, ",")
 	for _, kvPair := range kvPairs {
 		kvSplit := strings.SplitN(kvPair, "=", 2)
@@ -137,6 +137,10 @@ func parseMount(mount string, target domain.Target, ti dedup.TargetInput, cacheC
 		if mountTarget == "" {
 			return nil, fmt.Errorf("mount target not specified")
 		}
+		if opt.NoCacheMounts {
+			// Mount an empty directory, which is discarded after the command.
+			return []llb.RunOption{llb.AddMount(mountTarget, llbutil.ScratchWithPlatform(), mountOpts...)}, nil
+		}
 		key, err := cacheKeyTargetInput(ti)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,183 @@
+package earthfile2llb
+
+import (
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+	"github.com/pkg/errors"
+)
+
+// TargetInfo holds the documentation of a target of an Earthfile.
+type TargetInfo struct {
+	// Doc is the comment directly preceding the target declaration.
+	Doc string
+	// Args are the build args declared by the target, in order of declaration.
+	Args []TargetArg
+	// HasOutputs is set if the target produces outputs of its own (via SAVE ARTIFACT,
+	// SAVE IMAGE or RUN --push), or triggers the build of other targets (via BUILD).
+	// Targets without outputs (base targets) are either referenced by other targets, or built
+	// for the side effects of their commands, such as running tests.
+	HasOutputs bool
+}
+
+// IsBase returns true if the target produces no outputs.
+func (ti *TargetInfo) IsBase() bool {
+	return !ti.HasOutputs
+}
+
+// TargetArg is a build arg declared by a target.
+type TargetArg struct {
+	Name string
+	// DefaultValue is the default value, as written in the Earthfile.
+	DefaultValue string
+	HasDefault   bool
+	// Doc is the comment directly preceding the ARG declaration.
+	Doc string
+}
+
+// GetTargetInfo returns the documentation, the build args and the outputs of each target of
+// an Earthfile, keyed by target name. The args declared before the first target are keyed
+// under "base".
+func GetTargetInfo(filename string) (map[string]*TargetInfo, error) {
+	comments, err := getLineComments(filename)
+	if err != nil {
+		return nil, err
+	}
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, errors.Wrap(err, "new earthfile tree")
+	}
+	err = parseError(filename, errorListener, errorStrategy)
+	if err != nil {
+		return nil, err
+	}
+	tc := &targetInfoCollector{
+		comments:      comments,
+		currentTarget: "base",
+		infos:         map[string]*TargetInfo{"base": {}},
+	}
+	antlr.ParseTreeWalkerDefault.Walk(tc, tree)
+	return tc.infos, nil
+}
+
+// getLineComments returns the text of the comments which occupy a whole line of the
+// Earthfile, keyed by line number.
+func getLineComments(filename string) (map[int]string, error) {
+	// The lexer errors are reported when parsing the Earthfile.
+	stream, err := newEarthfileTokenStream(filename, true, antlrhandler.NewReturnErrorListener())
+	if err != nil {
+		return nil, err
+	}
+	stream.Fill()
+	comments := make(map[int]string)
+	codeLines := make(map[int]bool)
+	for _, tok := range stream.GetAllTokens() {
+		if tok.GetChannel() == commentChannel {
+			comments[tok.GetLine()] = strings.TrimSpace(strings.TrimPrefix(tok.GetText(), "#"))
+			continue
+		}
+		switch tok.GetTokenType() {
+		case antlr.TokenEOF, parser.EarthLexerNL, parser.EarthLexerWS,
+			parser.EarthLexerINDENT, parser.EarthLexerDEDENT:
+			// The NL and WS tokens contain the comments themselves.
+		default:
+			codeLines[tok.GetLine()] = true
+		}
+	}
+	for line := range codeLines {
+		// Trailing comment.
+		delete(comments, line)
+	}
+	return comments, nil
+}
+
+type targetInfoCollector struct {
+	*parser.BaseEarthParserListener
+	comments      map[int]string
+	currentTarget string
+	stmtWords     []string
+	arg           TargetArg
+	infos         map[string]*TargetInfo
+}
+
+// docBefore returns the block of comment lines directly above the given line.
+func (tc *targetInfoCollector) docBefore(line int) string {
+	var lines []string
+	for l := line - 1; ; l-- {
+		comment, found := tc.comments[l]
+		if !found {
+			break
+		}
+		lines = append([]string{comment}, lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (tc *targetInfoCollector) EnterTargetHeader(c *parser.TargetHeaderContext) {
+	tc.currentTarget = strings.TrimSuffix(c.GetText(), ":")
+	if _, found := tc.infos[tc.currentTarget]; !found {
+		tc.infos[tc.currentTarget] = &TargetInfo{
+			Doc: tc.docBefore(c.GetStart().GetLine()),
+		}
+	}
+}
+
+func (tc *targetInfoCollector) EnterStmt(c *parser.StmtContext) {
+	tc.stmtWords = nil
+}
+
+func (tc *targetInfoCollector) EnterStmtWord(c *parser.StmtWordContext) {
+	tc.stmtWords = append(tc.stmtWords, replaceEscape(c.GetText()))
+}
+
+func (tc *targetInfoCollector) EnterSaveArtifact(c *parser.SaveArtifactContext) {
+	tc.infos[tc.currentTarget].HasOutputs = true
+}
+
+func (tc *targetInfoCollector) EnterSaveImage(c *parser.SaveImageContext) {
+	tc.infos[tc.currentTarget].HasOutputs = true
+}
+
+func (tc *targetInfoCollector) EnterBuildStmt(c *parser.BuildStmtContext) {
+	tc.infos[tc.currentTarget].HasOutputs = true
+}
+
+func (tc *targetInfoCollector) ExitRunStmt(c *parser.RunStmtContext) {
+	for i := 0; i < len(tc.stmtWords); i++ {
+		word := tc.stmtWords[i]
+		if !strings.HasPrefix(word, "-") {
+			break
+		}
+		switch word {
+		case "--push", "--push=true":
+			tc.infos[tc.currentTarget].HasOutputs = true
+		case "--secret", "--mount":
+			// The value is the next word.
+			i++
+		}
+	}
+}
+
+func (tc *targetInfoCollector) EnterArgStmt(c *parser.ArgStmtContext) {
+	tc.arg = TargetArg{
+		Doc:        tc.docBefore(c.GetStart().GetLine()),
+		HasDefault: c.EQUALS() != nil,
+	}
+}
+
+func (tc *targetInfoCollector) EnterEnvArgKey(c *parser.EnvArgKeyContext) {
+	tc.arg.Name = c.GetText()
+}
+
+func (tc *targetInfoCollector) EnterEnvArgValue(c *parser.EnvArgValueContext) {
+	tc.arg.DefaultValue = c.GetText()
+}
+
+func (tc *targetInfoCollector) ExitArgStmt(c *parser.ArgStmtContext) {
+	info := tc.infos[tc.currentTarget]
+	info.Args = append(info.Args, tc.arg)
+}
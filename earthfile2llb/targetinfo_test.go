@@ -0,0 +1,74 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestGetTargetInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-args-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte(`FROM alpine:3.13
+# The version to release.
+ARG VERSION
+
+# Builds the app.
+# Requires the network.
+build:
+    RUN echo build # not a doc
+    # The output directory.
+    ARG OUT_DIR=/out
+    SAVE ARTIFACT ./out AS LOCAL out
+
+test:
+    RUN echo test
+
+release:
+    RUN --secret TOKEN=+secrets/token --push ./release.sh
+
+all:
+    BUILD +build
+`), 0644)
+	NoError(t, err)
+
+	infos, err := GetTargetInfo(file)
+	NoError(t, err)
+	Equal(t, map[string]*TargetInfo{
+		"base": {
+			Args: []TargetArg{
+				{Name: "VERSION", Doc: "The version to release."},
+			},
+		},
+		"build": {
+			Doc: "Builds the app.\nRequires the network.",
+			Args: []TargetArg{
+				{Name: "OUT_DIR", DefaultValue: "/out", HasDefault: true, Doc: "The output directory."},
+			},
+			HasOutputs: true,
+		},
+		"test":    {},
+		"release": {HasOutputs: true},
+		"all":     {HasOutputs: true},
+	}, infos)
+	True(t, infos["test"].IsBase())
+	False(t, infos["all"].IsBase())
+}
+
+func TestGetTargetInfoSyntaxError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-args-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte("FROM alpine:3.13\n\nbuild:\n    RUN true\n  bad indent\n"), 0644)
+	NoError(t, err)
+
+	_, err = GetTargetInfo(file)
+	Error(t, err)
+	Contains(t, err.Error(), "syntax error")
+}
@@ -0,0 +1,161 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/earthfile2llb/antlrhandler"
+	"github.com/earthly/earthly/earthfile2llb/parser"
+	"github.com/pkg/errors"
+)
+
+// Validate checks statically that a build of the given local target could be started:
+// that the Earthfiles of the target and of the local targets it references (via FROM,
+// FROM DOCKERFILE, COPY, BUILD and WITH DOCKER --load) parse, that the referenced targets
+// are defined and that the commands are known. It does not need buildkit: the Earthfiles
+// are not converted to LLB, so the errors which depend on the images, on the build context
+// or on the values of the build args are not detected. The references which use build
+// args are not followed, and remote targets are not fetched.
+func Validate(target domain.Target) error {
+	if target.IsRemote() {
+		return fmt.Errorf("only local targets can be validated: %s", target.String())
+	}
+	return newValidator().visit(target)
+}
+
+func newValidator() *validator {
+	return &validator{
+		earthfiles: make(map[string]*validateCollector),
+		visited:    make(map[string]bool),
+	}
+}
+
+type validator struct {
+	// earthfiles holds the parsed Earthfiles, by path.
+	earthfiles map[string]*validateCollector
+	visited    map[string]bool
+}
+
+func (v *validator) visit(target domain.Target) error {
+	key := target.StringCanonical()
+	if v.visited[key] || target.IsRemote() ||
+		strings.Contains(target.LocalPath, "$") || strings.Contains(target.Target, "$") {
+		return nil
+	}
+	v.visited[key] = true
+	earthfilePath := filepath.Join(filepath.FromSlash(target.LocalPath), "Earthfile")
+	vc, err := v.parse(earthfilePath)
+	if err != nil {
+		return err
+	}
+	refs, found := vc.deps[target.Target]
+	if !found {
+		return fmt.Errorf("target %s not defined in %s", target.Target, earthfilePath)
+	}
+	if target.Target != "base" {
+		// Every target starts with an implicit FROM +base.
+		refs = append(append([]string{}, vc.deps["base"]...), refs...)
+	}
+	for _, ref := range refs {
+		relTarget, err := domain.ParseTarget(ref)
+		if err != nil {
+			return errors.Wrapf(err, "parse target %s referenced by %s", ref, target.String())
+		}
+		depTarget, err := domain.JoinTargets(target, relTarget)
+		if err != nil {
+			return errors.Wrapf(err, "join targets %s and %s", target.String(), ref)
+		}
+		err = v.visit(depTarget)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) parse(earthfilePath string) (*validateCollector, error) {
+	if vc, found := v.earthfiles[earthfilePath]; found {
+		return vc, nil
+	}
+	errorListener := antlrhandler.NewReturnErrorListener()
+	errorStrategy := antlrhandler.NewReturnErrorStrategy()
+	tree, err := newEarthfileTree(earthfilePath, errorListener, errorStrategy)
+	if err != nil {
+		return nil, errors.Wrap(err, "new earthfile tree")
+	}
+	err = parseError(earthfilePath, errorListener, errorStrategy)
+	if err != nil {
+		return nil, err
+	}
+	_, err = getFeatures(tree)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get features of %s", earthfilePath)
+	}
+	vc := &validateCollector{
+		depsCollector: newDepsCollector(),
+		stmts:         make(map[string][]earthfileStmt),
+	}
+	antlr.ParseTreeWalkerDefault.Walk(vc, tree)
+	if vc.err != nil {
+		return nil, errors.Wrapf(vc.err, "in %s", earthfilePath)
+	}
+	v.earthfiles[earthfilePath] = vc
+	return vc, nil
+}
+
+// validateCollector collects the references of each target, like depsCollector, and
+// reports the commands which the parser does not know. It also keeps the statements of
+// each target, for Lint.
+type validateCollector struct {
+	*depsCollector
+	envArgKey string
+	stmts     map[string][]earthfileStmt
+	err       error
+}
+
+// earthfileStmt is a statement of a recipe.
+type earthfileStmt struct {
+	line    int
+	keyword string
+	words   []string
+	// text is the statement as written, including the values of ARG and ENV.
+	text string
+	// envArgKey is the key of an ARG or ENV statement.
+	envArgKey string
+}
+
+func (vc *validateCollector) EnterStmt(c *parser.StmtContext) {
+	vc.depsCollector.EnterStmt(c)
+	vc.envArgKey = ""
+}
+
+func (vc *validateCollector) EnterEnvArgKey(c *parser.EnvArgKeyContext) {
+	vc.envArgKey = c.GetText()
+}
+
+func (vc *validateCollector) ExitStmt(c *parser.StmtContext) {
+	vc.stmts[vc.currentTarget] = append(vc.stmts[vc.currentTarget], earthfileStmt{
+		line:      c.GetStart().GetLine(),
+		keyword:   c.GetStart().GetText(),
+		words:     vc.stmtWords,
+		text:      c.GetText(),
+		envArgKey: vc.envArgKey,
+	})
+}
+
+func (vc *validateCollector) ExitGenericCommandStmt(c *parser.GenericCommandStmtContext) {
+	if vc.err != nil {
+		return
+	}
+	line := c.GetStart().GetLine()
+	if c.CommandName().GetText() == "VERSION" {
+		if vc.currentTarget != "base" {
+			vc.err = fmt.Errorf("line %d: VERSION is only allowed at the top of the Earthfile, before the targets", line)
+		}
+		return
+	}
+	vc.err = fmt.Errorf("line %d: invalid command %s", line, c.GetText())
+}
@@ -0,0 +1,50 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/domain"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		earthfile string
+		ok        bool
+	}{
+		{"FROM alpine:3.13\n\nbuild:\n    BUILD +dep\n\ndep:\n    RUN true\n", true},
+		{"FROM alpine:3.13\n\nbuild:\n    COPY ./lib+lib/out .\n    BUILD +$TARGET\n", true},
+		{"FROM alpine:3.13\n\nbuild:\n    BUILD github.com/earthly/hello-world:main+hello\n", true},
+		{"FROM alpine:3.13\n\nbuild:\n    BUILD +dep\n", false},
+		{"FROM alpine:3.13\n\nbuild:\n    COPY ./lib+missing/out .\n", false},
+		{"FROM alpine:3.13\n\nbuild:\n    RUNN true\n", false},
+		{"FROM alpine:3.13\n\nbuild:\n    RUN true\n  bad indent\n", false},
+		{"VERSION 0.5\nFROM alpine:3.13\n\nbuild:\n    RUN true\n", true},
+		{"FROM alpine:3.13\n\nbuild:\n    VERSION 0.5\n    RUN true\n", false},
+		{"VERSION --use-copy-include-patterns 0.5\nFROM alpine:3.13\n\nbuild:\n    RUN true\n", true},
+		{"VERSION --no-such-feature 0.5\nFROM alpine:3.13\n\nbuild:\n    RUN true\n", false},
+	}
+	dir, err := ioutil.TempDir("", "earthly-validate-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0755))
+	err = ioutil.WriteFile(filepath.Join(dir, "lib", "Earthfile"), []byte("lib:\n    FROM alpine:3.13\n    SAVE ARTIFACT /etc/os-release out\n"), 0644)
+	NoError(t, err)
+	wd, err := os.Getwd()
+	NoError(t, err)
+	NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	for _, tt := range tests {
+		NoError(t, ioutil.WriteFile("Earthfile", []byte(tt.earthfile), 0644))
+		err := Validate(domain.Target{LocalPath: ".", Target: "build"})
+		if tt.ok {
+			NoError(t, err, tt.earthfile)
+		} else {
+			Error(t, err, tt.earthfile)
+		}
+	}
+	Error(t, Validate(domain.Target{GitURL: "github.com/earthly/earthly", Target: "build"}))
+}
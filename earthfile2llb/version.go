@@ -0,0 +1,55 @@
+package earthfile2llb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var versionRegexp = regexp.MustCompile(`^v?([0-9]+)\.([0-9]+)(?:\.([0-9]+))?$`)
+
+// parseVersion parses a release version of the form [v]<major>.<minor>[.<patch>]. The
+// patch defaults to 0.
+func parseVersion(s string) ([3]int, bool) {
+	var ret [3]int
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return ret, false
+	}
+	for i := 0; i < 3; i++ {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return ret, false
+		}
+		ret[i] = n
+	}
+	return ret, true
+}
+
+// checkEarthlyVersion returns an error if the running earthly version is older than the
+// version required by an Earthfile, via VERSION. The running version is only known for
+// release builds; development builds are assumed to support every version.
+func checkEarthlyVersion(required string, running string) error {
+	requiredVersion, ok := parseVersion(required)
+	if !ok {
+		return fmt.Errorf("invalid VERSION %s; expected a version such as 0.5 or 0.5.2", required)
+	}
+	runningVersion, ok := parseVersion(running)
+	if !ok {
+		return nil
+	}
+	for i := range requiredVersion {
+		if runningVersion[i] > requiredVersion[i] {
+			return nil
+		}
+		if runningVersion[i] < requiredVersion[i] {
+			return fmt.Errorf(
+				"the Earthfile requires earthly %s or newer, but this is earthly %s; please upgrade earthly",
+				required, running)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package earthfile2llb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCheckEarthlyVersion(t *testing.T) {
+	var tests = []struct {
+		required string
+		running  string
+		ok       bool
+	}{
+		{"0.5", "v0.5.0", true},
+		{"0.5", "v0.5.3", true},
+		{"v0.5.2", "v0.5.3", true},
+		{"0.5.3", "v0.5.3", true},
+		{"0.4", "v0.5.0", true},
+		{"1.0", "v0.9.9", false},
+		{"0.6", "v0.5.3", false},
+		{"0.5.4", "v0.5.3", false},
+		{"0.6", "dev-main-abc1234", true},
+		{"0.6", "", true},
+		{"latest", "v0.5.3", false},
+		{"0.5.x", "v0.5.3", false},
+	}
+	for _, tt := range tests {
+		err := checkEarthlyVersion(tt.required, tt.running)
+		if tt.ok {
+			NoError(t, err, "%s with %s", tt.required, tt.running)
+		} else {
+			Error(t, err, "%s with %s", tt.required, tt.running)
+		}
+	}
+}
+
+func TestParseVersionCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-version-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "Earthfile")
+	err = ioutil.WriteFile(file, []byte("VERSION 0.5\nFROM alpine:3.13\n\nbuild:\n    RUN echo build\n"), 0644)
+	NoError(t, err)
+	targets, err := GetTargets(file)
+	NoError(t, err)
+	Equal(t, []string{"build"}, targets)
+}
@@ -123,7 +123,7 @@ func (wdr *withDockerRun) Run(ctx context.Context, args []string, opt WithDocker
 	}
 	var runOpts []llb.RunOption
 	mountRunOpts, err := parseMounts(
-		opt.Mounts, wdr.c.mts.Final.Target, wdr.c.mts.Final.TargetInput, wdr.c.cacheContext)
+		opt.Mounts, wdr.c.mts.Final.Target, wdr.c.mts.Final.TargetInput, wdr.c.cacheContext, wdr.c.opt)
 	if err != nil {
 		return errors.Wrap(err, "parse mounts")
 	}
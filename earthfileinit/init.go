@@ -0,0 +1,170 @@
+package earthfileinit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/fileutil"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultTemplate is the template used when none is specified.
+const DefaultTemplate = "generic"
+
+type template struct {
+	earthfile string
+	ignore    []string
+}
+
+var templates = map[string]template{
+	"generic": {
+		earthfile: `FROM alpine:3.13
+WORKDIR /work
+
+build:
+    COPY . .
+    RUN echo "Add your build steps here"
+    SAVE ARTIFACT . /out
+
+docker:
+    COPY +build/out .
+    ENTRYPOINT ["/bin/sh"]
+    SAVE IMAGE my-image:latest
+`,
+		ignore: []string{"Earthfile"},
+	},
+	"go": {
+		earthfile: `FROM golang:1.16-alpine3.13
+WORKDIR /work
+
+deps:
+    COPY go.mod go.sum ./
+    RUN go mod download
+    SAVE ARTIFACT go.mod AS LOCAL go.mod
+    SAVE ARTIFACT go.sum AS LOCAL go.sum
+
+build:
+    FROM +deps
+    COPY . .
+    RUN go build -o build/app .
+    SAVE ARTIFACT build/app /app AS LOCAL build/app
+
+test:
+    FROM +deps
+    COPY . .
+    RUN go test ./...
+
+docker:
+    COPY +build/app .
+    ENTRYPOINT ["/work/app"]
+    SAVE IMAGE my-image:latest
+`,
+		ignore: []string{"Earthfile", "build"},
+	},
+	"node": {
+		earthfile: `FROM node:14-alpine3.13
+WORKDIR /work
+
+deps:
+    COPY package.json package-lock.json ./
+    RUN npm ci
+    SAVE ARTIFACT package-lock.json AS LOCAL ./package-lock.json
+
+build:
+    FROM +deps
+    COPY . .
+    RUN npm run build
+    SAVE ARTIFACT dist /dist AS LOCAL dist
+
+test:
+    FROM +deps
+    COPY . .
+    RUN npm test
+
+docker:
+    FROM +deps
+    COPY +build/dist ./dist
+    ENTRYPOINT ["node", "./dist/index.js"]
+    SAVE IMAGE my-image:latest
+`,
+		ignore: []string{"Earthfile", "node_modules", "dist"},
+	},
+	"python": {
+		earthfile: `FROM python:3
+WORKDIR /work
+
+deps:
+    RUN pip install wheel
+    COPY requirements.txt ./
+    RUN pip wheel -r requirements.txt --wheel-dir=wheels
+
+build:
+    FROM +deps
+    COPY src src
+    SAVE ARTIFACT src /src
+    SAVE ARTIFACT wheels /wheels
+
+docker:
+    COPY +build/src src
+    COPY +build/wheels wheels
+    COPY requirements.txt ./
+    RUN pip install --no-index --find-links=wheels -r requirements.txt
+    ENTRYPOINT ["python3", "./src/main.py"]
+    SAVE IMAGE my-image:latest
+`,
+		ignore: []string{"Earthfile", "__pycache__", "*.pyc", ".venv"},
+	},
+}
+
+// Templates returns the names of the available templates.
+func Templates() []string {
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Init writes a starter Earthfile, based on the given template, into dir. If withIgnore
+// is set, an ignore file is written alongside it. Existing files are not overwritten,
+// unless force is set.
+func Init(dir, templateName string, withIgnore, force bool) ([]string, error) {
+	t, ok := templates[templateName]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q; available templates are: %s", templateName, strings.Join(Templates(), ", "))
+	}
+	files := map[string]string{
+		"Earthfile": t.earthfile,
+	}
+	if withIgnore {
+		files[buildcontext.EarthIgnoreFile] = strings.Join(t.ignore, "\n") + "\n"
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !force {
+		for _, name := range names {
+			if fileutil.FileExists(filepath.Join(dir, name)) {
+				return nil, fmt.Errorf("%s already exists; use --force to overwrite it", name)
+			}
+		}
+	}
+	var written []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(files[name]), 0644)
+		if err != nil {
+			return written, errors.Wrapf(err, "write %s", path)
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,44 @@
+package earthfileinit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/earthly/earthly/buildcontext"
+	"github.com/earthly/earthly/earthfile2llb"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestInit(t *testing.T) {
+	for _, name := range Templates() {
+		dir, err := ioutil.TempDir("", "earthly-init-test")
+		NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		written, err := Init(dir, name, true, false)
+		NoError(t, err, name)
+		Equal(t, []string{
+			filepath.Join(dir, buildcontext.EarthIgnoreFile),
+			filepath.Join(dir, "Earthfile"),
+		}, written, name)
+		_, err = earthfile2llb.Format(filepath.Join(dir, "Earthfile"))
+		NoError(t, err, name)
+
+		_, err = Init(dir, name, false, false)
+		Error(t, err, name)
+		_, err = Init(dir, name, false, true)
+		NoError(t, err, name)
+	}
+}
+
+func TestInitUnknownTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-init-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, err = Init(dir, "cobol", false, false)
+	Error(t, err)
+	NoFileExists(t, filepath.Join(dir, "Earthfile"))
+}
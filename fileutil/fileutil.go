@@ -1,6 +1,7 @@
 package fileutil
 
 import (
+	"io/ioutil"
 	"os"
 )
 
@@ -21,3 +22,18 @@ func DirExists(filename string) bool {
 	}
 	return info.IsDir()
 }
+
+// CheckDirWritable returns an error if a file cannot be created in the directory (e.g. as it
+// is on a read-only or full filesystem). It creates and removes a temporary file to find out.
+func CheckDirWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".write-check-")
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Remove(f.Name())
+}
@@ -22,6 +22,8 @@ var (
 	ErrCouldNotDetectGitHash = errors.New("Could not auto-detect or parse Git hash")
 	// ErrCouldNotDetectGitBranch is an error returned when git branch could not be detected.
 	ErrCouldNotDetectGitBranch = errors.New("Could not auto-detect or parse Git branch")
+	// ErrCouldNotDetectGitTimestamp is an error returned when git commit timestamp could not be detected.
+	ErrCouldNotDetectGitTimestamp = errors.New("Could not auto-detect or parse Git commit timestamp")
 )
 
 // GitMetadata is a collection of git information about a certain directory.
@@ -33,6 +35,7 @@ type GitMetadata struct {
 	Hash      string
 	Branch    []string
 	Tags      []string
+	Timestamp string
 }
 
 // Metadata performs git metadata detection on the provided directory.
@@ -77,6 +80,11 @@ func Metadata(ctx context.Context, dir string) (*GitMetadata, error) {
 		// Most likely no tags. Keep going.
 		tags = nil
 	}
+	timestamp, err := detectGitTimestamp(ctx, dir)
+	if err != nil {
+		retErr = err
+		// Keep going.
+	}
 
 	relDir, isRel, err := gitRelDir(baseDir, dir)
 	if err != nil {
@@ -94,18 +102,20 @@ func Metadata(ctx context.Context, dir string) (*GitMetadata, error) {
 		Hash:      hash,
 		Branch:    branch,
 		Tags:      tags,
+		Timestamp: timestamp,
 	}, retErr
 }
 
 // Clone returns a copy of the GitMetadata object.
 func (gm *GitMetadata) Clone() *GitMetadata {
 	return &GitMetadata{
-		BaseDir: gm.BaseDir,
-		RelDir:  gm.RelDir,
-		GitURL:  gm.GitURL,
-		Hash:    gm.Hash,
-		Branch:  gm.Branch,
-		Tags:    gm.Tags,
+		BaseDir:   gm.BaseDir,
+		RelDir:    gm.RelDir,
+		GitURL:    gm.GitURL,
+		Hash:      gm.Hash,
+		Branch:    gm.Branch,
+		Tags:      gm.Tags,
+		Timestamp: gm.Timestamp,
 	}
 }
 
@@ -192,6 +202,20 @@ func detectGitHash(ctx context.Context, dir string) (string, error) {
 	return strings.SplitN(outStr, "\n", 2)[0], nil
 }
 
+func detectGitTimestamp(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "log", "-1", "--format=%ct")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(ErrCouldNotDetectGitTimestamp, "returned error %s: %s", err.Error(), string(out))
+	}
+	outStr := string(out)
+	if outStr == "" {
+		return "", errors.Wrapf(ErrCouldNotDetectGitTimestamp, "no git log output")
+	}
+	return strings.SplitN(outStr, "\n", 2)[0], nil
+}
+
 func detectGitBranch(ctx context.Context, dir string) ([]string, error) {
 	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = dir
@@ -260,13 +284,27 @@ func TargetWithGitMeta(target domain.Target, gitMeta *GitMetadata) domain.Target
 	targetRet.GitURL = gitMeta.GitURL
 
 	if targetRet.Tag == "" {
-		if len(gitMeta.Tags) > 0 {
-			targetRet.Tag = gitMeta.Tags[0]
-		} else if len(gitMeta.Branch) > 0 {
-			targetRet.Tag = gitMeta.Branch[0]
-		} else {
-			targetRet.Tag = gitMeta.Hash
-		}
+		targetRet.Tag = gitMeta.DefaultTag()
 	}
 	return targetRet
 }
+
+// DefaultTag returns the ref that best describes the current checkout: the tag if one
+// points at HEAD, otherwise the branch, otherwise the commit hash.
+func (gm *GitMetadata) DefaultTag() string {
+	if len(gm.Tags) > 0 {
+		return gm.Tags[0]
+	} else if len(gm.Branch) > 0 {
+		return gm.Branch[0]
+	}
+	return gm.Hash
+}
+
+// IsSameRepo returns whether the given git URL (optionally including a subdirectory)
+// refers to the same repository as the git metadata.
+func (gm *GitMetadata) IsSameRepo(gitURL string) bool {
+	if gm.GitURL == "" {
+		return false
+	}
+	return gitURL == gm.GitURL || strings.HasPrefix(gitURL, gm.GitURL+"/")
+}
@@ -50,3 +50,12 @@ func TestParseGitRemoteURL(t *testing.T) {
 		Equal(t, test.expectedGitURL, gitURL)
 	}
 }
+
+func TestIsSameRepo(t *testing.T) {
+	gm := &GitMetadata{GitURL: "github.com/earthly/earthly"}
+	True(t, gm.IsSameRepo("github.com/earthly/earthly"))
+	True(t, gm.IsSameRepo("github.com/earthly/earthly/examples/go"))
+	False(t, gm.IsSameRepo("github.com/earthly/earthly-other"))
+	False(t, gm.IsSameRepo("github.com/other/earthly"))
+	False(t, (&GitMetadata{}).IsSameRepo("github.com/earthly/earthly"))
+}
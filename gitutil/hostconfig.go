@@ -0,0 +1,100 @@
+package gitutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// InsteadOf is a url.<base>.insteadOf rule of the git config: URLs starting with Prefix
+// are rewritten to start with Base instead.
+type InsteadOf struct {
+	Base   string
+	Prefix string
+}
+
+// HostInsteadOfRules returns the url.<base>.insteadOf rules of the host's git config.
+func HostInsteadOfRules(ctx context.Context) ([]InsteadOf, error) {
+	err := detectGitBinary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", "--get-regexp", `^url\..*\.insteadof$`)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// No matching settings.
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "read git config url insteadOf rules")
+	}
+	return parseInsteadOfRules(out), nil
+}
+
+// parseInsteadOfRules parses the output of git config --get-regexp for the url.<base>.insteadOf
+// settings, made of "url.<base>.insteadof <prefix>" lines.
+func parseInsteadOfRules(out []byte) []InsteadOf {
+	var rules []InsteadOf
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimPrefix(parts[0], "url."), ".insteadof")
+		rules = append(rules, InsteadOf{Base: base, Prefix: parts[1]})
+	}
+	return rules
+}
+
+// HostCredentialHelperConfigured returns whether a credential helper is set up in the host's
+// git config.
+func HostCredentialHelperConfigured(ctx context.Context) bool {
+	if detectGitBinary(ctx) != nil {
+		return false
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", "credential.helper")
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) != ""
+}
+
+// HostCredentials returns the username and password for the given protocol and host, as
+// provided by the credential helpers of the host's git config. The user is never prompted.
+func HostCredentials(ctx context.Context, protocol, host string) (string, string, error) {
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", protocol, host))
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", errors.Wrapf(err, "git credential fill for %s://%s", protocol, host)
+	}
+	user, password := parseCredentials(out)
+	return user, password, nil
+}
+
+// parseCredentials parses the output of git credential fill, made of key=value lines, and
+// returns the username and password.
+func parseCredentials(out []byte) (string, string) {
+	var user, password string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "username":
+			user = kv[1]
+		case "password":
+			password = kv[1]
+		}
+	}
+	return user, password
+}
@@ -0,0 +1,65 @@
+package gitutil
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseInsteadOfRules(t *testing.T) {
+	var tests = []struct {
+		name     string
+		out      string
+		expected []InsteadOf
+	}{
+		{"empty", "", nil},
+		{
+			"single rule",
+			"url.git@github.com:.insteadof https://github.com/\n",
+			[]InsteadOf{{Base: "git@github.com:", Prefix: "https://github.com/"}},
+		},
+		{
+			"several rules",
+			"url.https://mirror.example.com/.insteadof https://github.com/\n" +
+				"url.ssh://git@gitlab.example.com:2222/.insteadof gl:\n",
+			[]InsteadOf{
+				{Base: "https://mirror.example.com/", Prefix: "https://github.com/"},
+				{Base: "ssh://git@gitlab.example.com:2222/", Prefix: "gl:"},
+			},
+		},
+		{
+			"malformed line skipped",
+			"url.https://example.com/.insteadof\nurl.a.insteadof b\n",
+			[]InsteadOf{{Base: "a", Prefix: "b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		Equal(t, tt.expected, parseInsteadOfRules([]byte(tt.out)), tt.name)
+	}
+}
+
+func TestParseCredentials(t *testing.T) {
+	var tests = []struct {
+		name             string
+		out              string
+		expectedUser     string
+		expectedPassword string
+	}{
+		{"empty", "", "", ""},
+		{
+			"user and password",
+			"protocol=https\nhost=github.com\nusername=me\npassword=secret\n",
+			"me", "secret",
+		},
+		{"password with equals", "username=me\npassword=a=b=c\n", "me", "a=b=c"},
+		{"user only", "protocol=https\nhost=example.com\nusername=me\n", "me", ""},
+		{"malformed line skipped", "garbage\npassword=secret\n", "", "secret"},
+	}
+
+	for _, tt := range tests {
+		user, password := parseCredentials([]byte(tt.out))
+		Equal(t, tt.expectedUser, user, tt.name)
+		Equal(t, tt.expectedPassword, password, tt.name)
+	}
+}
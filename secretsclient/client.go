@@ -2,11 +2,14 @@ package secretsclient
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -34,6 +37,13 @@ var ErrUnauthorized = fmt.Errorf("unauthorized")
 // ErrNoAuthorizedPublicKeys occurs when no authorized public keys are found
 var ErrNoAuthorizedPublicKeys = fmt.Errorf("no authorized public keys found")
 
+// ErrTimeout occurs when the API server does not respond within the timeout of the client
+var ErrTimeout = fmt.Errorf("timed out waiting for the API server")
+
+// secretExpiryHeader carries the expiry time of a secret, in RFC 3339 format. Servers which
+// support secret expiry echo it back when a secret is set, and return it when a secret is read.
+const secretExpiryHeader = "X-Earthly-Secret-Expiry"
+
 // OrgDetail contains an organization and details
 type OrgDetail struct {
 	Name  string
@@ -61,6 +71,10 @@ type Client interface {
 	Get(path string) ([]byte, error)
 	Remove(path string) error
 	Set(path string, data []byte) error
+	SetWithExpiry(path string, data []byte, expiry time.Time) (bool, error)
+	SetIfNotExists(path string, data []byte, expiry *time.Time) (bool, bool, error)
+	GetWithExpiry(path string) ([]byte, *time.Time, error)
+	GetExpiry(path string) (*time.Time, error)
 	List(path string) ([]string, error)
 	GetPublicKeys() ([]*agent.Key, error)
 	CreateOrg(org string) error
@@ -80,8 +94,15 @@ type Client interface {
 	SetLoginToken(token string) (string, error)
 	SetLoginSSH(email, sshKey string) error
 	DeleteCachedCredentials() error
+	CachedCredentialsPath() (string, error)
 	DisableSSHKeyGuessing()
+	SaveSSHKeyGuessingDisabled() error
+	EnableSSHKeyGuessing() error
 	SetAuthTokenDir(path string)
+	SetTimeout(timeout time.Duration)
+	SetRetries(retries int)
+	SetCACert(path string) error
+	SetClientCert(certPath, keyPath string) error
 }
 
 type request struct {
@@ -90,6 +111,9 @@ type request struct {
 
 	hasAuth bool
 	retry   bool
+
+	headers    map[string]string
+	respHeader *http.Header
 }
 type requestOpt func(*request) error
 
@@ -129,9 +153,34 @@ func withBody(body string) requestOpt {
 	}
 }
 
-const maxAttempt = 10
+func withHeader(key, value string) requestOpt {
+	return func(r *request) error {
+		if r.headers == nil {
+			r.headers = make(map[string]string)
+		}
+		r.headers[key] = value
+		return nil
+	}
+}
+
+// withResponseHeader stores the headers of the response in h.
+func withResponseHeader(h *http.Header) requestOpt {
+	return func(r *request) error {
+		r.respHeader = h
+		return nil
+	}
+}
+
+// DefaultRetries is the default number of times an idempotent request is retried after a
+// transient error.
+const DefaultRetries = 3
+
 const maxSleepBeforeRetry = time.Second * 3
 
+// errLoginRequired is returned when the server rejects the credentials and no other
+// credentials are available.
+var errLoginRequired = errors.Wrap(ErrUnauthorized, "the credentials were rejected; please log in via earthly account login")
+
 func (c *client) doCall(method, url string, opts ...requestOpt) (int, string, error) {
 	var r request
 	for _, opt := range opts {
@@ -141,14 +190,37 @@ func (c *client) doCall(method, url string, opts ...requestOpt) (int, string, er
 		}
 	}
 
+	status, body, err := c.doCallWithRetries(r, method, url, opts...)
+	if err != nil || status != http.StatusUnauthorized || !r.hasAuth {
+		return status, body, err
+	}
+	// The credentials may have expired during a long running command; refresh them once
+	// and try again.
+	if !c.refreshAuth() {
+		return 0, "", errLoginRequired
+	}
+	status, body, err = c.doCallWithRetries(r, method, url, opts...)
+	if err == nil && status == http.StatusUnauthorized {
+		return 0, "", errLoginRequired
+	}
+	return status, body, err
+}
+
+// doCallWithRetries performs the request, and retries it with an exponential backoff on
+// transient errors (5xx status codes and connection errors) if it is idempotent. Mutations are
+// never retried, as they may have been applied despite the error.
+func (c *client) doCallWithRetries(r request, method, url string, opts ...requestOpt) (int, string, error) {
 	var status int
 	var body string
 	var err error
+	attempts := 1
+	if isIdempotent(method) {
+		attempts += c.retries
+	}
 	duration := time.Millisecond * 100
-	for attempt := 0; attempt < maxAttempt; attempt++ {
+	for attempt := 0; attempt < attempts; attempt++ {
 		status, body, err = c.doCallImp(r, method, url, opts...)
-		if (err == nil && status < 500) || errors.Cause(err) == ErrNoAuthorizedPublicKeys || errors.Cause(err) == ErrNoSSHAgent ||
-			(err != nil && strings.Contains(err.Error(), "failed to connect to ssh-agent")) {
+		if !isTransient(status, err) || attempt == attempts-1 {
 			return status, body, err
 		}
 		if err != nil {
@@ -170,6 +242,23 @@ func (c *client) doCall(method, url string, opts ...requestOpt) (int, string, er
 	return status, body, err
 }
 
+// isIdempotent returns true if requests of the given method may safely be sent several times.
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// isTransient returns true if the result of a request may be different when trying again.
+func isTransient(status int, err error) bool {
+	if err == nil {
+		return status >= 500
+	}
+	switch errors.Cause(err) {
+	case ErrNoAuthorizedPublicKeys, ErrNoSSHAgent, ErrTimeout:
+		return false
+	}
+	return !strings.Contains(err.Error(), "failed to connect to ssh-agent")
+}
+
 func (c *client) doCallImp(r request, method, url string, opts ...requestOpt) (int, string, error) {
 	var bodyReader io.Reader
 	var bodyLen int64
@@ -192,21 +281,48 @@ func (c *client) doCallImp(r request, method, url string, opts ...requestOpt) (i
 		}
 		req.Header.Add("Authorization", authToken)
 	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
 
-	client := &http.Client{}
+	client := c.newHTTPClient()
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, "", err
+		return 0, "", c.wrapTimeout(err)
 	}
 
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return 0, "", err
+		return 0, "", c.wrapTimeout(err)
+	}
+	if r.respHeader != nil {
+		*r.respHeader = resp.Header
 	}
 	return resp.StatusCode, string(respBody), nil
 }
 
+// newHTTPClient returns an http client which gives up on requests after the timeout of the
+// client, if any.
+func (c *client) newHTTPClient() *http.Client {
+	hc := &http.Client{Timeout: c.timeout}
+	if c.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tlsConfig
+		hc.Transport = transport
+	}
+	return hc
+}
+
+// wrapTimeout wraps the given request error with ErrTimeout if the request timed out.
+func (c *client) wrapTimeout(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errors.Wrapf(ErrTimeout, "no response within %s (see --api-timeout)", c.timeout)
+	}
+	return err
+}
+
 type client struct {
 	secretServer          string
 	sshKeyBlob            []byte // sshKey to use
@@ -218,6 +334,10 @@ type client struct {
 	authToken             string
 	authTokenDir          string
 	disableSSHKeyGuessing bool
+	explicitCredentials   bool // if true the credentials were given explicitly, and are never refreshed
+	timeout               time.Duration
+	retries               int
+	tlsConfig             *tls.Config // nil means the default TLS settings
 	jm                    *jsonpb.Unmarshaler
 }
 
@@ -229,12 +349,14 @@ func NewClient(secretServer, agentSockPath, authTokenOverride string, warnFunc f
 			sockPath: agentSockPath,
 		},
 		warnFunc: warnFunc,
+		retries:  DefaultRetries,
 		jm: &jsonpb.Unmarshaler{
 			AllowUnknownFields: true,
 		},
 	}
 	if authTokenOverride != "" {
 		c.authToken = authTokenOverride
+		c.explicitCredentials = true
 	} else {
 		err := c.loadAuthToken()
 		if err != nil {
@@ -408,7 +530,7 @@ func (c *client) signChallenge(challenge string, key *agent.Key) (string, error)
 }
 
 func (c *client) tryAuth(challenge string, key *agent.Key) (string, string, error) {
-	client := &http.Client{}
+	client := c.newHTTPClient()
 
 	sig, err := c.signChallenge(challenge, key)
 	if err != nil {
@@ -427,7 +549,7 @@ func (c *client) tryAuth(challenge string, key *agent.Key) (string, string, erro
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", "", err
+		return "", "", c.wrapTimeout(err)
 	}
 
 	var pingResponse api.PingResponse
@@ -447,6 +569,31 @@ func (c *client) tryAuth(challenge string, key *agent.Key) (string, string, erro
 	return pingResponse.Email, authToken, nil
 }
 
+// refreshAuth replaces the cached credentials after the server rejected them (e.g. because
+// a token expired). The cached credentials are reloaded first, as they may have been updated
+// since (e.g. by earthly account login); failing that, token and password credentials fall
+// back to the keys of the ssh-agent. It returns false if no other credentials are available.
+func (c *client) refreshAuth() bool {
+	if c.explicitCredentials {
+		return false
+	}
+	email, password, authToken, sshKeyBlob := c.email, c.password, c.authToken, c.sshKeyBlob
+	c.email, c.password, c.authToken, c.sshKeyBlob = "", "", "", nil
+	err := c.loadAuthToken()
+	if err != nil {
+		c.warnFunc("failed to reload cached credentials: %v", err)
+	}
+	if c.email != email || c.password != password || c.authToken != authToken || !bytes.Equal(c.sshKeyBlob, sshKeyBlob) {
+		return true
+	}
+	if (c.authToken != "" || c.password != "") && !c.isSSHKeyGuessingDisabled() {
+		c.password = ""
+		c.authToken = ""
+		return true
+	}
+	return false
+}
+
 func getPasswordAuthToken(email, password string) string {
 	email64 := base64.StdEncoding.EncodeToString([]byte(email))
 	password64 := base64.StdEncoding.EncodeToString([]byte(password))
@@ -461,7 +608,7 @@ func (c *client) getAuthToken() (string, error) {
 		return "token " + c.authToken, nil
 	}
 
-	if c.disableSSHKeyGuessing {
+	if c.isSSHKeyGuessingDisabled() {
 		return "", ErrNoAuthorizedPublicKeys
 	}
 
@@ -542,31 +689,123 @@ func (c *client) List(path string) ([]string, error) {
 }
 
 func (c *client) Get(path string) ([]byte, error) {
+	data, _, err := c.GetWithExpiry(path)
+	return data, err
+}
+
+// GetWithExpiry returns a secret and its expiry time. The expiry is nil if the secret does
+// not expire, or if the server does not support secret expiry.
+func (c *client) GetWithExpiry(path string) ([]byte, *time.Time, error) {
 	if path == "" || path[0] != '/' || strings.HasSuffix(path, "/") {
-		return nil, fmt.Errorf("invalid path")
+		return nil, nil, fmt.Errorf("invalid path")
 	}
-	status, body, err := c.doCall("GET", fmt.Sprintf("/api/v0/secrets%s", path), withAuth(), withRetry())
+	var header http.Header
+	status, body, err := c.doCall("GET", fmt.Sprintf("/api/v0/secrets%s", path), withAuth(), withRetry(), withResponseHeader(&header))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if status != http.StatusOK {
 		msg, err := getMessageFromJSON(bytes.NewReader([]byte(body)))
 		if err != nil {
-			return nil, errors.Wrap(err, fmt.Sprintf("failed to decode response body (status code: %d)", status))
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("failed to decode response body (status code: %d)", status))
 		}
-		return nil, fmt.Errorf("failed to get secret: %s", msg)
+		return nil, nil, fmt.Errorf("failed to get secret: %s", msg)
+	}
+	expiry, err := parseSecretExpiry(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []byte(body), expiry, nil
+}
+
+// GetExpiry returns the expiry time of a secret, without fetching the secret itself. The
+// expiry is nil if the secret does not expire, or if the server does not support secret
+// expiry.
+func (c *client) GetExpiry(path string) (*time.Time, error) {
+	if path == "" || path[0] != '/' || strings.HasSuffix(path, "/") {
+		return nil, fmt.Errorf("invalid path")
+	}
+	var header http.Header
+	status, _, err := c.doCall("HEAD", fmt.Sprintf("/api/v0/secrets%s", path), withAuth(), withRetry(), withResponseHeader(&header))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		// The responses to HEAD requests have no body, so there is no message to report.
+		return nil, fmt.Errorf("failed to get secret expiry (status code: %d)", status)
+	}
+	return parseSecretExpiry(header)
+}
+
+// parseSecretExpiry returns the expiry time of a secret from the headers of a response, or
+// nil if there is none.
+func parseSecretExpiry(header http.Header) (*time.Time, error) {
+	v := header.Get(secretExpiryHeader)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse secret expiry %q", v)
 	}
-	return []byte(body), nil
+	return &t, nil
 }
 
 func (c *client) Set(path string, data []byte) error {
+	return c.set(path, data)
+}
+
+// SetWithExpiry stores a secret, which expires at the given time. It returns false if the
+// server does not support secret expiry, in which case the secret is stored without one.
+func (c *client) SetWithExpiry(path string, data []byte, expiry time.Time) (bool, error) {
+	var header http.Header
+	err := c.set(path, data,
+		withHeader(secretExpiryHeader, expiry.UTC().Format(time.RFC3339)), withResponseHeader(&header))
+	if err != nil {
+		return false, err
+	}
+	return header.Get(secretExpiryHeader) != "", nil
+}
+
+// SetIfNotExists stores a secret, unless a secret already exists at the path. The secret
+// expires at the given time, if not nil. It returns whether the secret was stored and
+// whether the server stored its expiry.
+//
+// The condition is sent to the server (as an If-None-Match: * header), which checks it
+// atomically if supported. Servers which do not support conditional requests ignore the
+// header and overwrite the secret; callers should check for an existing secret beforehand
+// in that case, which is subject to a race with concurrent writers.
+func (c *client) SetIfNotExists(path string, data []byte, expiry *time.Time) (bool, bool, error) {
+	var header http.Header
+	opts := []requestOpt{withHeader("If-None-Match", "*"), withResponseHeader(&header)}
+	if expiry != nil {
+		opts = append(opts, withHeader(secretExpiryHeader, expiry.UTC().Format(time.RFC3339)))
+	}
+	err := c.set(path, data, opts...)
+	if err == errSecretExists {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, expiry == nil || header.Get(secretExpiryHeader) != "", nil
+}
+
+// errSecretExists is returned by set when a conditional set fails because the secret exists.
+var errSecretExists = errors.New("secret already exists")
+
+func (c *client) set(path string, data []byte, opts ...requestOpt) error {
 	if path == "" || path[0] != '/' {
 		return fmt.Errorf("invalid path")
 	}
-	status, body, err := c.doCall("PUT", fmt.Sprintf("/api/v0/secrets%s", path), withAuth(), withBody(string(data)))
+	opts = append([]requestOpt{withAuth(), withBody(string(data))}, opts...)
+	status, body, err := c.doCall("PUT", fmt.Sprintf("/api/v0/secrets%s", path), opts...)
 	if err != nil {
 		return err
 	}
+	if status == http.StatusPreconditionFailed {
+		return errSecretExists
+	}
 	if status != http.StatusCreated {
 		msg, err := getMessageFromJSON(bytes.NewReader([]byte(body)))
 		if err != nil {
@@ -886,6 +1125,27 @@ func (c *client) getAuthTokenPath(create bool) (string, error) {
 	return tokenPath, nil
 }
 
+// getNoSSHKeyGuessingPath returns the path of the file which, if present, disables trying
+// the keys of the ssh-agent, until the next login.
+func (c *client) getNoSSHKeyGuessingPath(create bool) (string, error) {
+	tokenPath, err := c.getAuthTokenPath(create)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(tokenPath), "auth.no-ssh-key-guessing"), nil
+}
+
+func (c *client) isSSHKeyGuessingDisabled() bool {
+	if c.disableSSHKeyGuessing {
+		return true
+	}
+	markerPath, err := c.getNoSSHKeyGuessingPath(false)
+	if err != nil {
+		return false
+	}
+	return fileutil.FileExists(markerPath)
+}
+
 // loads ~/.earthly/auth.token
 // which is formatted as
 // <email> <type> ...
@@ -979,6 +1239,7 @@ func (c *client) savePasswordToken(email, password string) error {
 }
 
 func (c *client) SetLoginCredentials(email, password string) error {
+	c.explicitCredentials = true
 	c.authToken = ""
 	c.email = email
 	c.password = password
@@ -990,6 +1251,7 @@ func (c *client) SetLoginCredentials(email, password string) error {
 }
 
 func (c *client) SetLoginToken(token string) (string, error) {
+	c.explicitCredentials = true
 	c.email = ""
 	c.password = ""
 	c.authToken = token
@@ -1035,10 +1297,95 @@ func (c *client) DisableSSHKeyGuessing() {
 	c.disableSSHKeyGuessing = true
 }
 
+// SaveSSHKeyGuessingDisabled disables trying the keys of the ssh-agent for this and the
+// following commands, until EnableSSHKeyGuessing is called.
+func (c *client) SaveSSHKeyGuessingDisabled() error {
+	c.disableSSHKeyGuessing = true
+	markerPath, err := c.getNoSSHKeyGuessingPath(true)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(markerPath, []byte{}, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", markerPath)
+	}
+	return nil
+}
+
+// EnableSSHKeyGuessing undoes SaveSSHKeyGuessingDisabled.
+func (c *client) EnableSSHKeyGuessing() error {
+	c.disableSSHKeyGuessing = false
+	markerPath, err := c.getNoSSHKeyGuessingPath(false)
+	if err != nil {
+		return err
+	}
+	if !fileutil.FileExists(markerPath) {
+		return nil
+	}
+	err = os.Remove(markerPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete %s", markerPath)
+	}
+	return nil
+}
+
 func (c *client) SetAuthTokenDir(path string) {
 	c.authTokenDir = path
 }
 
+// SetTimeout sets the timeout of each request to the API server. Zero means no timeout.
+func (c *client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetCACert adds the CA certificates of the given PEM file to the system roots trusted when
+// connecting to the API server.
+func (c *client) SetCACert(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "read CA certificate %s", path)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// The system roots are not available on all platforms (e.g. older Windows versions).
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return errors.Errorf("no PEM certificates found in %s", path)
+	}
+	c.ensureTLSConfig().RootCAs = pool
+	return nil
+}
+
+// SetClientCert presents the given PEM certificate and key to the API server, for the servers
+// (or gateways) which require mutual TLS. The earthly credentials are still sent as usual.
+func (c *client) SetClientCert(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return errors.Wrapf(err, "load client certificate %s and key %s", certPath, keyPath)
+	}
+	c.ensureTLSConfig().Certificates = []tls.Certificate{cert}
+	return nil
+}
+
+// ensureTLSConfig returns the TLS settings of the client, creating them if needed.
+func (c *client) ensureTLSConfig() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{}
+	}
+	return c.tlsConfig
+}
+
+// SetRetries sets how many times idempotent requests are retried after a transient error.
+func (c *client) SetRetries(retries int) {
+	c.retries = retries
+}
+
+// CachedCredentialsPath returns the path of the file which caches the login credentials.
+func (c *client) CachedCredentialsPath() (string, error) {
+	return c.getAuthTokenPath(false)
+}
+
 func (c *client) DeleteCachedCredentials() error {
 	c.email = ""
 	c.password = ""
@@ -1063,6 +1410,7 @@ func (c *client) SetLoginSSH(email, sshKey string) error {
 		return err
 	}
 
+	c.explicitCredentials = true
 	c.password = ""
 	c.authToken = ""
 	c.email = email
@@ -0,0 +1,209 @@
+package secretsclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c, err := NewClient(server.URL, "", "test-token", func(string, ...interface{}) {})
+	NoError(t, err)
+	return c
+}
+
+func TestGetExpiry(t *testing.T) {
+	expiry := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	var tests = []struct {
+		name     string
+		status   int
+		header   string
+		expected *time.Time
+		err      bool
+	}{
+		{"with expiry", http.StatusOK, expiry.Format(time.RFC3339), &expiry, false},
+		{"without expiry", http.StatusOK, "", nil, false},
+		{"invalid expiry", http.StatusOK, "tomorrow", nil, true},
+		{"not found", http.StatusNotFound, "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var methods []string
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				methods = append(methods, r.Method)
+				mu.Unlock()
+				Equal(t, "/api/v0/secrets/user/secret", r.URL.Path)
+				if tt.header != "" {
+					w.Header().Set(secretExpiryHeader, tt.header)
+				}
+				w.WriteHeader(tt.status)
+			})
+			actual, err := c.GetExpiry("/user/secret")
+			if tt.err {
+				Error(t, err)
+			} else {
+				NoError(t, err)
+				Equal(t, tt.expected, actual)
+			}
+			// The secret itself is never fetched.
+			mu.Lock()
+			defer mu.Unlock()
+			Equal(t, []string{http.MethodHead}, methods)
+		})
+	}
+}
+
+func TestDoCallRetries(t *testing.T) {
+	var tests = []struct {
+		name             string
+		method           string
+		statuses         []int
+		expectedStatus   int
+		expectedAttempts int
+	}{
+		{"get retried on 5xx", http.MethodGet, []int{503, 503, 503}, 503, 3},
+		{"get succeeds after retry", http.MethodGet, []int{500, 200}, 200, 2},
+		{"head retried on 5xx", http.MethodHead, []int{502, 200}, 200, 2},
+		{"get not retried on 4xx", http.MethodGet, []int{404}, 404, 1},
+		{"put not retried", http.MethodPut, []int{503, 200}, 503, 1},
+		{"post not retried", http.MethodPost, []int{500, 200}, 500, 1},
+		{"delete not retried", http.MethodDelete, []int{503, 200}, 503, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				Equal(t, tt.method, r.Method)
+				Equal(t, "token test-token", r.Header.Get("Authorization"))
+				attempt := int(atomic.AddInt32(&attempts, 1)) - 1
+				status := tt.statuses[len(tt.statuses)-1]
+				if attempt < len(tt.statuses) {
+					status = tt.statuses[attempt]
+				}
+				w.WriteHeader(status)
+			})
+			c.SetRetries(2)
+			status, _, err := c.(*client).doCall(tt.method, "/api/v0/secrets/user/secret", withAuth())
+			NoError(t, err)
+			Equal(t, tt.expectedStatus, status)
+			Equal(t, int32(tt.expectedAttempts), atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestDoCallTimeout(t *testing.T) {
+	var attempts int32
+	done := make(chan struct{})
+	defer close(done)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	})
+	c.SetTimeout(50 * time.Millisecond)
+	_, _, err := c.(*client).doCall(http.MethodGet, "/api/v0/secrets/user/secret", withAuth())
+	Error(t, err)
+	Equal(t, ErrTimeout, errors.Cause(err))
+	// Timeouts are not retried, as the server is likely overloaded.
+	Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestDoCallUnauthorized(t *testing.T) {
+	var attempts int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	_, _, err := c.(*client).doCall(http.MethodGet, "/api/v0/secrets/user/secret", withAuth())
+	Equal(t, errLoginRequired, err)
+	Equal(t, ErrUnauthorized, errors.Cause(err))
+	// The explicitly given credentials are never refreshed.
+	Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	var tests = []struct {
+		method   string
+		expected bool
+	}{
+		{http.MethodGet, true},
+		{http.MethodHead, true},
+		{http.MethodPost, false},
+		{http.MethodPut, false},
+		{http.MethodDelete, false},
+	}
+
+	for _, tt := range tests {
+		Equal(t, tt.expected, isIdempotent(tt.method), tt.method)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	var tests = []struct {
+		name     string
+		status   int
+		err      error
+		expected bool
+	}{
+		{"ok", 200, nil, false},
+		{"not found", 404, nil, false},
+		{"server error", 500, nil, true},
+		{"unavailable", 503, nil, true},
+		{"connection error", 0, fmt.Errorf("connection refused"), true},
+		{"timeout", 0, errors.Wrap(ErrTimeout, "no response"), false},
+		{"no ssh agent", 0, ErrNoSSHAgent, false},
+		{"no authorized keys", 0, ErrNoAuthorizedPublicKeys, false},
+		{"ssh agent connection", 0, fmt.Errorf("failed to connect to ssh-agent"), false},
+	}
+
+	for _, tt := range tests {
+		Equal(t, tt.expected, isTransient(tt.status, tt.err), tt.name)
+	}
+}
+
+func TestSSHKeyGuessingDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-secretsclient-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	newClient := func() *client {
+		c, err := NewClient("http://127.0.0.1:0", "", "", func(string, ...interface{}) {})
+		NoError(t, err)
+		c.SetAuthTokenDir(dir)
+		// Ignore the credentials cached in the home dir, if any.
+		NoError(t, c.DeleteCachedCredentials())
+		return c.(*client)
+	}
+
+	c := newClient()
+	False(t, c.isSSHKeyGuessingDisabled())
+	NoError(t, c.SaveSSHKeyGuessingDisabled())
+	True(t, c.isSSHKeyGuessingDisabled())
+	_, err = c.getAuthToken()
+	Equal(t, ErrNoAuthorizedPublicKeys, err)
+
+	// The following commands do not try the ssh-agent either.
+	c = newClient()
+	True(t, c.isSSHKeyGuessingDisabled())
+
+	NoError(t, c.EnableSSHKeyGuessing())
+	False(t, c.isSSHKeyGuessingDisabled())
+	False(t, newClient().isSSHKeyGuessingDisabled())
+	NoError(t, c.EnableSSHKeyGuessing())
+}
@@ -9,3 +9,11 @@ func IsTTY() bool {
 	}
 	return false
 }
+
+// IsInputTTY returns true if stdin is a terminal
+func IsInputTTY() bool {
+	if fileInfo, _ := os.Stdin.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
+		return true
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package variables
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
@@ -46,7 +47,10 @@ func NewCollection() *Collection {
 }
 
 // ParseCommandLineBuildArgs parses a slice of constant build args and returns a new collection.
-func ParseCommandLineBuildArgs(args []string, dotEnvMap map[string]string) (*Collection, error) {
+// The args override the values of the .env file, and a later arg overrides an earlier one with
+// the same name. If strictDuplicates is set, the duplicates are an error instead, as they are
+// for secrets.
+func ParseCommandLineBuildArgs(args []string, dotEnvMap map[string]string, strictDuplicates bool) (*Collection, error) {
 	ret := NewCollection()
 	for k, v := range dotEnvMap {
 		ret.variables[k] = NewConstant(v)
@@ -70,12 +74,39 @@ func ParseCommandLineBuildArgs(args []string, dotEnvMap map[string]string) (*Col
 				return nil, fmt.Errorf("env var %s not set", key)
 			}
 		}
+		if _, found := ret.variables[key]; found && strictDuplicates {
+			return nil, fmt.Errorf("build arg %q already contains a value", key)
+		}
 		ret.variables[key] = NewConstant(value)
 		ret.overridingVariables[key] = true
 	}
 	return ret, nil
 }
 
+// ReadBuildArgFiles reads the build args given as <key>=<path>, and returns them as
+// <key>=<value> args, which can be passed to ParseCommandLineBuildArgs. A single trailing
+// new line is removed from the values, unless keepNewline is set.
+func ReadBuildArgFiles(argFiles []string, keepNewline bool) ([]string, error) {
+	args := make([]string, 0, len(argFiles))
+	for _, argFile := range argFiles {
+		splitArg := strings.SplitN(argFile, "=", 2)
+		if len(splitArg) != 2 || splitArg[0] == "" || splitArg[1] == "" {
+			return nil, fmt.Errorf("invalid build arg file %s; expected <key>=<path>", argFile)
+		}
+		data, err := ioutil.ReadFile(splitArg[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "read build arg file %s", splitArg[1])
+		}
+		value := string(data)
+		if !keepNewline {
+			value = strings.TrimSuffix(value, "\n")
+			value = strings.TrimSuffix(value, "\r")
+		}
+		args = append(args, fmt.Sprintf("%s=%s", splitArg[0], value))
+	}
+	return args, nil
+}
+
 // Get returns a variable by name.
 func (c *Collection) Get(name string) (Variable, bool, bool) {
 	variable, found := c.variables[name]
@@ -214,8 +245,9 @@ func getProjectName(s string) string {
 }
 
 // WithBuiltinBuildArgs returns a new collection containing the current variables together with
-// builtin args. This operation does not modify the current collection.
-func (c *Collection) WithBuiltinBuildArgs(target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata) *Collection {
+// builtin args. The sourceDateEpoch, if not empty, takes precedence over the git commit timestamp
+// for SOURCE_DATE_EPOCH. This operation does not modify the current collection.
+func (c *Collection) WithBuiltinBuildArgs(target domain.Target, platform specs.Platform, gitMeta *gitutil.GitMetadata, sourceDateEpoch string) *Collection {
 	ret := NewCollection()
 	// Copy existing variables.
 	for k, v := range c.variables {
@@ -251,7 +283,14 @@ func (c *Collection) WithBuiltinBuildArgs(target domain.Target, platform specs.P
 		ret.variables["EARTHLY_GIT_ORIGIN_URL"] = NewConstant(gitMeta.RemoteURL)
 		ret.variables["EARTHLY_GIT_ORIGIN_URL_SCRUBBED"] = NewConstant(stringutil.ScrubCredentials(gitMeta.RemoteURL))
 		ret.variables["EARTHLY_GIT_PROJECT_NAME"] = NewConstant(getProjectName(gitMeta.RemoteURL))
+		if sourceDateEpoch == "" {
+			sourceDateEpoch = gitMeta.Timestamp
+		}
+	}
+	if sourceDateEpoch == "" {
+		sourceDateEpoch = "0"
 	}
+	ret.variables["SOURCE_DATE_EPOCH"] = NewConstant(sourceDateEpoch)
 	return ret
 }
 
@@ -1,8 +1,15 @@
 package variables
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/earthly/earthly/domain"
+	"github.com/earthly/earthly/gitutil"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	. "github.com/stretchr/testify/assert"
 )
 
@@ -27,3 +34,101 @@ func TestGetProjectName(t *testing.T) {
 		Equal(t, tt.safe, ans)
 	}
 }
+
+func TestParseCommandLineBuildArgs(t *testing.T) {
+	os.Setenv("EARTHLY_TEST_BUILD_ARG", "env")
+	defer os.Unsetenv("EARTHLY_TEST_BUILD_ARG")
+	dotEnv := map[string]string{"A": "dotenv", "B": "dotenv"}
+	var tests = []struct {
+		name     string
+		args     []string
+		strict   bool
+		expected map[string]string
+		err      bool
+	}{
+		{"args override dotenv", []string{"A=arg"}, false, map[string]string{"A": "arg", "B": "dotenv"}, false},
+		{"last arg wins", []string{"C=1", "C=2"}, false, map[string]string{"A": "dotenv", "B": "dotenv", "C": "2"}, false},
+		{"arg from env", []string{"EARTHLY_TEST_BUILD_ARG"}, false,
+			map[string]string{"A": "dotenv", "B": "dotenv", "EARTHLY_TEST_BUILD_ARG": "env"}, false},
+		{"strict without duplicates", []string{"C=1"}, true, map[string]string{"A": "dotenv", "B": "dotenv", "C": "1"}, false},
+		{"strict arg and dotenv", []string{"A=arg"}, true, nil, true},
+		{"strict args", []string{"C=1", "C=2"}, true, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCommandLineBuildArgs(tt.args, dotEnv, tt.strict)
+			if tt.err {
+				Error(t, err)
+				return
+			}
+			NoError(t, err)
+			actual := make(map[string]string)
+			for k, v := range c.variables {
+				actual[k] = v.ConstantValue()
+			}
+			Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestSourceDateEpochBuiltinArg(t *testing.T) {
+	var tests = []struct {
+		name            string
+		gitMeta         *gitutil.GitMetadata
+		sourceDateEpoch string
+		expected        string
+	}{
+		{"no git", nil, "", "0"},
+		{"git commit timestamp", &gitutil.GitMetadata{Timestamp: "1612137600"}, "", "1612137600"},
+		{"explicit over git", &gitutil.GitMetadata{Timestamp: "1612137600"}, "1500000000", "1500000000"},
+		{"explicit without git", nil, "1500000000", "1500000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := domain.Target{LocalPath: ".", Target: "foo"}
+			platform := specs.Platform{OS: "linux", Architecture: "amd64"}
+			c := NewCollection().WithBuiltinBuildArgs(target, platform, tt.gitMeta, tt.sourceDateEpoch)
+			v, _, found := c.Get("SOURCE_DATE_EPOCH")
+			True(t, found)
+			Equal(t, tt.expected, v.ConstantValue())
+		})
+	}
+}
+
+func TestReadBuildArgFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "earthly-variables-test")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	versionPath := filepath.Join(dir, "version")
+	NoError(t, ioutil.WriteFile(versionPath, []byte("1.2.3\n"), 0644))
+	notesPath := filepath.Join(dir, "notes")
+	NoError(t, ioutil.WriteFile(notesPath, []byte("line 1\r\nline 2\r\n\r\n"), 0644))
+
+	var tests = []struct {
+		name        string
+		argFiles    []string
+		keepNewline bool
+		expected    []string
+	}{
+		{"trailing new line", []string{"VERSION=" + versionPath}, false, []string{"VERSION=1.2.3"}},
+		{"keep new line", []string{"VERSION=" + versionPath}, true, []string{"VERSION=1.2.3\n"}},
+		{"multi-line", []string{"NOTES=" + notesPath}, false, []string{"NOTES=line 1\r\nline 2\r\n"}},
+		{"missing file", []string{"VERSION=" + filepath.Join(dir, "missing")}, false, nil},
+		{"no path", []string{"VERSION"}, false, nil},
+		{"empty path", []string{"VERSION="}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := ReadBuildArgFiles(tt.argFiles, tt.keepNewline)
+			if tt.expected == nil {
+				Error(t, err)
+				return
+			}
+			NoError(t, err)
+			Equal(t, tt.expected, args)
+		})
+	}
+}